@@ -0,0 +1,318 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// apiChanged returns true when the exported API of the package in dir differs
+// from its exported API before files, the names of the changed files in dir,
+// were changed. It also returns true when the exported API before the change
+// cannot be determined.
+func apiChanged(bd BaseDiffer, dir string, files []string) (bool, error) {
+	diskFiles, err := apiFilesIn(dir)
+	if err != nil {
+		return false, err
+	}
+
+	head := make(map[string][]byte, len(diskFiles))
+	for _, fn := range diskFiles {
+		b, err := os.ReadFile(filepath.Join(dir, fn))
+		if err != nil {
+			return false, err
+		}
+		head[fn] = b
+	}
+
+	base := make(map[string][]byte, len(head))
+	for fn, b := range head {
+		base[fn] = b
+	}
+	for _, fn := range files {
+		if !isAPIFile(fn) {
+			continue
+		}
+
+		b, err := bd.BaseFile(filepath.Join(dir, fn))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			delete(base, fn)
+		case errors.Is(err, ErrNoBase):
+			return true, nil
+		case err != nil:
+			return false, err
+		default:
+			base[fn] = b
+		}
+	}
+
+	headAPI, err := exportedAPI(head)
+	if err != nil {
+		return true, nil
+	}
+
+	baseAPI, err := exportedAPI(base)
+	if err != nil {
+		return true, nil
+	}
+
+	return headAPI != baseAPI, nil
+}
+
+// apiDecl is a package-level declaration that may be part of a package's
+// exported API.
+type apiDecl struct {
+	// desc describes the declaration.
+	desc string
+	// nodes are the parts of the declaration whose identifiers may refer to
+	// other package-level declarations that are part of the API.
+	nodes []ast.Node
+}
+
+// exportedAPI returns a description of the exported declarations, including
+// the values of exported constants, and the init functions in files, a map of
+// file names to their contents. The unexported declarations that the exported
+// declarations refer to, directly or through other unexported declarations,
+// are described too, with the exported methods of the unexported types among
+// them, because changing them changes the exported API (e.g. the fields of t
+// change the fields of T in type T t). Two packages with the same exported API
+// and init functions have the same description.
+func exportedAPI(files map[string][]byte) (string, error) {
+	fset := token.NewFileSet()
+
+	format := func(node interface{}) (string, error) {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, node); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	// named maps the names of the package-level declarations to them, and
+	// methods maps the names of types to their exported methods.
+	named := make(map[string]apiDecl)
+	methods := make(map[string][]apiDecl)
+	var inits []string
+
+	for fn, src := range files {
+		f, err := parser.ParseFile(fset, fn, src, 0)
+		if err != nil {
+			return "", err
+		}
+
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				// the side effects of init functions are observable by the
+				// package's importers, so their bodies are part of the API.
+				if decl.Recv == nil && decl.Name.Name == "init" {
+					s, err := format(decl)
+					if err != nil {
+						return "", err
+					}
+					inits = append(inits, s)
+					continue
+				}
+
+				fd := *decl
+				fd.Body = nil
+				s, err := format(&fd)
+				if err != nil {
+					return "", err
+				}
+
+				d := apiDecl{desc: s, nodes: []ast.Node{decl.Type}}
+				switch {
+				case decl.Recv != nil:
+					if decl.Name.IsExported() {
+						typ := receiverTypeName(decl.Recv)
+						methods[typ] = append(methods[typ], d)
+					}
+				default:
+					named[decl.Name.Name] = d
+				}
+			case *ast.GenDecl:
+				// the values of constants are part of the API; a spec without
+				// values repeats the values of the previous spec at its own
+				// iota.
+				var values []ast.Expr
+				var valuesType ast.Expr
+				for idx, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						s, err := format(spec)
+						if err != nil {
+							return "", err
+						}
+						named[spec.Name.Name] = apiDecl{desc: "type " + s, nodes: []ast.Node{spec}}
+					case *ast.ValueSpec:
+						typExpr := spec.Type
+						if decl.Tok == token.CONST {
+							if len(spec.Values) > 0 {
+								values, valuesType = spec.Values, spec.Type
+							} else {
+								typExpr = valuesType
+							}
+						}
+
+						var typ string
+						if typExpr != nil {
+							typ, err = format(typExpr)
+							if err != nil {
+								return "", err
+							}
+						}
+
+						for i, name := range spec.Names {
+							d := apiDecl{desc: strings.TrimSpace(fmt.Sprintf("%s %s %s", decl.Tok, name.Name, typ))}
+							if typExpr != nil {
+								d.nodes = append(d.nodes, typExpr)
+							}
+							if decl.Tok == token.CONST && i < len(values) {
+								v, err := format(values[i])
+								if err != nil {
+									return "", err
+								}
+								d.desc += " = " + v
+								if usesIota(values[i]) {
+									d.desc += fmt.Sprintf(" (iota %d)", idx)
+								}
+								d.nodes = append(d.nodes, values[i])
+							}
+							named[name.Name] = d
+						}
+					}
+				}
+			}
+		}
+	}
+
+	decls := inits
+	seen := make(map[string]struct{})
+	var include func(name string)
+	include = func(name string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+
+		d, ok := named[name]
+		if !ok {
+			return
+		}
+
+		for _, d := range append([]apiDecl{d}, methods[name]...) {
+			decls = append(decls, d.desc)
+			for _, node := range d.nodes {
+				referencedNames(node, include)
+			}
+		}
+	}
+
+	for name := range named {
+		if ast.IsExported(name) {
+			include(name)
+		}
+	}
+
+	sort.Strings(decls)
+	return strings.Join(decls, "\n"), nil
+}
+
+// referencedNames calls f with the identifiers in node that may refer to
+// package-level declarations. The names of fields, parameters and results,
+// and the selected names of selector expressions, are not passed to f.
+func referencedNames(node ast.Node, f func(name string)) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			referencedNames(n.Type, f)
+			return false
+		case *ast.SelectorExpr:
+			referencedNames(n.X, f)
+			return false
+		case *ast.Ident:
+			f(n.Name)
+		}
+		return true
+	})
+}
+
+// usesIota returns true when expr refers to iota.
+func usesIota(expr ast.Expr) bool {
+	var found bool
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// receiverTypeName returns the name of the type of the receiver in recv, or an
+// empty string when it is not known.
+func receiverTypeName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+
+	typ := recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// isAPIFile returns true when fn is the name of a Go file that may contribute
+// to a package's exported API.
+func isAPIFile(fn string) bool {
+	return filepath.Ext(fn) == ".go" && !strings.HasSuffix(fn, "_test.go")
+}
+
+// apiFilesIn returns the names of the Go files in dir that may contribute to
+// the exported API of its package. It returns an empty slice when dir does
+// not exist.
+func apiFilesIn(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isAPIFile(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import "testing"
+
+func TestExportedAPI(t *testing.T) {
+	const src = `package foo
+
+import "io"
+
+const C, c = 1, 2
+
+var V io.Reader
+
+type T struct{ r io.Reader }
+
+type t struct{}
+
+func (T) M() {}
+
+func (*T) m() {}
+
+func (t) M() {}
+
+func F(r io.Reader) error { return nil }
+`
+
+	tests := []struct {
+		desc string
+		src  string
+		same bool
+	}{
+		{
+			desc: "package name and unexported declarations",
+			src: `package bar
+
+import "io"
+
+const C = 1
+
+var V io.Reader
+
+type T struct{ r io.Reader }
+
+func (T) M() {}
+
+func F(r io.Reader) error {
+	return helper()
+}
+
+func helper() error { return nil }
+`,
+			same: true,
+		},
+		{
+			desc: "changed constant value",
+			src: `package foo
+
+import "io"
+
+const C = 3
+
+var V io.Reader
+
+type T struct{ r io.Reader }
+
+func (T) M() {}
+
+func F(r io.Reader) error { return nil }
+`,
+		},
+		{
+			desc: "changed signature",
+			src: `package foo
+
+import "io"
+
+const C = 1
+
+var V io.Reader
+
+type T struct{ r io.Reader }
+
+func (T) M() {}
+
+func F(r io.Reader) {}
+`,
+		},
+	}
+
+	want, err := exportedAPI(map[string][]byte{"foo.go": []byte(src)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := exportedAPI(map[string][]byte{"foo.go": []byte(tt.src)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if same := got == want; same != tt.same {
+				t.Errorf("same = %v; want %v\n%s\n\n%s", same, tt.same, want, got)
+			}
+		})
+	}
+}
+
+func TestExportedAPI_UnexportedDeclarations(t *testing.T) {
+	tests := []struct {
+		desc       string
+		base, head string
+		same       bool
+	}{
+		{
+			desc: "constant referring to a changed constant",
+			base: "package foo\n\nconst X = x\n\nconst x = 1\n",
+			head: "package foo\n\nconst X = x\n\nconst x = 2\n",
+		},
+		{
+			desc: "type defined by a changed type",
+			base: "package foo\n\ntype T t\n\ntype t struct{ N int }\n",
+			head: "package foo\n\ntype T t\n\ntype t struct{ N string }\n",
+		},
+		{
+			desc: "changed method of an unexported type returned by a function",
+			base: "package foo\n\nfunc New() *t { return nil }\n\ntype t struct{}\n\nfunc (*t) M() {}\n",
+			head: "package foo\n\nfunc New() *t { return nil }\n\ntype t struct{}\n\nfunc (*t) M() error { return nil }\n",
+		},
+		{
+			desc: "changed unexported method of an unexported type returned by a function",
+			base: "package foo\n\nfunc New() *t { return nil }\n\ntype t struct{}\n\nfunc (*t) m() {}\n",
+			head: "package foo\n\nfunc New() *t { return nil }\n\ntype t struct{}\n\nfunc (*t) m() error { return nil }\n",
+			same: true,
+		},
+		{
+			desc: "changed unreferenced type",
+			base: "package foo\n\ntype T struct{}\n\ntype t struct{ N int }\n",
+			head: "package foo\n\ntype T struct{}\n\ntype t struct{ N string }\n",
+			same: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			base, err := exportedAPI(map[string][]byte{"foo.go": []byte(tt.base)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			head, err := exportedAPI(map[string][]byte{"foo.go": []byte(tt.head)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if same := base == head; same != tt.same {
+				t.Errorf("same = %v; want %v\n%s\n\n%s", same, tt.same, base, head)
+			}
+		})
+	}
+}
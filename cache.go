@@ -0,0 +1,112 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Cache stores values by key so that they can be reused between runs. A Cache
+// may be backed by anything (e.g. the local disk, Redis or S3); values that
+// cannot be retrieved or stored only cause them to be computed again.
+type Cache interface {
+	// Get returns the value stored for key. The boolean is false when no value
+	// is stored for key.
+	Get(key string) ([]byte, bool)
+	// Put stores value for key.
+	Put(key string, value []byte)
+}
+
+// NewFileCache returns a Cache that stores each value in its own file in the
+// directory dir. The directory is created when it does not exist.
+func NewFileCache(dir string) Cache {
+	return &fileCache{dir: dir}
+}
+
+// fileCache implements Cache with a file for each key.
+type fileCache struct {
+	dir string
+}
+
+// Get implements the Cache interface.
+func (c *fileCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Put implements the Cache interface.
+func (c *fileCache) Put(key string, value []byte) {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return
+	}
+	_ = writeFileAtomic(c.path(key), value)
+}
+
+// path returns the path of the file of key. Keys are hashed so that they can
+// contain any character.
+func (c *fileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// singleFileCache implements Cache with a single file, fn, that holds the
+// value of the key that was stored most recently.
+type singleFileCache struct {
+	fn string
+}
+
+// Get implements the Cache interface.
+func (c *singleFileCache) Get(key string) ([]byte, bool) {
+	b, err := os.ReadFile(c.fn)
+	if err != nil {
+		return nil, false
+	}
+
+	// the file starts with the quoted key on its own line.
+	storedKey, value, ok := bytes.Cut(b, []byte("\n"))
+	if !ok || string(storedKey) != strconv.Quote(key) {
+		return nil, false
+	}
+	return value, true
+}
+
+// Put implements the Cache interface.
+func (c *singleFileCache) Put(key string, value []byte) {
+	b := strconv.AppendQuote(nil, key)
+	b = append(b, '\n')
+	b = append(b, value...)
+	_ = writeFileAtomic(c.fn, b)
+}
+
+// writeFileAtomic writes b to fn. b is written to a temporary file that is
+// renamed to fn so that a concurrent reader never sees a partially written
+// file.
+func writeFileAtomic(fn string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fn)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// memCache is a Cache that holds its values in memory and counts the hits and
+// misses of Get.
+type memCache struct {
+	values       map[string][]byte
+	hits, misses int
+}
+
+func newMemCache() *memCache {
+	return &memCache{values: make(map[string][]byte)}
+}
+
+func (c *memCache) Get(key string) ([]byte, bool) {
+	value, ok := c.values[key]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return value, ok
+}
+
+func (c *memCache) Put(key string, value []byte) {
+	c.values[key] = value
+}
+
+func TestCaches(t *testing.T) {
+	tests := []struct {
+		desc string
+		c    Cache
+		// keepsAll is true when the cache keeps the values of all of the keys,
+		// not only the most recent one.
+		keepsAll bool
+	}{
+		{
+			desc:     "memory",
+			c:        newMemCache(),
+			keepsAll: true,
+		},
+		{
+			desc:     "file",
+			c:        NewFileCache(filepath.Join(t.TempDir(), "cache")),
+			keepsAll: true,
+		},
+		{
+			desc: "single file",
+			c:    &singleFileCache{fn: filepath.Join(t.TempDir(), "graph")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			get := func(key string, wantValue string, wantOK bool) {
+				t.Helper()
+
+				value, ok := tt.c.Get(key)
+				if ok != wantOK || string(value) != wantValue {
+					t.Errorf("Get(%q) = %q, %t; want %q, %t", key, value, ok, wantValue, wantOK)
+				}
+			}
+
+			get("a", "", false)
+
+			tt.c.Put("a", []byte("1"))
+			get("a", "1", true)
+
+			tt.c.Put("b/c\n", []byte("2\n3"))
+			get("b/c\n", "2\n3", true)
+			if tt.keepsAll {
+				get("a", "1", true)
+			} else {
+				get("a", "", false)
+			}
+
+			tt.c.Put("a", []byte("4"))
+			get("a", "4", true)
+		})
+	}
+}
@@ -11,96 +11,487 @@ in the LICENSE file.
 package main
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
 
 	"github.com/digitalocean/gta"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// formats are the built-in templates that can be passed to -format by name.
+var formats = map[string]string{
+	"gotest": `{{with .AllChanges}}go test{{range .}}{{if .Dir}} {{.ImportPath}}{{end}}{{end}}{{"\n"}}{{end}}`,
+	"lines":  `{{range .AllChanges}}{{.ImportPath}}{{"\n"}}{{end}}`,
+	"turbo":  `{{range .AllChanges}}{{with .Dir}}--filter={{turboName .}}{{"\n"}}{{end}}{{end}}`,
+}
+
+// formatFuncs are the functions that can be used in the templates passed to
+// -format.
+var formatFuncs = template.FuncMap{
+	"turboName": turboName,
+}
+
+// turboName returns the name that identifies the package in dir in Turborepo
+// filters. A package is identified by its directory relative to the root of
+// the repository, which is expected to be the current directory, starting with
+// ./ (e.g. ./services/api). The root of the repository itself is identified
+// by //, the name of Turborepo's root workspace.
+func turboName(dir string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == "." {
+		return "//", nil
+	}
+
+	return "./" + filepath.ToSlash(rel), nil
+}
+
+// exitNoChanges is the exit status when no packages changed and -exit-code
+// is set. Like grep, which exits with status 1 when nothing matched, it is 1.
+const exitNoChanges = 1
+
+// exitError is the exit status when gta fails. It differs from exitNoChanges
+// so that a failure is not mistaken for the absence of changes.
+const exitError = 2
+
+// exitTooManyChanges is the exit status when more packages changed than
+// allowed by -max-packages.
+const exitTooManyChanges = 3
+
+// fatal is like log.Fatal, but exits with exitError.
+func fatal(v ...any) {
+	log.Output(2, fmt.Sprint(v...))
+	os.Exit(exitError)
+}
+
+// fatalf is like log.Fatalf, but exits with exitError.
+func fatalf(format string, v ...any) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(exitError)
+}
+
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ltime)
 	flagBase := flag.String("base", "origin/master", "base, branch to diff against")
+	flagBases := flag.String("bases", "", "a comma separated list of candidate base branches (e.g. origin/main,origin/release-1.0), of which the nearest to HEAD is diffed against instead of -base")
+	flagBaseFile := flag.String("base-file", "", "read the revision to diff against (e.g. the last commit that was built successfully) from a file instead of using -base")
 	flagInclude := flag.String("include", "", "define changes to be filtered with a set of comma separated prefixes")
+	flagScopedLoad := flag.Bool("scoped-load", false, "only load the packages below the -include prefixes instead of all packages; faster, but changes imported through files excluded by build constraints are missed")
+	flagIncludeRegexp := flag.String("include-regexp", "", "define changes to be filtered with a regular expression matching import paths, in addition to the -include prefixes")
+	flagExclude := flag.String("exclude", "", "define changes to be excluded with a set of comma separated prefixes, in addition to the prefixes in the .gtaignore file")
+	flagIgnore := flag.String("ignore", "", "a comma separated list of glob patterns of the names of files whose changes are ignored (e.g. '*.pb.go')")
+	flagIgnoreTests := flag.Bool("ignore-tests", false, "ignore the changes to _test.go files")
+	flagStrip := flag.String("strip", "", "an import path prefix to remove from the import paths of the changed packages (e.g. github.com/acme/monorepo to print services/x instead of github.com/acme/monorepo/services/x)")
+	flagModules := flag.String("modules", "", "a comma separated list of the directories of the modules whose packages are analyzed instead of all packages")
 	flagMerge := flag.Bool("merge", false, "diff using the latest merge commit")
 	flagJSON := flag.Bool("json", false, "output list of changes as json")
+	flagJSONFull := flag.Bool("json-full", false, "output list of changes as json where each package is an object with its import path and directory")
+	flagJSONL := flag.Bool("jsonl", false, "output each changed package as a json object with its import path, directory and whether it changed or is a dependent on its own line")
 	flagBuildableOnly := flag.Bool("buildable-only", true, "keep buildable changed packages only")
-	flagChangedFiles := flag.String("changed-files", "", "path to a file containing a newline separated list of files that have changed")
-	flagTags := flag.String("tags", "", "a list of build tags to consider")
+	flagDirs := flag.Bool("dirs", false, "print the absolute directories of the changed packages instead of their import paths; deleted packages are omitted")
+	flagChangedFiles := flag.String("changed-files", "", "path to a file containing a newline separated list of files that have changed; - reads the list from stdin")
+	flagChangedFilesRoot := flag.String("changed-files-root", "", "the directory against which the relative paths of -changed-files are resolved; by default, the root of the git repository, or the current directory outside of one")
+	flagTags := flag.String("tags", "", "a comma or space separated list of build tags to consider")
+	flagBuildFlags := flag.String("build-flags", "", "a space separated list of flags to pass to the go command when loading packages (e.g. '-mod=mod'); the tags of a -tags flag are added to -tags")
+	flagCGO := flag.String("cgo", "", "whether cgo is enabled when loading packages (true or false); by default, CGO_ENABLED is respected")
 	flagHeadToHead := flag.Bool("h2h", false, "diff using the HEAD of the base branch and the HEAD of the current branch")
+	flagResolveSymlinks := flag.Bool("resolve-symlinks", false, "resolve symbolic links in the directories of the changed files")
+	flagWorkingTree := flag.Bool("working-tree", false, "include uncommitted changes and untracked files")
+	flagIgnoreWhitespace := flag.Bool("ignore-whitespace", false, "ignore the files whose only changes are to whitespace")
+	flagMergeParent := flag.Int("merge-parent", 0, "the parent of the merge commit whose changes are included when used with -merge (e.g. 2 for HEAD^2); all of them are included by default")
+	flagPathspec := flag.String("pathspec", "", "a comma separated list of git pathspecs, relative to the root of the repository, that limit the changed files")
+	flagFetchBase := flag.Bool("fetch-base", false, "fetch the -base remote-tracking branch (e.g. origin/main) from its remote when it does not exist locally")
+	flagUnshallow := flag.Bool("unshallow", false, "fetch the full history when the repository is a shallow clone that does not contain the branch point")
+	flagFormat := flag.String("format", "", fmt.Sprintf("a text/template executed against the changed packages (e.g. '{{range .AllChanges}}{{.ImportPath}} {{end}}') or the name of a built-in template (%s)", strings.Join(formatNames(), ", ")))
+	flagCollapse := flag.Bool("collapse", false, "replace the changed packages by a single import path pattern ending with /... when all of the packages below the import path changed")
+	flagDirectories := flag.Bool("directories", false, "include the changed directories and their changed files in the json output")
+	flagMoves := flag.Bool("moves", false, "include the deleted and added packages with the same exported API, which were likely moved, in the json output")
+	flagChangedLines := flag.Bool("changed-lines", false, "include the number of lines that were added or deleted in the directory of each package in the json-full and jsonl output")
+	flagGroupBy := flag.String("group-by", "", "print the changed packages grouped by their owners instead of the changed packages; the only supported value is owners, which uses the CODEOWNERS file")
+	flagCodeowners := flag.String("codeowners", "", "path of the CODEOWNERS file used by -group-by owners; by default, it is looked for in the root, .github and docs directories of the repository")
+	flagExplain := flag.Bool("explain", false, "print the reason each changed package was changed instead of the changed packages")
+	flagTestOnly := flag.Bool("test-only", false, "include the changed packages that are only affected through _test.go files in the json output")
+	flagGitattributes := flag.Bool("gitattributes", false, "do not mark the dependents of packages whose only changes are to files marked linguist-generated in .gitattributes")
+	flagGoSum := flag.Bool("gosum", false, "mark the packages of modules whose checksums changed in go.sum files as changed")
+	flagAPI := flag.Bool("api", false, "only mark the dependents of changed packages whose exported API changed")
+	flagGoModWholeModule := flag.Bool("gomod-whole-module", false, "mark every package of a module as changed when its go.mod changed")
+	flagGodebugMains := flag.Bool("godebug-mains", false, "mark the main packages of a module as changed when the godebug settings of its go.mod changed")
+	flagPropagateTestImports := flag.Bool("propagate-test-imports", true, "propagate changes through imports from _test.go files; when false, packages that import a marked package only from _test.go files are marked without their dependents")
+	flagMaxDepth := flag.Int("max-depth", -1, "only mark the dependents that are at most this many imports away from a changed package; negative values mark all dependents")
+	flagCache := flag.String("cache", "", "path of a file in which to cache the dependency graph between runs")
+	flagIncludeUnbuildable := flag.Bool("include-unbuildable", false, "include the changed packages whose Go files cannot be parsed")
+	flagStrict := flag.Bool("strict", false, "fail when packages cannot be loaded (e.g. because a file cannot be parsed) instead of logging the errors")
+	flagValidateInternal := flag.Bool("validate-internal", false, "fail when the dependency graph contains imports of internal packages that the go tool does not allow")
+	flagGitHubOutput := flag.Bool("github-output", false, "append the changed packages and their count to the GitHub Actions step output file named by GITHUB_OUTPUT")
+	flagStats := flag.Bool("stats", false, "print the numbers of changed files, deleted files and modules whose checksums changed in go.sum files to stderr")
+	flagDebug := flag.Bool("debug", false, "log diagnostics about how the changed packages are determined to stderr")
+	flagMaxPackages := flag.Int("max-packages", 0, fmt.Sprintf("fail with exit status %d when more packages changed than this maximum; 0 does not limit the number of changed packages", exitTooManyChanges))
+	flagExitCode := flag.Bool("exit-code", false, fmt.Sprintf("exit with status 0 when there are changed packages and status %d when there are none; failures exit with status %d and output is not affected", exitNoChanges, exitError))
 
 	flag.Parse()
 
+	if *flagJSON && *flagJSONFull {
+		fatal("-json and -json-full cannot be used together")
+	}
+
+	if *flagJSONL && (*flagJSON || *flagJSONFull) {
+		fatal("-jsonl cannot be used together with -json or -json-full")
+	}
+
 	if *flagJSON && *flagBuildableOnly {
-		log.Fatal("-buildable-only must be set to false when using -json")
+		fatal("-buildable-only must be set to false when using -json")
+	}
+
+	if *flagJSONFull && *flagBuildableOnly {
+		fatal("-buildable-only must be set to false when using -json-full")
+	}
+
+	if *flagJSONL && *flagBuildableOnly {
+		fatal("-buildable-only must be set to false when using -jsonl")
 	}
 
 	if *flagMerge && len(*flagChangedFiles) > 0 {
-		log.Fatal("changed files must not be provided when using the latest merge commit")
+		fatal("changed files must not be provided when using the latest merge commit")
+	}
+
+	if *flagBases != "" && *flagBaseFile != "" {
+		fatal("-bases and -base-file cannot be used together")
 	}
 
 	if *flagMerge && *flagHeadToHead {
-		log.Fatal("-merge and -h2h cannot be used together")
+		fatal("-merge and -h2h cannot be used together")
 	}
 
 	if *flagHeadToHead && len(*flagChangedFiles) > 0 {
-		log.Fatal("-changed-files and -h2h cannot be used together")
+		fatal("-changed-files and -h2h cannot be used together")
+	}
+
+	if *flagDirectories && !*flagJSON && !*flagJSONFull {
+		fatal("-directories can only be used together with -json or -json-full")
+	}
+
+	if *flagMoves && !*flagJSON && !*flagJSONFull {
+		fatal("-moves can only be used together with -json or -json-full")
+	}
+
+	if *flagTestOnly && !*flagJSON && !*flagJSONFull {
+		fatal("-test-only can only be used together with -json or -json-full")
+	}
+
+	if (*flagJSON || *flagJSONFull) && len(*flagFormat) > 0 {
+		fatal("-json and -json-full cannot be used together with -format")
 	}
 
-	var tags []string
-	for _, v := range parseStringSlice(*flagTags) {
-		tags = append(tags, strings.Fields(v)...)
+	if *flagJSONL && len(*flagFormat) > 0 {
+		fatal("-jsonl cannot be used together with -format")
+	}
+
+	if *flagCollapse && (*flagJSON || *flagJSONFull || *flagJSONL || len(*flagFormat) > 0) {
+		fatal("-collapse cannot be used together with -json, -json-full, -jsonl or -format")
+	}
+
+	if *flagDirs && (*flagJSON || *flagJSONFull || *flagJSONL || len(*flagFormat) > 0 || *flagCollapse) {
+		fatal("-dirs cannot be used together with -json, -json-full, -jsonl, -format or -collapse")
+	}
+
+	if *flagStrip != "" && *flagCollapse {
+		fatal("-strip cannot be used together with -collapse")
+	}
+
+	if *flagGroupBy != "" && *flagGroupBy != "owners" {
+		fatalf("invalid -group-by value %q; the only supported value is owners", *flagGroupBy)
+	}
+
+	if *flagGroupBy != "" && (*flagExplain || *flagJSON || *flagJSONFull || *flagJSONL || len(*flagFormat) > 0 || *flagCollapse) {
+		fatal("-group-by cannot be used together with -explain, -json, -json-full, -jsonl, -format or -collapse")
+	}
+
+	githubOutput := os.Getenv("GITHUB_OUTPUT")
+	if *flagGitHubOutput && githubOutput == "" {
+		fatal("-github-output requires GITHUB_OUTPUT to be set")
+	}
+
+	var tmpl *template.Template
+	if len(*flagFormat) > 0 {
+		var err error
+		tmpl, err = parseFormat(*flagFormat)
+		if err != nil {
+			fatalf("can't parse format: %v", err)
+		}
 	}
 
 	options := []gta.Option{
 		gta.SetPrefixes(parseStringSlice(*flagInclude)...),
-		gta.SetTags(tags...),
+		gta.SetExcludes(parseStringSlice(*flagExclude)...),
+		gta.SetScopedLoad(*flagScopedLoad),
+		gta.SetTags(*flagTags),
+		gta.SetBuildFlags(strings.Fields(*flagBuildFlags)...),
+		gta.SetUseGitattributes(*flagGitattributes),
+		gta.SetIncludeDirectories(*flagDirectories),
+		gta.SetIncludeReasons(*flagExplain),
+		gta.SetIncludeChangedLines(*flagChangedLines),
+		gta.SetUseGoSum(*flagGoSum),
+		gta.SetDetectMoves(*flagMoves),
+		gta.SetIncludeTestOnlyChanges(*flagTestOnly),
+		gta.SetAPIChangeDetection(*flagAPI),
+		gta.SetGoModChangesWholeModule(*flagGoModWholeModule),
+		gta.SetGodebugChangesMains(*flagGodebugMains),
+		gta.SetGraphCache(*flagCache),
+		gta.SetMaxDepth(*flagMaxDepth),
+		gta.SetPropagateThroughTestImports(*flagPropagateTestImports),
+		gta.SetStrict(*flagStrict),
+		gta.SetStripPrefix(*flagStrip),
+		gta.SetMaxChangedPackages(*flagMaxPackages),
+		gta.SetValidateInternal(*flagValidateInternal),
+		gta.SetIncludeUnbuildable(*flagIncludeUnbuildable),
+		gta.SetModules(parseStringSlice(*flagModules)...),
+		gta.SetIgnoreFilePatterns(parseStringSlice(*flagIgnore)...),
+		gta.SetIgnoreTestFiles(*flagIgnoreTests),
+	}
+
+	if *flagIncludeRegexp != "" {
+		re, err := regexp.Compile(*flagIncludeRegexp)
+		if err != nil {
+			fatalf("invalid -include-regexp value %q: %v", *flagIncludeRegexp, err)
+		}
+		options = append(options, gta.SetIncludeRegexp(re))
+	}
+
+	if *flagCGO != "" {
+		cgo, err := strconv.ParseBool(*flagCGO)
+		if err != nil {
+			fatalf("invalid -cgo value %q: %v", *flagCGO, err)
+		}
+		options = append(options, gta.SetCGO(cgo))
 	}
 
+	// the packages that could not be loaded are always reported.
+	level := slog.LevelWarn
+	if *flagDebug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	options = append(options, gta.SetLogger(logger))
+
+	var differ gta.Differ
 	if len(*flagChangedFiles) == 0 {
 		// override the differ to use the git differ instead.
 		gitDifferOptions := []gta.GitDifferOption{
 			gta.SetBaseBranch(*flagBase),
 			gta.SetUseMergeCommit(*flagMerge),
+			gta.SetMergeParentIndex(*flagMergeParent),
 			gta.SetUseHeadToHead(*flagHeadToHead),
+			gta.SetResolveSymlinks(*flagResolveSymlinks),
+			gta.SetIncludeWorkingTree(*flagWorkingTree),
+			gta.SetAutoUnshallow(*flagUnshallow),
+			gta.SetFetchBase(*flagFetchBase),
+			gta.SetIgnoreWhitespaceChanges(*flagIgnoreWhitespace),
+			gta.SetPathspec(parseStringSlice(*flagPathspec)...),
+		}
+		if *flagBases != "" {
+			gitDifferOptions = append(gitDifferOptions, gta.SetBaseBranches(parseStringSlice(*flagBases)...))
 		}
-		options = append(options, gta.SetDiffer(gta.NewGitDiffer(gitDifferOptions...)))
+		if *flagBaseFile != "" {
+			gitDifferOptions = append(gitDifferOptions, gta.SetBaseFromFile(*flagBaseFile))
+		}
+		differ = gta.NewGitDiffer(gitDifferOptions...)
 	} else {
-		sl, err := changedFiles(*flagChangedFiles)
+		sl, err := changedFiles(*flagChangedFiles, os.Stdin)
 		if err != nil {
-			log.Fatal(fmt.Errorf("could not read changed file list: %w", err))
+			fatal(fmt.Errorf("could not read changed file list: %w", err))
 		}
-		options = append(options, gta.SetDiffer(gta.NewFileDiffer(sl)))
+		root, err := changedFilesRoot(*flagChangedFilesRoot)
+		if err != nil {
+			fatalf("can't resolve the root of the changed files: %v", err)
+		}
+		differ = gta.NewFileDifferWithRoot(root, sl)
 	}
+	options = append(options, gta.SetDiffer(differ))
 
 	gt, err := gta.New(options...)
 	if err != nil {
-		log.Fatalf("can't prepare gta: %v", err)
+		fatalf("can't prepare gta: %v", err)
 	}
 
 	packages, err := gt.ChangedPackages()
+	if errors.Is(err, gta.ErrTooManyChanges) {
+		log.Print(err)
+		os.Exit(exitTooManyChanges)
+	}
 	if err != nil {
-		log.Fatalf("can't list dirty packages: %v", err)
+		fatalf("can't list dirty packages: %v", err)
+	}
+
+	if *flagStats {
+		if err := printStats(os.Stderr, differ); err != nil {
+			fatalf("can't summarize changes: %v", err)
+		}
 	}
 
-	if *flagJSON {
-		err = json.NewEncoder(os.Stdout).Encode(packages)
+	switch {
+	case *flagExplain:
+		printReasons(os.Stdout, packages)
+	case *flagGroupBy == "owners":
+		fn := *flagCodeowners
+		if fn == "" {
+			wd, err := os.Getwd()
+			if err != nil {
+				fatal(err)
+			}
+			fn, err = findCodeowners(wd)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		groups, err := gta.GroupByCodeowners(packages.AllChanges, fn)
 		if err != nil {
-			log.Fatal(err)
+			fatalf("can't group packages by owners: %v", err)
 		}
-		return
+		printGroups(os.Stdout, groups)
+	case *flagJSON:
+		_, err = packages.Formatted(gta.FormatJSON).WriteTo(os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+	case *flagJSONL:
+		_, err = packages.Formatted(gta.FormatJSONL).WriteTo(os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+	case *flagJSONFull:
+		b, err := packages.MarshalJSONFull()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(string(b))
+	case tmpl != nil:
+		err = tmpl.Execute(os.Stdout, packages)
+		if err != nil {
+			fatal(err)
+		}
+	default:
+		strung := stringify(packages.AllChanges, *flagBuildableOnly, *flagDirs)
+		if *flagCollapse {
+			strung, err = gt.CollapseToTrees(packages.AllChanges)
+			if err != nil {
+				fatalf("can't collapse packages: %v", err)
+			}
+		}
+
+		// stdin is not a terminal when the changed files are piped in, so
+		// consult stdout instead to decide how to print the packages.
+		fd := syscall.Stdin
+		if *flagChangedFiles == "-" {
+			fd = syscall.Stdout
+		}
+		printPackages(strung, terminal.IsTerminal(fd))
+	}
+
+	if *flagGitHubOutput {
+		err = writeGitHubOutput(githubOutput, stringify(packages.AllChanges, *flagBuildableOnly, *flagDirs))
+		if err != nil {
+			fatalf("can't write GitHub Actions output: %v", err)
+		}
+	}
+
+	if status := exitStatus(packages.AllChanges, *flagExitCode); status != 0 {
+		os.Exit(status)
+	}
+}
+
+// exitStatus returns the exit status of a run that found the changed packages
+// pkgs. It is only non-zero when exitCode is true and no packages changed.
+func exitStatus(pkgs []gta.Package, exitCode bool) int {
+	if exitCode && len(pkgs) == 0 {
+		return exitNoChanges
+	}
+	return 0
+}
+
+// printReasons writes the import path of each of the packages in the Changes
+// of p and the reason it was changed to w, one package per line.
+func printReasons(w io.Writer, p *gta.Packages) {
+	for _, pkg := range p.Changes {
+		fmt.Fprintf(w, "%s: %s\n", pkg.ImportPath, p.Reasons[pkg.ImportPath])
+	}
+}
+
+// printGroups writes each owner in groups followed by the import paths of its
+// packages to w, one owner per line. The packages without owners are written
+// last.
+func printGroups(w io.Writer, groups map[string][]gta.Package) {
+	owners := make([]string, 0, len(groups))
+	for owner := range groups {
+		if owner != "" {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	if _, ok := groups[""]; ok {
+		owners = append(owners, "")
 	}
 
-	strung := stringify(packages.AllChanges, *flagBuildableOnly)
+	for _, owner := range owners {
+		importPaths := make([]string, 0, len(groups[owner]))
+		for _, pkg := range groups[owner] {
+			importPaths = append(importPaths, pkg.ImportPath)
+		}
 
-	if terminal.IsTerminal(syscall.Stdin) {
+		name := owner
+		if name == "" {
+			name = "(unowned)"
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, strings.Join(importPaths, " "))
+	}
+}
+
+// findCodeowners returns the path of the CODEOWNERS file in the root, .github
+// or docs directory of the repository that contains dir. The root of the
+// repository is the closest ancestor of dir with a .git file or directory.
+func findCodeowners(dir string) (string, error) {
+	for {
+		for _, fn := range []string{
+			filepath.Join(dir, ".github", "CODEOWNERS"),
+			filepath.Join(dir, "CODEOWNERS"),
+			filepath.Join(dir, "docs", "CODEOWNERS"),
+		} {
+			if _, err := os.Stat(fn); err == nil {
+				return fn, nil
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || dir == filepath.Dir(dir) {
+			return "", errors.New("can't find a CODEOWNERS file; use -codeowners")
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func printPackages(strung []string, interactive bool) {
+	if interactive {
 		for _, pkg := range strung {
 			fmt.Println(pkg)
 		}
@@ -110,18 +501,119 @@ func main() {
 	fmt.Println(strings.Join(strung, " "))
 }
 
-func stringify(pkgs []gta.Package, validOnly bool) []string {
+// writeGitHubOutput appends the changed_packages and changed_count outputs for
+// pkgs to the GitHub Actions output file fn.
+func writeGitHubOutput(fn string, pkgs []string) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(f, gitHubOutput(pkgs, "ghadelimiter_"+hex.EncodeToString(b)))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// gitHubOutput returns the changed_packages and changed_count outputs for pkgs
+// in the format of the GitHub Actions output file. The packages are written as
+// a multiline value that ends with delim, so that the length of the list is
+// not a concern.
+func gitHubOutput(pkgs []string, delim string) string {
+	return fmt.Sprintf("changed_packages<<%s\n%s\n%s\nchanged_count=%d\n", delim, strings.Join(pkgs, " "), delim, len(pkgs))
+}
+
+// printStats writes the numbers of changed files, deleted files and changed
+// module dependencies reported by d to w.
+func printStats(w io.Writer, d gta.Differ) error {
+	sd, ok := d.(gta.StatsDiffer)
+	if !ok {
+		return fmt.Errorf("%T does not implement gta.StatsDiffer", d)
+	}
+
+	stats, err := sd.DiffStats()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "files changed: %d\nfiles deleted: %d\ngo.sum modules changed: %d\n", stats.FilesChanged, stats.FilesDeleted, stats.GoSumModulesChanged)
+	return err
+}
+
+// parseFormat parses s as a template. s may be the name of one of the
+// built-in formats.
+func parseFormat(s string) (*template.Template, error) {
+	if v, ok := formats[s]; ok {
+		s = v
+	}
+
+	return template.New("format").Funcs(formatFuncs).Parse(s)
+}
+
+func formatNames() []string {
+	var names []string
+	for k := range formats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stringify returns the import paths of pkgs, or their directories when dirs
+// is true. Deleted packages, whose Dir is empty, are omitted when validOnly or
+// dirs is true.
+func stringify(pkgs []gta.Package, validOnly, dirs bool) []string {
 	var out []string
 	for _, pkg := range pkgs {
-		if !validOnly || (validOnly && pkg.Dir != "") {
+		if (validOnly || dirs) && pkg.Dir == "" {
+			continue
+		}
+
+		if dirs {
+			out = append(out, pkg.Dir)
+		} else {
 			out = append(out, pkg.ImportPath)
 		}
 	}
 	return out
 }
 
-func changedFiles(fn string) ([]string, error) {
-	b, err := os.ReadFile(fn)
+// changedFilesRoot returns the directory against which the relative paths of
+// the changed files are resolved. It is root when root is not empty. Otherwise,
+// it is the root of the git repository, like the paths reported by git diff,
+// or the current directory when it is not in a git repository.
+func changedFilesRoot(root string) (string, error) {
+	if root != "" {
+		return filepath.Abs(root)
+	}
+
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return os.Getwd()
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// changedFiles returns the changed files listed in fn. When fn is -, the list
+// is read from stdin.
+func changedFiles(fn string, stdin io.Reader) ([]string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if fn == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = os.ReadFile(fn)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -133,11 +625,7 @@ func changedFiles(fn string) ([]string, error) {
 			continue
 		}
 
-		if !filepath.IsAbs(s) {
-			return nil, errors.New("all changed files paths must be absolute paths")
-		}
-
-		sl[n] = s
+		sl[n] = strings.TrimSpace(s)
 		n++
 	}
 
@@ -0,0 +1,358 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/gta"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChangedFiles(t *testing.T) {
+	const list = "foo/foo.go\r\n\n/bar/bar.go\n  \nbaz/baz_test.go\n"
+
+	fn := filepath.Join(t.TempDir(), "changed")
+	if err := os.WriteFile(fn, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"/src/foo/foo.go":      false,
+		"/bar/bar.go":          false,
+		"/src/baz/baz_test.go": false,
+	}
+
+	tests := []struct {
+		desc  string
+		fn    string
+		stdin func(t *testing.T) io.Reader
+	}{
+		{
+			desc: "file",
+			fn:   fn,
+		},
+		{
+			desc: "stdin",
+			fn:   "-",
+			stdin: func(t *testing.T) io.Reader {
+				r, w, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { r.Close() })
+
+				go func() {
+					io.WriteString(w, list)
+					w.Close()
+				}()
+
+				return r
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var stdin io.Reader
+			if tt.stdin != nil {
+				stdin = tt.stdin(t)
+			}
+
+			sl, err := changedFiles(tt.fn, stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := gta.NewFileDifferWithRoot("/src", sl).DiffFiles()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChangedFilesRoot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// Resolve symbolic links, because git reports the top level directory
+	// with them resolved (e.g. /private/var instead of /var on macOS).
+	repo, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := exec.Command("git", "init", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+
+	sub := filepath.Join(repo, "services", "api")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+
+	root, err := changedFilesRoot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != repo {
+		t.Errorf("changedFilesRoot(%q) = %q; want %q", "", root, repo)
+	}
+
+	got, err := gta.NewFileDifferWithRoot(root, []string{"services/api/api.go"}).DiffFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{filepath.Join(sub, "api.go"): false}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+
+	root, err = changedFilesRoot("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(repo, "services"); root != want {
+		t.Errorf("changedFilesRoot(%q) = %q; want %q", "..", root, want)
+	}
+}
+
+func TestFormatTurbo(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages := &gta.Packages{
+		AllChanges: []gta.Package{
+			{ImportPath: "example.com/deleted"},
+			{ImportPath: "example.com/root", Dir: wd},
+			{ImportPath: "example.com/services/api", Dir: filepath.Join(wd, "services", "api")},
+		},
+	}
+
+	tmpl, err := parseFormat("turbo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, packages); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "--filter=//\n--filter=./services/api\n"
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestWriteGitHubOutput(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(fn, []byte("previous=1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs := []string{"example.com/foo", "example.com/bar"}
+	if err := writeGitHubOutput(fn, pkgs); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the delimiter is random, so read it from the output.
+	lines := strings.Split(string(b), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "changed_packages<<") {
+		t.Fatalf("unexpected output:\n%s", b)
+	}
+	delim := strings.TrimPrefix(lines[1], "changed_packages<<")
+	if delim == "" {
+		t.Fatalf("missing delimiter:\n%s", b)
+	}
+
+	want := "previous=1\n" + gitHubOutput(pkgs, delim)
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGitHubOutput(t *testing.T) {
+	want := `changed_packages<<EOF
+example.com/foo example.com/bar
+EOF
+changed_count=2
+`
+	got := gitHubOutput([]string{"example.com/foo", "example.com/bar"}, "EOF")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.go"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err := printStats(&buf, gta.NewFileDifferWithRoot(dir, []string{"foo.go", "deleted.go"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "files changed: 2\nfiles deleted: 1\ngo.sum modules changed: 0\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestPrintReasons(t *testing.T) {
+	p := &gta.Packages{
+		Changes: []gta.Package{
+			{ImportPath: "example.com/bar"},
+			{ImportPath: "example.com/foo"},
+		},
+		Reasons: map[string]string{
+			"example.com/bar": "deleted",
+			"example.com/foo": "changed test files",
+		},
+	}
+
+	var buf bytes.Buffer
+	printReasons(&buf, p)
+
+	want := "example.com/bar: deleted\nexample.com/foo: changed test files\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestStringify(t *testing.T) {
+	pkgs := []gta.Package{
+		{ImportPath: "example.com/a", Dir: "/src/a"},
+		{ImportPath: "example.com/deleted"},
+		{ImportPath: "example.com/b", Dir: "/src/b"},
+	}
+
+	tests := []struct {
+		desc      string
+		validOnly bool
+		dirs      bool
+		want      []string
+	}{
+		{
+			desc: "import paths",
+			want: []string{"example.com/a", "example.com/deleted", "example.com/b"},
+		},
+		{
+			desc:      "buildable import paths",
+			validOnly: true,
+			want:      []string{"example.com/a", "example.com/b"},
+		},
+		{
+			desc: "dirs",
+			dirs: true,
+			want: []string{"/src/a", "/src/b"},
+		},
+		{
+			desc:      "buildable dirs",
+			validOnly: true,
+			dirs:      true,
+			want:      []string{"/src/a", "/src/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := stringify(pkgs, tt.validOnly, tt.dirs)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	changed := []gta.Package{{ImportPath: "example.com/a"}}
+
+	tests := []struct {
+		desc     string
+		pkgs     []gta.Package
+		exitCode bool
+		want     int
+	}{
+		{desc: "changes", pkgs: changed, want: 0},
+		{desc: "no changes", want: 0},
+		{desc: "changes with -exit-code", pkgs: changed, exitCode: true, want: 0},
+		{desc: "no changes with -exit-code", exitCode: true, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := exitStatus(tt.pkgs, tt.exitCode); got != tt.want {
+			t.Errorf("%s: exitStatus() = %d; want %d", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestPrintGroups(t *testing.T) {
+	groups := map[string][]gta.Package{
+		"@org/b": {{ImportPath: "example.com/b"}},
+		"":       {{ImportPath: "example.com/deleted"}},
+		"@org/a": {{ImportPath: "example.com/a"}, {ImportPath: "example.com/a/sub"}},
+	}
+
+	var buf bytes.Buffer
+	printGroups(&buf, groups)
+
+	want := "@org/a: example.com/a example.com/a/sub\n@org/b: example.com/b\n(unowned): example.com/deleted\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestFindCodeowners(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{".git", ".github", filepath.Join("a", "b")} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := findCodeowners(filepath.Join(root, "a", "b")); err == nil {
+		t.Error("err = nil; want an error when there is no CODEOWNERS file")
+	}
+
+	want := filepath.Join(root, ".github", "CODEOWNERS")
+	if err := os.WriteFile(want, []byte("* @org/core\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findCodeowners(filepath.Join(root, "a", "b"))
+	if err != nil {
+		t.Fatalf("err = %q; want nil", err)
+	}
+	if got != want {
+		t.Errorf("findCodeowners() = %q; want %q", got, want)
+	}
+}
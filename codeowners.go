@@ -0,0 +1,172 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// codeownersRule is a single line of a CODEOWNERS file.
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+// GroupByCodeowners groups pkgs by the owners that the GitHub CODEOWNERS file
+// at codeownersPath assigns to their directories. The patterns of the file are
+// relative to the root of the repository, which is the directory of the file,
+// or its parent when the file is in a .github or docs directory.
+//
+// As in GitHub, the last matching rule determines the owners of a package. A
+// rule matches a package when its pattern matches the package's directory,
+// one of the directory's ancestors, or one of the Go files in the directory,
+// so a rule for * sets the default owners. A package with several owners is
+// in the group of each of them, and the packages without owners (e.g. deleted
+// packages, whose Dir is empty) are grouped by the empty string.
+func GroupByCodeowners(pkgs []Package, codeownersPath string) (map[string][]Package, error) {
+	rules, err := parseCodeowners(codeownersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Dir(codeownersPath)
+	if base := filepath.Base(root); base == ".github" || base == "docs" {
+		root = filepath.Dir(root)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]Package)
+	for _, pkg := range pkgs {
+		owners, err := codeowners(rules, root, pkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(owners) == 0 {
+			groups[""] = append(groups[""], pkg)
+			continue
+		}
+		for _, owner := range owners {
+			groups[owner] = append(groups[owner], pkg)
+		}
+	}
+
+	return groups, nil
+}
+
+// parseCodeowners returns the rules of the CODEOWNERS file fn. Blank lines and
+// comments are skipped.
+func parseCodeowners(fn string) ([]codeownersRule, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []codeownersRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rules = append(rules, codeownersRule{pattern: fields[0], owners: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// codeowners returns the owners of the package in the absolute directory dir
+// according to rules, whose patterns are relative to root.
+func codeowners(rules []codeownersRule, root, dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, nil
+	}
+
+	var segments []string
+	if rel != "." {
+		segments = strings.Split(rel, "/")
+	}
+
+	var files []string
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".go") {
+			files = append(files, entry.Name())
+		}
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		if matchCodeownersPattern(rules[i].pattern, segments, files) {
+			return rules[i].owners, nil
+		}
+	}
+	return nil, nil
+}
+
+// matchCodeownersPattern reports whether pattern matches the directory whose
+// slash separated path elements relative to the root of the repository are
+// dir, one of its ancestors, or one of files, which are the names of files in
+// the directory. A pattern that starts with a slash or that has a slash other
+// than a trailing one is relative to the root; other patterns match at any
+// depth. A pattern that ends with a slash only matches directories.
+func matchCodeownersPattern(pattern string, dir, files []string) bool {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	segments := strings.Split(pattern, "/")
+	if !anchored {
+		segments = append([]string{"**"}, segments...)
+	}
+
+	for i := 1; i <= len(dir); i++ {
+		if matchSegments(segments, dir[:i]) {
+			return true
+		}
+	}
+
+	if dirOnly {
+		return false
+	}
+
+	for _, fn := range files {
+		name := append(append([]string(nil), dir...), fn)
+		if matchSegments(segments, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatchCodeownersPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		files   []string
+		want    bool
+	}{
+		{pattern: "*", dir: "", files: []string{"main.go"}, want: true},
+		{pattern: "*", dir: "a/b", want: true},
+		{pattern: "*.go", dir: "a", files: []string{"a.go"}, want: true},
+		{pattern: "*.js", dir: "a", files: []string{"a.go"}, want: false},
+		{pattern: "b", dir: "a/b/c", want: true},
+		{pattern: "b/", dir: "a/b", want: true},
+		{pattern: "a.go/", dir: "a", files: []string{"a.go"}, want: false},
+		{pattern: "/b/", dir: "a/b", want: false},
+		{pattern: "/a/", dir: "a/b", want: true},
+		{pattern: "a/b", dir: "a/b/c", want: true},
+		{pattern: "a/b", dir: "c/a/b", want: false},
+		{pattern: "a/*", dir: "a", files: []string{"a.go"}, want: true},
+		{pattern: "a/*.go", dir: "a/b", files: []string{"b.go"}, want: false},
+		{pattern: "**/internal", dir: "a/internal/b", want: true},
+		{pattern: "a/**/c", dir: "a/b/c", want: true},
+		{pattern: "a/**/c", dir: "a/b/d", want: false},
+	}
+
+	for _, tt := range tests {
+		var dir []string
+		if tt.dir != "" {
+			dir = strings.Split(tt.dir, "/")
+		}
+		if got := matchCodeownersPattern(tt.pattern, dir, tt.files); got != tt.want {
+			t.Errorf("matchCodeownersPattern(%q, %q, %q) = %v; want %v", tt.pattern, tt.dir, tt.files, got, tt.want)
+		}
+	}
+}
+
+func TestGroupByCodeowners(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, contents string) {
+		t.Helper()
+		fn := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(".github/CODEOWNERS", `# the default owners
+*             @org/core
+
+/api/         @org/api @org/platform
+*_gen.go      @org/generators
+/api/legacy/  # no owners
+docs/**       @org/docs
+`)
+	write("main.go", "package main\n")
+	write("api/api.go", "package api\n")
+	write("api/v1/v1.go", "package v1\n")
+	write("api/legacy/legacy.go", "package legacy\n")
+	write("lib/lib.go", "package lib\n")
+	write("lib/gen/types_gen.go", "package gen\n")
+
+	pkg := func(importPath, dir string) Package {
+		if dir == "" {
+			return Package{ImportPath: importPath}
+		}
+		return Package{ImportPath: importPath, Dir: filepath.Join(root, filepath.FromSlash(dir))}
+	}
+
+	pkgs := []Package{
+		pkg("example.com/m", "."),
+		pkg("example.com/m/api", "api"),
+		pkg("example.com/m/api/v1", "api/v1"),
+		pkg("example.com/m/api/legacy", "api/legacy"),
+		pkg("example.com/m/lib", "lib"),
+		pkg("example.com/m/lib/gen", "lib/gen"),
+		pkg("example.com/m/deleted", ""),
+	}
+
+	got, err := GroupByCodeowners(pkgs, filepath.Join(root, ".github", "CODEOWNERS"))
+	if err != nil {
+		t.Fatalf("err = %q; want nil", err)
+	}
+
+	want := map[string][]Package{
+		"@org/core": {
+			pkg("example.com/m", "."),
+			pkg("example.com/m/lib", "lib"),
+		},
+		"@org/api": {
+			pkg("example.com/m/api", "api"),
+			pkg("example.com/m/api/v1", "api/v1"),
+		},
+		"@org/platform": {
+			pkg("example.com/m/api", "api"),
+			pkg("example.com/m/api/v1", "api/v1"),
+		},
+		"@org/generators": {
+			pkg("example.com/m/lib/gen", "lib/gen"),
+		},
+		"": {
+			pkg("example.com/m/api/legacy", "api/legacy"),
+			pkg("example.com/m/deleted", ""),
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGroupByCodeowners_NotExist(t *testing.T) {
+	_, err := GroupByCodeowners(nil, filepath.Join(t.TempDir(), "CODEOWNERS"))
+	if !os.IsNotExist(err) {
+		t.Errorf("err = %v; want a not exist error", err)
+	}
+}
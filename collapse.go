@@ -0,0 +1,128 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"go/build"
+	"go/scanner"
+	"path"
+	"sort"
+	"strings"
+)
+
+// CollapseToTrees returns the import paths of pkgs as arguments for go test.
+// When pkgs includes all of the packages below an import path, and there is
+// more than one of them, they are replaced by a single pattern of the import
+// path followed by /... (e.g. example.com/foo/...). The packages below an
+// import path are found in the directories of the roots, so the patterns never
+// match packages that are not in pkgs. Packages that were deleted (i.e. whose
+// Dir is empty) are omitted, because they cannot be tested. The returned
+// arguments are sorted.
+func (g *GTA) CollapseToTrees(pkgs []Package) ([]string, error) {
+	if g.packager == nil {
+		return nil, ErrNoPackager
+	}
+
+	changed := make(map[string]struct{}, len(pkgs))
+	for _, pkg := range pkgs {
+		if pkg.Dir != "" {
+			changed[pkg.ImportPath] = struct{}{}
+		}
+	}
+
+	// modules are the import paths of the roots. A pattern must not match
+	// packages outside of them (e.g. the packages of dependencies).
+	var modules []string
+
+	// count is the number of packages below each import path, including the
+	// package at the import path itself, and incomplete is the set of import
+	// paths with packages below them that are not in pkgs.
+	count := make(map[string]int)
+	incomplete := make(map[string]struct{})
+
+	for _, root := range g.roots {
+		if pkg, err := g.packager.PackageFromEmptyDir(root); err == nil && pkg.ImportPath != "." {
+			modules = append(modules, pkg.ImportPath)
+		}
+
+		dirs, err := g.moduleDirs(root)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dir := range dirs {
+			pkg, err := g.packager.PackageFromDir(dir)
+			if err != nil {
+				switch err.(type) {
+				case *build.NoGoError, scanner.ErrorList:
+					continue
+				}
+				return nil, err
+			}
+
+			_, ok := changed[pkg.ImportPath]
+			for _, importPath := range treesOf(pkg.ImportPath) {
+				count[importPath]++
+				if !ok {
+					incomplete[importPath] = struct{}{}
+				}
+			}
+		}
+	}
+
+	withinModules := func(importPath string) bool {
+		if len(modules) == 0 {
+			return true
+		}
+
+		for _, module := range modules {
+			if importPath == module || strings.HasPrefix(importPath, module+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := make(map[string]struct{}, len(changed))
+	var args []string
+	for importPath := range changed {
+		arg := importPath
+
+		// use the pattern of the highest import path whose packages are all in
+		// pkgs. The packages below an import path are a superset of the packages
+		// below the import paths below it, so there is no need to look further
+		// once an import path is incomplete.
+		for _, tree := range treesOf(importPath) {
+			if _, ok := incomplete[tree]; ok || !withinModules(tree) {
+				break
+			}
+			if count[tree] > 1 {
+				arg = tree + "/..."
+			}
+		}
+
+		if _, ok := seen[arg]; ok {
+			continue
+		}
+		seen[arg] = struct{}{}
+		args = append(args, arg)
+	}
+	sort.Strings(args)
+
+	return args, nil
+}
+
+// treesOf returns importPath and the import paths above it, starting with
+// importPath (e.g. a/b/c, a/b, a).
+func treesOf(importPath string) []string {
+	var trees []string
+	for importPath != "." && importPath != "/" && importPath != "" {
+		trees = append(trees, importPath)
+		importPath = path.Dir(importPath)
+	}
+	return trees
+}
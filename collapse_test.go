@@ -0,0 +1,113 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGTA_CollapseToTrees(t *testing.T) {
+	root := t.TempDir()
+
+	dirs2Imports := make(map[string]string)
+	for dir, importPath := range map[string]string{
+		"a":     "m/a",
+		"a/b":   "m/a/b",
+		"a/b/c": "m/a/b/c",
+		"d":     "m/d",
+		"d/e":   "m/d/e",
+	} {
+		dir = filepath.Join(root, filepath.FromSlash(dir))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "x.go"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		dirs2Imports[dir] = importPath
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: dirs2Imports,
+		graph:        &Graph{graph: map[string]map[string]bool{}},
+		errs:         make(map[string]error),
+	}
+
+	tests := []struct {
+		desc string
+		pkgs []Package
+		want []string
+	}{
+		{
+			desc: "fully changed subtree",
+			pkgs: []Package{
+				{ImportPath: "m/a", Dir: "a"},
+				{ImportPath: "m/a/b", Dir: "a/b"},
+				{ImportPath: "m/a/b/c", Dir: "a/b/c"},
+				{ImportPath: "m/d", Dir: "d"},
+			},
+			want: []string{"m/a/...", "m/d"},
+		},
+		{
+			desc: "partially changed subtree",
+			pkgs: []Package{
+				{ImportPath: "m/a/b", Dir: "a/b"},
+				{ImportPath: "m/a/b/c", Dir: "a/b/c"},
+				{ImportPath: "m/d/e", Dir: "d/e"},
+			},
+			want: []string{"m/a/b/...", "m/d/e"},
+		},
+		{
+			desc: "everything changed",
+			pkgs: []Package{
+				{ImportPath: "m/a", Dir: "a"},
+				{ImportPath: "m/a/b", Dir: "a/b"},
+				{ImportPath: "m/a/b/c", Dir: "a/b/c"},
+				{ImportPath: "m/d", Dir: "d"},
+				{ImportPath: "m/d/e", Dir: "d/e"},
+			},
+			want: []string{"m/..."},
+		},
+		{
+			desc: "deleted package",
+			pkgs: []Package{
+				{ImportPath: "m/a/b/c", Dir: "a/b/c"},
+				{ImportPath: "m/a/b/deleted"},
+			},
+			want: []string{"m/a/b/c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(&testDiffer{}), SetPackager(pkgr), SetRoots(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := gta.CollapseToTrees(tt.pkgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_treesOf(t *testing.T) {
+	want := []string{"example.com/a/b", "example.com/a", "example.com"}
+	if diff := cmp.Diff(want, treesOf("example.com/a/b")); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
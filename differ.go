@@ -8,13 +8,17 @@ package gta
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // A Differ implements provides methods that return values to understand the
@@ -30,16 +34,142 @@ type Differ interface {
 	DiffFiles() (map[string]bool, error)
 }
 
+// A GoSumDiffer is a Differ that can also report the modules whose checksums
+// changed in go.sum files.
+type GoSumDiffer interface {
+	Differ
+
+	// DiffGoSum returns a set of module paths whose checksums were added to or
+	// removed from go.sum files. Checksums of only a module's go.mod file are
+	// not considered.
+	DiffGoSum() (map[string]struct{}, error)
+}
+
+// DiffStats summarizes the changes reported by a differ.
+type DiffStats struct {
+	// FilesChanged is the number of changed files, including the deleted
+	// files.
+	FilesChanged int
+	// FilesDeleted is the number of changed files that were deleted.
+	FilesDeleted int
+	// GoSumModulesChanged is the number of modules whose checksums changed in
+	// go.sum files.
+	GoSumModulesChanged int
+}
+
+// A StatsDiffer is a Differ that can also summarize the changes.
+type StatsDiffer interface {
+	Differ
+
+	// DiffStats returns the numbers of changed and deleted files and of
+	// modules whose checksums changed in go.sum files.
+	DiffStats() (DiffStats, error)
+}
+
+// A LineDiffer is a Differ that can also report the numbers of changed lines
+// of the changed files.
+type LineDiffer interface {
+	Differ
+
+	// DiffLines returns a map of the absolute paths of changed files to the
+	// numbers of their lines that were added or deleted. Lines that were
+	// changed are counted as both deleted and added. Binary files have no
+	// changed lines.
+	DiffLines() (map[string]int, error)
+}
+
+// ErrNoBase is returned by BaseFile when the differ does not know the contents
+// of files before they were changed.
+var ErrNoBase = errors.New("the base contents of files are not available")
+
+// ErrBaseBranchNotFound is returned by the differs created by NewGitDiffer
+// when the base branch does not name a commit (e.g. because of a typo or
+// because the ref was not fetched).
+var ErrBaseBranchNotFound = errors.New("base branch not found")
+
+// ErrNotGitRepository is returned by the differs created by NewGitDiffer when
+// the current directory is not in a git repository, so that callers can fall
+// back to another differ (e.g. one created by NewFileDiffer).
+var ErrNotGitRepository = errors.New("not a git repository")
+
+// ErrShallowClone is returned by the differs created by NewGitDiffer when the
+// repository is a shallow clone whose history does not include the commit from
+// which HEAD was branched from the base branch.
+var ErrShallowClone = errors.New("the repository is a shallow clone")
+
+// A BaseDiffer is a Differ that can also provide the contents of changed files
+// before they were changed.
+type BaseDiffer interface {
+	Differ
+
+	// BaseFile returns the contents of the file at the absolute path abs before
+	// it was changed. It returns an error that satisfies
+	// errors.Is(err, fs.ErrNotExist) when the file did not exist.
+	BaseFile(abs string) ([]byte, error)
+}
+
+// A BaseRevisionDiffer is a Differ that can also report the revision that the
+// changes are compared to.
+type BaseRevisionDiffer interface {
+	Differ
+
+	// BaseRevision returns an identifier of the revision that the changes are
+	// compared to (e.g. a commit hash). It returns ErrNoBase when the revision
+	// is not known.
+	BaseRevision() (string, error)
+}
+
+// A ResettableDiffer is a Differ whose changes are cached and can be computed
+// again.
+type ResettableDiffer interface {
+	Differ
+
+	// Reset discards the cached changes so that they are computed again by the
+	// next call to one of the differ's methods (e.g. after new commits were
+	// made). Reset must not be called concurrently with the other methods.
+	Reset()
+}
+
+// A rootDiffer is a Differ that can also report the root directory of the
+// repository whose changes it reports.
+type rootDiffer interface {
+	Differ
+
+	// root returns the absolute path of the root directory of the repository.
+	// It returns errNoRoot when the root is not known.
+	root() (string, error)
+}
+
+// errNoRoot is returned by the root method of the differs that were not
+// created by NewGitDiffer.
+var errNoRoot = errors.New("the root of the repository is not known")
+
 // GitDifferOption is an option function used to modify a git differ
 type GitDifferOption func(*git)
 
-// SetUseMergeCommit sets the useMergeCommit field on a git differ
+// SetUseMergeCommit sets the useMergeCommit field on a git differ. When HEAD
+// is a merge commit, the changes are those between its first parent and each
+// of its other parents, so the changes of all the branches of an octopus merge
+// are included. Use SetMergeParentIndex to only include one of them.
 func SetUseMergeCommit(useMergeCommit bool) GitDifferOption {
 	return func(gd *git) {
 		gd.useMergeCommit = useMergeCommit
 	}
 }
 
+// SetMergeParentIndex sets the parent of the merge commit whose changes are
+// included when the merge commit is used, numbered like git does (e.g. 2 is
+// HEAD^2, the first branch that was merged). Only the changes between the
+// first parent and that parent are included instead of those of all the
+// merged branches. The differ returns an error when HEAD is a merge commit
+// without that parent. 0, the default, includes all of them. It has no effect
+// unless SetUseMergeCommit is set, or when HEAD is not a merge commit.
+func SetMergeParentIndex(n int) GitDifferOption {
+	return func(gd *git) {
+		gd.mergeParentIndex = n
+	}
+}
+
 // SetBaseBranch sets the baseBranch field on a git differ
 func SetBaseBranch(baseBranch string) GitDifferOption {
 	return func(gd *git) {
@@ -47,6 +177,46 @@ func SetBaseBranch(baseBranch string) GitDifferOption {
 	}
 }
 
+// SetBaseBranches sets candidate base branches of a git differ (e.g. the main
+// branch and the release branches), of which the nearest to HEAD is used as
+// the base branch instead of the one set with SetBaseBranch. The nearest base
+// branch is the one with the fewest commits that are reachable from HEAD but
+// not from it, i.e. the one whose branch point is the closest to HEAD. Ties
+// are broken in favor of the base branch that comes first in baseBranches.
+// The candidates that do not name a commit are skipped, and
+// ErrBaseBranchNotFound is returned when none of them do.
+func SetBaseBranches(baseBranches ...string) GitDifferOption {
+	return func(gd *git) {
+		gd.baseBranches = baseBranches
+	}
+}
+
+// SetBaseFromFile sets the base branch to the revision named in the file at
+// path (e.g. the hash of the last commit that was built successfully), so that
+// the changes since that revision are found. The file is read when the differ
+// is created; leading and trailing whitespace is ignored. The differ returns
+// an error when the file cannot be read or is empty, and an error wrapping
+// ErrBaseBranchNotFound when the revision does not name a commit.
+func SetBaseFromFile(path string) GitDifferOption {
+	return func(gd *git) {
+		gd.baseRevisionFile = path
+		gd.baseFileErr = nil
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			gd.baseFileErr = fmt.Errorf("reading the base revision: %w", err)
+			return
+		}
+
+		base := strings.TrimSpace(string(b))
+		if base == "" {
+			gd.baseFileErr = fmt.Errorf("reading the base revision: %s is empty", path)
+			return
+		}
+		gd.baseBranch = base
+	}
+}
+
 // SetUseHeadToHead sets the useHeadToHead field on a git differ
 func SetUseHeadToHead(useHeadToHead bool) GitDifferOption {
 	return func(gd *git) {
@@ -54,6 +224,102 @@ func SetUseHeadToHead(useHeadToHead bool) GitDifferOption {
 	}
 }
 
+// SetResolveSymlinks sets whether the directories of the changed files are
+// reported by their paths with symbolic links resolved (e.g. a change to
+// third_party/foo/foo.go is reported in the directory that third_party links
+// to) so that they match the directories of the packages. Directories that do
+// not exist are reported by their literal paths.
+func SetResolveSymlinks(resolveSymlinks bool) GitDifferOption {
+	return func(gd *git) {
+		gd.resolveSymlinks = resolveSymlinks
+	}
+}
+
+// SetIncludeWorkingTree sets whether the changes in the working tree are
+// included in addition to the committed changes: the uncommitted changes to
+// tracked files, whether they are staged or not, and the untracked files that
+// are not ignored. Only committed changes are included by default.
+func SetIncludeWorkingTree(includeWorkingTree bool) GitDifferOption {
+	return func(gd *git) {
+		gd.includeWorkingTree = includeWorkingTree
+	}
+}
+
+// SetIgnoreWhitespaceChanges sets whether the files whose only changes are to
+// whitespace (e.g. a gofmt reformat) are omitted from the changed files, as
+// with the -w flag of git diff. Added and deleted files are changed
+// regardless of their contents.
+func SetIgnoreWhitespaceChanges(ignoreWhitespaceChanges bool) GitDifferOption {
+	return func(gd *git) {
+		gd.ignoreWhitespace = ignoreWhitespaceChanges
+	}
+}
+
+// SetPathspec sets git pathspecs (e.g. "services/billing" or
+// ":(glob)**/*.go"), relative to the root of the repository, that limit the
+// changed files to the files they match. Changes to other files are not
+// reported at all, unlike packages that are filtered with SetPrefixes. The
+// changes to go.sum files are compared regardless of the pathspecs. The
+// pathspecs do not limit the packages that are loaded; use SetModules for
+// that.
+func SetPathspec(paths ...string) GitDifferOption {
+	return func(gd *git) {
+		gd.pathspecs = paths
+	}
+}
+
+// SetAutoUnshallow sets whether the history of a shallow clone is fetched with
+// git fetch --unshallow before the changes are determined. Without it, an
+// error wrapping ErrShallowClone is returned when the clone is shallow and the
+// commit from which HEAD was branched from the base branch is not in its
+// history.
+func SetAutoUnshallow(autoUnshallow bool) GitDifferOption {
+	return func(gd *git) {
+		gd.autoUnshallow = autoUnshallow
+	}
+}
+
+// SetFetchBase sets whether the base branch is fetched from its remote before
+// the changes are determined when it does not name a commit yet (e.g. in a CI
+// checkout of a single branch). The remote and the branch are parsed from the
+// base branch, which must be a remote-tracking branch such as origin/main or
+// refs/remotes/origin/main, and the branch is fetched into that ref.
+func SetFetchBase(fetchBase bool) GitDifferOption {
+	return func(gd *git) {
+		gd.fetchBase = fetchBase
+	}
+}
+
+// SetGitRetries sets the number of times a git command that fails transiently
+// (e.g. because another process holds the lock of the index) is retried, and
+// the delay before the first retry, which doubles with each retry. Other
+// errors, like that of a base branch that does not exist, are never retried.
+// By default, git commands are not retried.
+func SetGitRetries(n int, backoff time.Duration) GitDifferOption {
+	return func(gd *git) {
+		gd.retries = n
+		gd.retryBackoff = backoff
+	}
+}
+
+// SetGitBinary sets the path of the git executable that is run. By default, git
+// is looked up in the directories named by the PATH environment variable.
+func SetGitBinary(path string) GitDifferOption {
+	return func(gd *git) {
+		gd.binary = path
+	}
+}
+
+// SetGitEnv sets environment variables, as key=value pairs, that git is run
+// with in addition to the environment of the current process (e.g.
+// GIT_CONFIG_NOSYSTEM=1). When a variable is set in both, the value in env is
+// used.
+func SetGitEnv(env []string) GitDifferOption {
+	return func(gd *git) {
+		gd.env = env
+	}
+}
+
 // NewGitDiffer returns a Differ that determines differences using git.
 func NewGitDiffer(opts ...GitDifferOption) Differ {
 	g := &git{
@@ -65,7 +331,14 @@ func NewGitDiffer(opts ...GitDifferOption) Differ {
 	}
 
 	return &differ{
-		diff: g.diff,
+		diff:            g.diff,
+		diffGoSum:       g.diffGoSum,
+		diffLines:       g.diffLines,
+		baseFile:        g.baseFile,
+		baseRevision:    g.baseRevision,
+		repoRoot:        g.root,
+		resolveSymlinks: g.resolveSymlinks,
+		reset:           g.reset,
 	}
 }
 
@@ -83,18 +356,84 @@ func NewFileDiffer(files []string) Differ {
 	}
 }
 
+// NewFileDifferWithRoot returns a Differ that operates on a list of changed
+// files. Relative paths are resolved against root the same way the paths
+// reported by git are resolved against the repository's root; absolute paths
+// are used as is.
+func NewFileDifferWithRoot(root string, files []string) Differ {
+	m := make(map[string]struct{}, len(files))
+
+	var err error
+	for _, v := range files {
+		if !filepath.IsAbs(v) {
+			v, err = filepath.Abs(filepath.Join(root, v))
+			if err != nil {
+				break
+			}
+		}
+
+		m[v] = struct{}{}
+	}
+
+	return &differ{
+		diff: func() (map[string]struct{}, error) {
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	}
+}
+
 type differ struct {
-	diff func() (map[string]struct{}, error)
+	diff         func() (map[string]struct{}, error)
+	diffGoSum    func() (map[string]struct{}, error)
+	diffLines    func() (map[string]int, error)
+	baseFile     func(string) ([]byte, error)
+	baseRevision func() (string, error)
+	repoRoot     func() (string, error)
+	// exists reports whether a changed file exists. The file system is
+	// consulted when it is nil.
+	exists func(string) bool
+	// resolveSymlinks is true when the symbolic links in the directories of
+	// the changed files are resolved.
+	resolveSymlinks bool
+	// reset discards the cached changes. The changes are not cached when it is
+	// nil.
+	reset func()
 }
 
 // git implements the Differ interface using a git version control method.
 type git struct {
-	baseBranch     string
-	useMergeCommit bool
-	useHeadToHead  bool
-	onceDiff       sync.Once
-	changedFiles   map[string]struct{}
-	diffErr        error
+	baseBranch         string
+	baseBranches       []string
+	useMergeCommit     bool
+	useHeadToHead      bool
+	resolveSymlinks    bool
+	includeWorkingTree bool
+	autoUnshallow      bool
+	fetchBase          bool
+	retries            int
+	retryBackoff       time.Duration
+	binary             string
+	env                []string
+	ignoreWhitespace   bool
+	pathspecs          []string
+	mergeParentIndex   int
+	baseRevisionFile   string
+	baseFileErr        error
+	onceDiff           sync.Once
+	changedFiles       map[string]struct{}
+	diffErr            error
+
+	onceParents      sync.Once
+	parent1          string
+	rightwardParents []string
+	parentsErr       error
+
+	onceBase sync.Once
+	base     string
+	baseErr  error
 }
 
 // A Directory describes changes to a directory and its contents.
@@ -113,7 +452,7 @@ func (d *differ) Diff() (map[string]Directory, error) {
 
 	existsDirs := make(map[string]Directory, len(files))
 	for abs := range files {
-		absdir := filepath.Dir(abs)
+		absdir := d.resolveDir(filepath.Dir(abs))
 		dir, ok := existsDirs[absdir]
 		if !ok {
 			dir.Exists = exists(absdir)
@@ -136,31 +475,161 @@ func (d *differ) DiffFiles() (map[string]bool, error) {
 		return nil, err
 	}
 
+	fileExists := exists
+	if d.exists != nil {
+		fileExists = d.exists
+	}
+
 	existsFiles := map[string]bool{}
 	for abs := range files {
-		existsFiles[abs] = exists(abs)
+		existsFiles[filepath.Join(d.resolveDir(filepath.Dir(abs)), filepath.Base(abs))] = fileExists(abs)
 	}
 
 	return existsFiles, nil
 }
 
+// resolveDir returns absdir with its symbolic links resolved when the differ
+// resolves them. absdir is returned as is otherwise, or when it cannot be
+// resolved (e.g. because it was deleted).
+func (d *differ) resolveDir(absdir string) string {
+	if !d.resolveSymlinks {
+		return absdir
+	}
+
+	resolved, err := filepath.EvalSymlinks(absdir)
+	if err != nil {
+		return absdir
+	}
+	return resolved
+}
+
+// DiffGoSum returns a set of module paths whose checksums changed in go.sum
+// files. The returned set is always empty when the differ is not able to
+// compare the contents of go.sum files (e.g. a differ created by
+// NewFileDiffer).
+func (d *differ) DiffGoSum() (map[string]struct{}, error) {
+	if d.diffGoSum == nil {
+		return map[string]struct{}{}, nil
+	}
+
+	return d.diffGoSum()
+}
+
+// DiffLines returns a map of the absolute paths of changed files to the
+// numbers of their lines that were added or deleted. The returned map is
+// always empty when the differ is not able to compare the contents of files
+// (e.g. a differ created by NewFileDiffer).
+func (d *differ) DiffLines() (map[string]int, error) {
+	if d.diffLines == nil {
+		return map[string]int{}, nil
+	}
+
+	lines, err := d.diffLines()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]int, len(lines))
+	for abs, n := range lines {
+		resolved[filepath.Join(d.resolveDir(filepath.Dir(abs)), filepath.Base(abs))] += n
+	}
+	return resolved, nil
+}
+
+// DiffStats returns the numbers of changed and deleted files and of changed
+// module dependencies. The number of changed module dependencies is always 0
+// when the differ is not able to compare the contents of go.sum files.
+func (d *differ) DiffStats() (DiffStats, error) {
+	files, err := d.DiffFiles()
+	if err != nil {
+		return DiffStats{}, err
+	}
+
+	modules, err := d.DiffGoSum()
+	if err != nil {
+		return DiffStats{}, err
+	}
+
+	stats := DiffStats{
+		FilesChanged:        len(files),
+		GoSumModulesChanged: len(modules),
+	}
+	for _, ok := range files {
+		if !ok {
+			stats.FilesDeleted++
+		}
+	}
+	return stats, nil
+}
+
+// BaseFile returns the contents of the file at abs before it was changed. It
+// returns ErrNoBase when the differ was not created by NewGitDiffer.
+func (d *differ) BaseFile(abs string) ([]byte, error) {
+	if d.baseFile == nil {
+		return nil, ErrNoBase
+	}
+
+	return d.baseFile(abs)
+}
+
+// BaseRevision returns the commit that the changes are compared to. It returns
+// ErrNoBase when the differ was not created by NewGitDiffer.
+func (d *differ) BaseRevision() (string, error) {
+	if d.baseRevision == nil {
+		return "", ErrNoBase
+	}
+
+	return d.baseRevision()
+}
+
+// root returns the root directory of the git repository. It returns errNoRoot
+// when the differ was not created by NewGitDiffer.
+func (d *differ) root() (string, error) {
+	if d.repoRoot == nil {
+		return "", errNoRoot
+	}
+
+	return d.repoRoot()
+}
+
+// Reset discards the changes cached by a differ created by NewGitDiffer so
+// that they are computed again, e.g. after new commits were made. It must not
+// be called concurrently with the other methods of the differ.
+func (d *differ) Reset() {
+	if d.reset == nil {
+		return
+	}
+
+	d.reset()
+}
+
 func (g *git) getMergeParents() (parent1 string, rightwardParents []string, err error) {
-	out, err := execWithStderr(exec.Command("git", "log", "-1", "--pretty=format:%p"))
+	out, err := g.output("", "log", "-1", "--pretty=format:%p")
 	if err != nil {
 		return
 	}
 	parents := strings.TrimSpace(string(out))
 	parentSplit := strings.Split(parents, " ")
 
-	// for merge commits, parents will include both values
+	// for merge commits, parents will include both values. An octopus merge
+	// has more than two parents; the changes of each of the merged branches are
+	// included unless a single parent was picked.
 	if len(parentSplit) >= 2 {
 		parent1 = parentSplit[0]
 		rightwardParents = parentSplit[1:]
+
+		if n := g.mergeParentIndex; n != 0 {
+			if n < 2 || n > len(parentSplit) {
+				err = fmt.Errorf("merge parent %d does not exist: HEAD has %d parents", n, len(parentSplit))
+				return
+			}
+			rightwardParents = parentSplit[n-1 : n]
+		}
 		return
 	}
 
 	// for squash-merge/rebase commits, get the most recent merge commit hash and use as left parent
-	out, err = execWithStderr(exec.Command("git", "log", "-1", "--merges", "--pretty=format:%h"))
+	out, err = g.output("", "log", "-1", "--merges", "--pretty=format:%h")
 	if err != nil {
 		return
 	}
@@ -169,6 +638,22 @@ func (g *git) getMergeParents() (parent1 string, rightwardParents []string, err
 	return
 }
 
+// reset discards the cached changed files and parents.
+func (g *git) reset() {
+	g.onceDiff = sync.Once{}
+	g.changedFiles = nil
+	g.diffErr = nil
+
+	g.onceParents = sync.Once{}
+	g.parent1 = ""
+	g.rightwardParents = nil
+	g.parentsErr = nil
+
+	g.onceBase = sync.Once{}
+	g.base = ""
+	g.baseErr = nil
+}
+
 // diff returns a set of changed files.
 func (g *git) diff() (map[string]struct{}, error) {
 	g.onceDiff.Do(func() {
@@ -179,26 +664,27 @@ func (g *git) diff() (map[string]struct{}, error) {
 				return nil, err
 			}
 
-			parent1, rightwardParents, err := g.getParents()
+			parent1, rightwardParents, err := g.parents()
 			if err != nil {
 				return nil, fmt.Errorf("git differ failed to get branch parents when getting go.mod dependency changes: %w", err)
 			}
 
 			files := make(map[string]struct{})
 
+			revisions := make([]string, 0, len(rightwardParents)+1)
 			for _, parent2 := range rightwardParents {
-				// get the names of all affected files without doing rename detection.
-				cmd := exec.Command("git", "diff", fmt.Sprintf("%s...%s", parent1, parent2), "--name-only", "--no-renames")
-				stdout, err := cmd.StdoutPipe()
-				if err != nil {
-					return nil, err
-				}
+				revisions = append(revisions, fmt.Sprintf("%s...%s", parent1, parent2))
+			}
 
-				if err := cmd.Start(); err != nil {
-					return nil, err
-				}
+			// only the committed changes are considered unless the working tree
+			// is included: the uncommitted changes to tracked files, staged or
+			// not, and the untracked files that are not ignored.
+			if g.includeWorkingTree {
+				revisions = append(revisions, "HEAD")
+			}
 
-				changedPaths, err := diffPaths(root, stdout)
+			for _, revision := range revisions {
+				changedPaths, err := g.diffNames(root, revision)
 				if err != nil {
 					return nil, err
 				}
@@ -206,11 +692,17 @@ func (g *git) diff() (map[string]struct{}, error) {
 				for path := range changedPaths {
 					files[path] = struct{}{}
 				}
+			}
 
-				err = cmd.Wait()
+			if g.includeWorkingTree {
+				untracked, err := g.paths(root, g.withPathspecs("ls-files", "--others", "--exclude-standard", "--full-name")...)
 				if err != nil {
 					return nil, err
 				}
+
+				for path := range untracked {
+					files[path] = struct{}{}
+				}
 			}
 			return files, nil
 		}()
@@ -225,20 +717,313 @@ func (g *git) diff() (map[string]struct{}, error) {
 	return g.changedFiles, g.diffErr
 }
 
+// diffNames returns the absolute paths of the files that are changed by the
+// diff of revision, without doing rename detection.
+func (g *git) diffNames(root, revision string) (map[string]struct{}, error) {
+	if !g.ignoreWhitespace {
+		return g.paths(root, g.withPathspecs("diff", revision, "--name-only", "--no-renames")...)
+	}
+
+	// git diff --name-only lists the files whose only changes are to whitespace
+	// even when whitespace is ignored, but git diff --numstat omits them.
+	out, err := g.output(root, g.withPathspecs("diff", revision, "--numstat", "-z", "-w", "--no-renames")...)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := numstatLines(root, out)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]struct{}, len(lines))
+	for abs := range lines {
+		paths[abs] = struct{}{}
+	}
+	return paths, nil
+}
+
+// withPathspecs returns args followed by the pathspecs set with SetPathspec.
+func (g *git) withPathspecs(args ...string) []string {
+	if len(g.pathspecs) == 0 {
+		return args
+	}
+	return append(append(args, "--"), g.pathspecs...)
+}
+
+// baseRevision returns the hash of the commit that the changes are compared
+// to: the merge base of the first parent and the rightward parents, which is
+// the commit that diff compares each of the rightward parents to. It returns
+// ErrNoBase when the rightward parents have different merge bases, e.g. after
+// an octopus merge.
+func (g *git) baseRevision() (string, error) {
+	g.onceBase.Do(func() {
+		parent1, rightwardParents, err := g.parents()
+		if err != nil {
+			g.baseErr = err
+			return
+		}
+
+		for _, parent2 := range rightwardParents {
+			out, err := g.output("", "merge-base", parent1, parent2)
+			if err != nil {
+				g.baseErr = err
+				return
+			}
+
+			base := strings.TrimSpace(string(out))
+			if g.base != "" && g.base != base {
+				g.base, g.baseErr = "", ErrNoBase
+				return
+			}
+			g.base = base
+		}
+	})
+
+	return g.base, g.baseErr
+}
+
+// baseFile returns the contents of the file at abs in the revision that the
+// changes are compared to.
+func (g *git) baseFile(abs string) ([]byte, error) {
+	root, err := g.root()
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, err
+	}
+	rel = filepath.ToSlash(rel)
+
+	base, err := g.baseRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := g.output("", "ls-tree", "--full-tree", "--name-only", base, "--", rel)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, &os.PathError{Op: "open", Path: abs, Err: os.ErrNotExist}
+	}
+
+	return g.output("", "show", fmt.Sprintf("%s:%s", base, rel))
+}
+
+// parents returns the memoized result of getParents.
+func (g *git) parents() (string, []string, error) {
+	g.onceParents.Do(func() {
+		g.parent1, g.rightwardParents, g.parentsErr = g.getParents()
+	})
+
+	return g.parent1, g.rightwardParents, g.parentsErr
+}
+
+// diffGoSum returns a set of module paths whose checksums changed in go.sum
+// files.
+func (g *git) diffGoSum() (map[string]struct{}, error) {
+	parent1, rightwardParents, err := g.parents()
+	if err != nil {
+		return nil, fmt.Errorf("git differ failed to get branch parents when getting go.sum changes: %w", err)
+	}
+
+	modules := make(map[string]struct{})
+	for _, parent2 := range rightwardParents {
+		out, err := g.output("", "diff", fmt.Sprintf("%s...%s", parent1, parent2), "--no-renames", "-U0", "--", ":(top,glob)**/go.sum")
+		if err != nil {
+			return nil, err
+		}
+
+		changed, err := goSumModules(bytes.NewReader(out))
+		if err != nil {
+			return nil, err
+		}
+
+		for module := range changed {
+			modules[module] = struct{}{}
+		}
+	}
+
+	return modules, nil
+}
+
+// diffLines returns the numbers of added and deleted lines of the changed
+// files. Only the committed changes are counted, even when the working tree is
+// included.
+func (g *git) diffLines() (map[string]int, error) {
+	root, err := g.root()
+	if err != nil {
+		return nil, err
+	}
+
+	parent1, rightwardParents, err := g.parents()
+	if err != nil {
+		return nil, fmt.Errorf("git differ failed to get branch parents when counting changed lines: %w", err)
+	}
+
+	lines := make(map[string]int)
+	for _, parent2 := range rightwardParents {
+		out, err := g.output(root, g.withPathspecs("diff", fmt.Sprintf("%s...%s", parent1, parent2), "--numstat", "-z", "--no-renames")...)
+		if err != nil {
+			return nil, err
+		}
+
+		changed, err := numstatLines(root, out)
+		if err != nil {
+			return nil, err
+		}
+
+		for abs, n := range changed {
+			lines[abs] += n
+		}
+	}
+
+	return lines, nil
+}
+
+// numstatLines returns the absolute paths of the files in the output of git
+// diff --numstat -z, whose paths are relative to root, mapped to the numbers
+// of their added and deleted lines.
+func numstatLines(root string, out []byte) (map[string]int, error) {
+	lines := make(map[string]int)
+	for _, record := range strings.Split(string(out), "\x00") {
+		if record == "" {
+			continue
+		}
+
+		// added, deleted and path are separated by tabs. Binary files have - for
+		// the numbers of lines.
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed numstat record %q", record)
+		}
+
+		var n int
+		for _, field := range fields[:2] {
+			if field == "-" {
+				continue
+			}
+
+			i, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("malformed numstat record %q: %w", record, err)
+			}
+			n += i
+		}
+
+		abs, err := filepath.Abs(filepath.Join(root, filepath.FromSlash(fields[2])))
+		if err != nil {
+			return nil, err
+		}
+		lines[abs] += n
+	}
+	return lines, nil
+}
+
+// goSumModules returns the module paths of the checksum lines that were added
+// or removed in a diff of go.sum files. A line that was both added and removed
+// (e.g. because it was moved) is not considered to be changed.
+func goSumModules(r io.Reader) (map[string]struct{}, error) {
+	added := make(map[string]struct{})
+	removed := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+
+		var set map[string]struct{}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			set = added
+		case strings.HasPrefix(line, "-"):
+			set = removed
+		default:
+			continue
+		}
+
+		// each line is of the form: module version hash, and the version of the
+		// checksum of a module's go.mod has a /go.mod suffix.
+		fields := strings.Fields(line[1:])
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+
+		set[strings.Join(fields, " ")] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	modules := make(map[string]struct{})
+	for _, sets := range [][2]map[string]struct{}{{added, removed}, {removed, added}} {
+		for line := range sets[0] {
+			if _, ok := sets[1][line]; ok {
+				continue
+			}
+			modules[strings.Fields(line)[0]] = struct{}{}
+		}
+	}
+
+	return modules, nil
+}
+
 func (g *git) getParents() (parent1 string, rightwardParents []string, errR error) {
-	parent1 = g.baseBranch
+	if g.baseFileErr != nil {
+		errR = g.baseFileErr
+		return
+	}
+
+	// the chosen base branch is kept in base instead of g.baseBranch so that
+	// computing the parents does not modify g.
+	base := g.baseBranch
+	parent1 = base
 	rightwardParents = []string{"HEAD"}
 
+	// the base branch is not used when diffing against the latest merge
+	// commit.
+	if !g.useMergeCommit {
+		if len(g.baseBranches) > 0 {
+			if base, errR = g.nearestBaseBranch(); errR != nil {
+				return
+			}
+			parent1 = base
+		}
+		if g.fetchBase {
+			if errR = g.fetchBaseBranch(base); errR != nil {
+				return
+			}
+		}
+		if errR = g.verifyBaseBranch(base); errR != nil {
+			return
+		}
+	}
+
 	// When HeadToHead is not set, vanilla behavior. Get root commit when the branch was created from the base as the parent.
 	if !g.useHeadToHead {
-		// get the revision from which HEAD was branched from g.baseBranch.
-		resParent1, err := g.branchPointOf("HEAD")
+		// get the revision from which HEAD was branched from base.
+		resParent1, err := g.branchPointOf("HEAD", base)
 		if err != nil {
 			errR = err
 
 			return
 		}
 
+		// the branch point of a shallow clone may be missing from its history,
+		// in which case falling back to the base branch would compare the
+		// wrong commits.
+		if resParent1 == "" {
+			resParent1, errR = g.branchPointOfShallow(base)
+			if errR != nil {
+				return
+			}
+		}
+
 		// If the branch point is unknown, fall back to using the base branch. In
 		// most cases, this will be fine, but results in a corner case when base
 		// branch has been merged into the branch since branch was created. In
@@ -263,12 +1048,125 @@ func (g *git) getParents() (parent1 string, rightwardParents []string, errR erro
 }
 
 func (g *git) root() (string, error) {
-	out, err := execWithStderr(exec.Command("git", "rev-parse", "--show-toplevel"))
+	out, err := g.output("", "rev-parse", "--show-toplevel")
 	if err != nil {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(out)), nil
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// gitPaths runs cmd, a git command, and returns the absolute paths of the files
+// whose paths relative to root it writes to stdout, one per line.
+func gitPaths(cmd *exec.Cmd, root string) (map[string]struct{}, error) {
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	paths, err := diffPaths(root, stdout)
+	if err != nil {
+		// Read the rest of the output so that git is not blocked writing to
+		// stdout and exits, and wait for it so that its resources are released.
+		_, _ = io.Copy(io.Discard, stdout)
+		if waitErr := cmd.Wait(); waitErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: %s", waitErr, stderr.String()))
+		}
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, stderr.String())
+	}
+
+	return paths, nil
+}
+
+// command returns the git command with args that runs in dir, or the current
+// directory when dir is empty. It runs the git binary and has the environment
+// set with SetGitBinary and SetGitEnv.
+func (g *git) command(dir string, args ...string) *exec.Cmd {
+	bin := "git"
+	if g.binary != "" {
+		bin = g.binary
+	}
+
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	if len(g.env) > 0 {
+		cmd.Env = append(os.Environ(), g.env...)
+	}
+	return cmd
+}
+
+// output runs git with args in dir, or the current directory when dir is
+// empty, and returns its stdout. The command is retried as configured with
+// SetGitRetries when it fails transiently.
+func (g *git) output(dir string, args ...string) ([]byte, error) {
+	var out []byte
+	err := g.retry(func() error {
+		var err error
+		out, err = execWithStderr(g.command(dir, args...))
+		return err
+	})
+	return out, err
+}
+
+// paths is like gitPaths, but retries as configured with SetGitRetries when git
+// fails transiently.
+func (g *git) paths(root string, args ...string) (map[string]struct{}, error) {
+	var paths map[string]struct{}
+	err := g.retry(func() error {
+		var err error
+		paths, err = gitPaths(g.command(root, args...), root)
+		return err
+	})
+	return paths, err
+}
+
+// retry calls f until it succeeds, it fails with an error that is not
+// transient, or it was retried g.retries times. The delay before each retry is
+// twice the previous one, starting with g.retryBackoff.
+func (g *git) retry(f func() error) error {
+	backoff := g.retryBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= g.retries || !isTransientGitError(err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transientGitErrors are the messages of git errors that are likely caused by
+// another git process, e.g. one that holds the lock of the index, and that may
+// succeed when the command is run again.
+var transientGitErrors = []string{
+	".lock': File exists",
+	"index.lock",
+	"Unable to create",
+	"unable to create",
+	"cannot lock ref",
+}
+
+// isTransientGitError returns true when err is the error of a git command that
+// failed transiently.
+func isTransientGitError(err error) bool {
+	msg := err.Error()
+	for _, s := range transientGitErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
 }
 
 // diffPaths returns the path that have changed.
@@ -277,7 +1175,8 @@ func diffPaths(root string, r io.Reader) (map[string]struct{}, error) {
 
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		path := scanner.Text()
+		// git always separates the elements of paths with slashes.
+		path := filepath.FromSlash(scanner.Text())
 
 		// We build our full absolute file path.
 		full, err := filepath.Abs(filepath.Join(root, path))
@@ -296,10 +1195,129 @@ func exists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
-// branchPointOf will return the oldest commit on g.baseBranch that is in
-// branch. If no such commit exists (e.g. branch is a shallow clone or branch
-// does not share history with g.baseBranch), then an empty string is returned.
-func (g *git) branchPointOf(branch string) (string, error) {
+// verifyBaseBranch returns an error that wraps ErrBaseBranchNotFound when
+// base does not name a commit, so that a missing ref is not mistaken for a
+// branch that does not share history with HEAD.
+func (g *git) verifyBaseBranch(base string) error {
+	_, err := g.output("", "rev-parse", "--verify", "--quiet", base+"^{commit}")
+	if err != nil {
+		var exitErr *exec.ExitError
+		// git exits with status 1 when the revision does not exist and with
+		// other statuses when it cannot look for it.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			if g.baseRevisionFile != "" {
+				return fmt.Errorf("%w: %s (read from %s)", ErrBaseBranchNotFound, base, g.baseRevisionFile)
+			}
+			return fmt.Errorf("%w: %s", ErrBaseBranchNotFound, base)
+		}
+		return err
+	}
+	return nil
+}
+
+// nearestBaseBranch returns the one of g.baseBranches that is the nearest to
+// HEAD. See SetBaseBranches.
+func (g *git) nearestBaseBranch() (string, error) {
+	nearest, distance := "", -1
+	for _, base := range g.baseBranches {
+		if g.fetchBase {
+			if err := g.fetchBaseBranch(base); err != nil {
+				return "", err
+			}
+		}
+		if err := g.verifyBaseBranch(base); err != nil {
+			if errors.Is(err, ErrBaseBranchNotFound) {
+				continue
+			}
+			return "", err
+		}
+
+		out, err := g.output("", "rev-list", "--count", "HEAD", "^"+base)
+		if err != nil {
+			return "", err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+		if err != nil {
+			return "", fmt.Errorf("counting the commits of HEAD that are not on %s: %w", base, err)
+		}
+
+		if distance < 0 || n < distance {
+			nearest, distance = base, n
+		}
+	}
+
+	if nearest == "" {
+		return "", fmt.Errorf("%w: none of %s", ErrBaseBranchNotFound, strings.Join(g.baseBranches, ", "))
+	}
+
+	return nearest, nil
+}
+
+// fetchBaseBranch fetches base from its remote unless it already names a
+// commit. Base branches that are not remote-tracking branches are left to
+// verifyBaseBranch.
+func (g *git) fetchBaseBranch(base string) error {
+	if _, err := g.output("", "rev-parse", "--verify", "--quiet", base+"^{commit}"); err == nil {
+		return nil
+	}
+
+	remote, branch, ok := remoteBranch(base)
+	if !ok {
+		return nil
+	}
+
+	// name the destination explicitly, because the remote-tracking ref is not
+	// updated when the fetch refspecs of the remote do not include the branch
+	// (e.g. in a clone of a single branch).
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+	if _, err := g.output("", "fetch", "--quiet", remote, refspec); err != nil {
+		return fmt.Errorf("fetching base branch %s: %w", base, err)
+	}
+	return nil
+}
+
+// remoteBranch returns the remote and the branch of the remote-tracking branch
+// ref (e.g. origin and main for origin/main or refs/remotes/origin/main).
+func remoteBranch(ref string) (remote, branch string, ok bool) {
+	remote, branch, ok = strings.Cut(strings.TrimPrefix(ref, "refs/remotes/"), "/")
+	if !ok || remote == "" || branch == "" {
+		return "", "", false
+	}
+	return remote, branch, true
+}
+
+// branchPointOfShallow returns the branch point of HEAD after fetching the
+// history of the repository when it is a shallow clone and g.autoUnshallow is
+// set. It returns an error wrapping ErrShallowClone when the repository is a
+// shallow clone and g.autoUnshallow is not set, and an empty string when the
+// repository is not a shallow clone.
+func (g *git) branchPointOfShallow(base string) (string, error) {
+	out, err := g.output("", "rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(out)) != "true" {
+		return "", nil
+	}
+
+	if !g.autoUnshallow {
+		return "", fmt.Errorf("%w: the commit from which HEAD was branched from %s is not in its history; fetch more history (e.g. with git fetch --unshallow)", ErrShallowClone, base)
+	}
+
+	if _, err := g.output("", "fetch", "--unshallow"); err != nil {
+		return "", fmt.Errorf("fetching the history of the shallow clone: %w", err)
+	}
+
+	return g.branchPointOf("HEAD", base)
+}
+
+// branchPointOf will return the oldest commit on base that is in branch. If no
+// such commit exists (e.g. branch is a shallow clone or branch does not share
+// history with base), then an empty string is returned.
+// branch may be any revision; only the commits reachable from it are
+// considered, so a detached HEAD has the same branch point as a branch that
+// points to the same commit.
+func (g *git) branchPointOf(branch, base string) (string, error) {
 	// Use --topo-order to ensure graph order is respected.
 	//
 	// Use --parents so each line will list the commit and its parents.
@@ -312,10 +1330,10 @@ func (g *git) branchPointOf(branch string) (string, error) {
 	// from the base branch in branch.
 	//
 	// Do NOT try using git merge-base at all. It would not deliver the right
-	// result when g.baseBranch had been merged into branch sometime after branch
-	// was created from g.baseBranch. In such a case, the merge base would be the
-	// the merge commit where g.baseBranch was merged into branch.
-	out, err := execWithStderr(exec.Command("git", "rev-list", "--topo-order", "--parents", "--reverse", branch, "^"+g.baseBranch))
+	// result when base had been merged into branch sometime after branch was
+	// created from base. In such a case, the merge base would be the the merge
+	// commit where base was merged into branch.
+	out, err := g.output("", "rev-list", "--topo-order", "--parents", "--reverse", branch, "^"+base)
 	if err != nil {
 		return "", nil
 	}
@@ -340,6 +1358,11 @@ func execWithStderr(c *exec.Cmd) (out []byte, err error) {
 	out, err = c.Output()
 	if err != nil {
 		err = fmt.Errorf("%w: %s", err, stderr.String())
+		// git reports the same message for every command that needs a
+		// repository.
+		if strings.Contains(stderr.String(), "not a git repository") {
+			err = fmt.Errorf("%w: %w", ErrNotGitRepository, err)
+		}
 	}
 	return
 }
@@ -7,8 +7,16 @@ in the LICENSE file.
 package gta
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -16,6 +24,9 @@ import (
 // check to make sure Git implements the Differ interface.
 var _ Differ = &differ{}
 
+var _ StatsDiffer = &differ{}
+var _ ResettableDiffer = &differ{}
+
 func Test_diffFileDirectories(t *testing.T) {
 	var tests = []struct {
 		desc string
@@ -71,6 +82,26 @@ bar/baz/qux/corge.go
 			},
 		},
 	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, []struct {
+			desc string
+			root string
+			buf  []byte
+			want map[string]struct{}
+		}{
+			{
+				desc: "slash separated paths on windows",
+				root: `C:\repo`,
+				buf: []byte(`foo/bar.go
+foo/baz/qux.go
+`),
+				want: map[string]struct{}{
+					`C:\repo\foo\bar.go`:     struct{}{},
+					`C:\repo\foo\baz\qux.go`: struct{}{},
+				},
+			},
+		}...)
+	}
 
 	for _, tt := range tests {
 		t.Run(tt.desc, func(t *testing.T) {
@@ -86,3 +117,469 @@ bar/baz/qux/corge.go
 		})
 	}
 }
+
+func TestGitPathsWaitsOnError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	// a line that is too long for diffPaths, followed by more output than fits
+	// in the buffer of a pipe, which must not block the command.
+	cmd := exec.Command("sh", "-c", "head -c 70000 /dev/zero | tr '\\0' a; echo; yes | head -n 100000")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := gitPaths(cmd, "/repo")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, bufio.ErrTooLong) {
+			t.Errorf("err = %v; want %v", err, bufio.ErrTooLong)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("gitPaths did not return")
+	}
+
+	if cmd.ProcessState == nil {
+		t.Error("the command was not waited for")
+	}
+}
+
+func TestNewFileDifferWithRoot(t *testing.T) {
+	var tests = []struct {
+		desc  string
+		root  string
+		files []string
+		want  map[string]bool
+	}{
+		{
+			desc:  "absolute paths",
+			root:  "/foo",
+			files: []string{"/bar/bar.go", "/bar/baz/baz.go"},
+			want: map[string]bool{
+				"/bar/bar.go":     false,
+				"/bar/baz/baz.go": false,
+			},
+		},
+		{
+			desc:  "relative paths",
+			root:  "/foo",
+			files: []string{"bar/bar.go", "./bar/baz/../baz.go"},
+			want: map[string]bool{
+				"/foo/bar/bar.go": false,
+				"/foo/bar/baz.go": false,
+			},
+		},
+		{
+			desc:  "absolute and relative paths",
+			root:  "/foo",
+			files: []string{"/bar/bar.go", "bar/bar.go", "/foo/bar/bar.go"},
+			want: map[string]bool{
+				"/bar/bar.go":     false,
+				"/foo/bar/bar.go": false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := NewFileDifferWithRoot(tt.root, tt.files).DiffFiles()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiffer_ResolveSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links may not be supported")
+	}
+
+	// resolve symlinks in the temporary directory so that only the link that
+	// is created here is resolved.
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "vendored", "foo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("vendored", filepath.Join(root, "third_party")); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]struct{}{
+		filepath.Join(root, "third_party", "foo", "foo.go"):         {},
+		filepath.Join(root, "third_party", "deleted", "deleted.go"): {},
+	}
+
+	tests := []struct {
+		desc            string
+		resolveSymlinks bool
+		want            map[string]Directory
+	}{
+		{
+			desc: "literal paths",
+			want: map[string]Directory{
+				filepath.Join(root, "third_party", "foo"):     {Exists: true, Files: []string{"foo.go"}},
+				filepath.Join(root, "third_party", "deleted"): {Exists: false, Files: []string{"deleted.go"}},
+			},
+		},
+		{
+			desc:            "resolved symlinks",
+			resolveSymlinks: true,
+			want: map[string]Directory{
+				filepath.Join(root, "vendored", "foo"):        {Exists: true, Files: []string{"foo.go"}},
+				filepath.Join(root, "third_party", "deleted"): {Exists: false, Files: []string{"deleted.go"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			d := &differ{
+				diff:            func() (map[string]struct{}, error) { return files, nil },
+				resolveSymlinks: tt.resolveSymlinks,
+			}
+
+			got, err := d.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiffer_DiffStats(t *testing.T) {
+	d := &differ{
+		diff: func() (map[string]struct{}, error) {
+			return map[string]struct{}{
+				"/src/foo/foo.go":         {},
+				"/src/foo/deleted.go":     {},
+				"/src/bar/bar.go":         {},
+				"/src/deleted/deleted.go": {},
+			}, nil
+		},
+		diffGoSum: func() (map[string]struct{}, error) {
+			return map[string]struct{}{"example.com/dep": {}}, nil
+		},
+		exists: func(abs string) bool {
+			return filepath.Base(abs) != "deleted.go"
+		},
+	}
+
+	got, err := d.DiffStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DiffStats{FilesChanged: 4, FilesDeleted: 2, GoSumModulesChanged: 1}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func Test_goSumModules(t *testing.T) {
+	var tests = []struct {
+		desc string
+		buf  []byte
+		want map[string]struct{}
+	}{
+		{
+			desc: "added and removed checksums",
+			buf: []byte(`diff --git a/go.sum b/go.sum
+index 1111111..2222222 100644
+--- a/go.sum
++++ b/go.sum
+@@ -1,2 +1,2 @@
+-example.com/a v1.0.0 h1:aaa=
+-example.com/a v1.0.0/go.mod h1:bbb=
++example.com/a v1.1.0 h1:ccc=
++example.com/a v1.1.0/go.mod h1:ddd=
+@@ -9 +9,0 @@
+-example.com/b v0.1.0 h1:eee=
+`),
+			want: map[string]struct{}{
+				"example.com/a": struct{}{},
+				"example.com/b": struct{}{},
+			},
+		},
+		{
+			desc: "go.mod checksums only",
+			buf: []byte(`--- a/go.sum
++++ b/go.sum
+@@ -3,0 +4 @@
++example.com/c v1.0.0/go.mod h1:fff=
+`),
+			want: map[string]struct{}{},
+		},
+		{
+			desc: "moved checksum",
+			buf: []byte(`--- a/go.sum
++++ b/go.sum
+@@ -1 +0,0 @@
+-example.com/d v1.0.0 h1:ggg=
+@@ -5,0 +5 @@
++example.com/d v1.0.0 h1:ggg=
+`),
+			want: map[string]struct{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := goSumModules(bytes.NewReader(tt.buf))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_numstatLines(t *testing.T) {
+	root := string(filepath.Separator) + "repo"
+
+	var tests = []struct {
+		desc    string
+		out     []byte
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			desc: "text and binary files",
+			out:  []byte("3\t1\tfoo/foo.go\x002\t0\tbar/has\ttab.go\x00-\t-\tfoo/image.png\x00"),
+			want: map[string]int{
+				filepath.Join(root, "foo", "foo.go"):      4,
+				filepath.Join(root, "bar", "has\ttab.go"): 2,
+				filepath.Join(root, "foo", "image.png"):   0,
+			},
+		},
+		{
+			desc: "no changes",
+			out:  nil,
+			want: map[string]int{},
+		},
+		{
+			desc:    "malformed",
+			out:     []byte("x\t1\tfoo.go\x00"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := numstatLines(root, tt.out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGitDiffer_NotGitRepository(t *testing.T) {
+	dir := t.TempDir()
+	// keep git from finding a repository in the ancestors of dir.
+	defer Setenv(t, "GIT_CEILING_DIRECTORIES", filepath.Dir(dir))()
+	t.Chdir(dir)
+
+	d := NewGitDiffer()
+	if _, err := d.DiffFiles(); !errors.Is(err, ErrNotGitRepository) {
+		t.Errorf("DiffFiles() err = %v; want %v", err, ErrNotGitRepository)
+	}
+
+	if _, err := d.(BaseRevisionDiffer).BaseRevision(); !errors.Is(err, ErrNotGitRepository) {
+		t.Errorf("BaseRevision() err = %v; want %v", err, ErrNotGitRepository)
+	}
+}
+
+func Test_remoteBranch(t *testing.T) {
+	tests := []struct {
+		ref        string
+		wantRemote string
+		wantBranch string
+		wantOK     bool
+	}{
+		{ref: "origin/main", wantRemote: "origin", wantBranch: "main", wantOK: true},
+		{ref: "refs/remotes/origin/main", wantRemote: "origin", wantBranch: "main", wantOK: true},
+		{ref: "upstream/release/1.0", wantRemote: "upstream", wantBranch: "release/1.0", wantOK: true},
+		{ref: "main"},
+		{ref: "origin/"},
+	}
+
+	for _, tt := range tests {
+		remote, branch, ok := remoteBranch(tt.ref)
+		if remote != tt.wantRemote || branch != tt.wantBranch || ok != tt.wantOK {
+			t.Errorf("remoteBranch(%q) = %q, %q, %v; want %q, %q, %v", tt.ref, remote, branch, ok, tt.wantRemote, tt.wantBranch, tt.wantOK)
+		}
+	}
+}
+
+func TestGitRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake git is a shell script")
+	}
+
+	// fakeGit returns a git that fails with stderr the first failures times it
+	// is run and prints ok afterwards, and a function that returns the number of
+	// times it was run.
+	fakeGit := func(t *testing.T, failures int, stderr string) func() int {
+		t.Helper()
+		dir := t.TempDir()
+		calls := filepath.Join(dir, "calls")
+		script := fmt.Sprintf(`#!/bin/sh
+echo >> %[1]q
+if [ "$(wc -l < %[1]q)" -le %[2]d ]; then
+	echo %[3]q >&2
+	exit 128
+fi
+echo ok
+`, calls, failures, stderr)
+		if err := os.WriteFile(filepath.Join(dir, "git"), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(Setenv(t, "PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH")))
+
+		return func() int {
+			b, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return bytes.Count(b, []byte("\n"))
+		}
+	}
+
+	const lockErr = "fatal: Unable to create '/repo/.git/index.lock': File exists."
+
+	tests := []struct {
+		desc      string
+		retries   int
+		failures  int
+		stderr    string
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			desc:      "transient failure retried",
+			retries:   2,
+			failures:  2,
+			stderr:    lockErr,
+			wantCalls: 3,
+		},
+		{
+			desc:      "too many transient failures",
+			retries:   1,
+			failures:  2,
+			stderr:    lockErr,
+			wantErr:   true,
+			wantCalls: 2,
+		},
+		{
+			desc:      "not retried by default",
+			failures:  1,
+			stderr:    lockErr,
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			desc:      "other failure not retried",
+			retries:   2,
+			failures:  1,
+			stderr:    "fatal: bad revision 'origin/nope'",
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			calls := fakeGit(t, tt.failures, tt.stderr)
+
+			g := &git{}
+			SetGitRetries(tt.retries, time.Millisecond)(g)
+
+			out, err := g.output("", "status")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(out) != "ok\n" {
+				t.Errorf("out = %q; want %q", out, "ok\n")
+			}
+			if got := calls(); got != tt.wantCalls {
+				t.Errorf("git was run %d times; want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGitBinaryAndEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the git binary is a shell script")
+	}
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	bin := filepath.Join(dir, "wrapped-git")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$GTA_TEST_ENV $*" >> %q
+echo ok
+`, log)
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &git{}
+	for _, opt := range []GitDifferOption{
+		SetGitBinary(bin),
+		SetGitEnv([]string{"GTA_TEST_ENV=set"}),
+	} {
+		opt(g)
+	}
+
+	root, err := g.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != "ok" {
+		t.Errorf("root = %q; want %q", root, "ok")
+	}
+
+	if _, err := g.paths(dir, "diff", "--name-only"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "set rev-parse --show-toplevel\nset diff --name-only\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
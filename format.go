@@ -0,0 +1,170 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is a format in which Packages can be written.
+type Format int
+
+const (
+	// FormatText writes the import path of each package in AllChanges on its
+	// own line.
+	FormatText Format = iota
+	// FormatJSON writes the JSON encoding of the packages, as returned by
+	// MarshalJSON, followed by a newline.
+	FormatJSON
+	// FormatJSONL writes each package in AllChanges on its own line as a JSON
+	// object with its import path, directory, whether it is a command, its
+	// module, its number of changed lines and the reason it is included. The
+	// reason is changed when the package is in Changes, and dependent
+	// otherwise.
+	FormatJSONL
+	// FormatCSV writes a header followed by a record with the import path,
+	// directory and whether it is a command of each package in AllChanges.
+	FormatCSV
+)
+
+// Formatted returns an io.WriterTo that writes p in format. The packages are
+// written to the writer as they are formatted instead of being formatted in
+// memory first.
+func (p *Packages) Formatted(format Format) io.WriterTo {
+	return &formattedPackages{
+		packages: p,
+		format:   format,
+	}
+}
+
+// formattedPackages implements io.WriterTo for Packages in a format.
+type formattedPackages struct {
+	packages *Packages
+	format   Format
+}
+
+// WriteTo implements the io.WriterTo interface.
+func (fp *formattedPackages) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	var err error
+	switch fp.format {
+	case FormatText:
+		bw := bufio.NewWriter(cw)
+		for _, pkg := range fp.packages.AllChanges {
+			fmt.Fprintln(bw, pkg.ImportPath)
+		}
+		err = bw.Flush()
+	case FormatJSON:
+		err = json.NewEncoder(cw).Encode(fp.packages)
+	case FormatJSONL:
+		changes := make(map[string]struct{}, len(fp.packages.Changes))
+		for _, pkg := range fp.packages.Changes {
+			changes[pkg.ImportPath] = struct{}{}
+		}
+
+		enc := json.NewEncoder(cw)
+		for _, pkg := range fp.packages.AllChanges {
+			reason := reasonDependent
+			if _, ok := changes[pkg.ImportPath]; ok {
+				reason = reasonChanged
+			}
+
+			err = enc.Encode(jsonlPackage{ImportPath: pkg.ImportPath, Dir: pkg.Dir, IsCommand: pkg.IsCommand, Module: pkg.Module, ChangedLines: pkg.ChangedLines, Reason: reason})
+			if err != nil {
+				break
+			}
+		}
+	case FormatCSV:
+		csvw := csv.NewWriter(cw)
+		csvw.Write([]string{"import_path", "dir", "is_command"})
+		for _, pkg := range fp.packages.AllChanges {
+			csvw.Write([]string{pkg.ImportPath, pkg.Dir, strconv.FormatBool(pkg.IsCommand)})
+		}
+		csvw.Flush()
+		err = csvw.Error()
+	default:
+		err = fmt.Errorf("unknown format %d", fp.format)
+	}
+
+	return cw.n, err
+}
+
+// BuildConstraintFile writes the source of a Go file to w that blank imports
+// the packages in AllChanges, so that building the file with a single go build
+// invocation compiles all of them. Packages that cannot be imported are
+// omitted: deleted packages (i.e. those whose Dir is empty), commands,
+// external test packages and internal packages, which can only be imported
+// from within the tree rooted at the parent of their internal directory. The
+// changed internal packages are still compiled when one of the other changed
+// packages imports them.
+func (p *Packages) BuildConstraintFile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "// Code generated by gta. DO NOT EDIT.")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "// Package main imports the changed packages so that building it builds all")
+	fmt.Fprintln(bw, "// of them.")
+	fmt.Fprintln(bw, "package main")
+	fmt.Fprintln(bw)
+
+	var importPaths []string
+	for _, pkg := range p.AllChanges {
+		if pkg.Dir == "" || pkg.IsCommand || strings.HasSuffix(pkg.ImportPath, "_test") {
+			continue
+		}
+		if parent, ok := internalParent(pkg.ImportPath); ok && parent != "" {
+			continue
+		}
+		importPaths = append(importPaths, pkg.ImportPath)
+	}
+
+	if len(importPaths) > 0 {
+		fmt.Fprintln(bw, "import (")
+		for _, importPath := range importPaths {
+			fmt.Fprintf(bw, "\t_ %s\n", strconv.Quote(importPath))
+		}
+		fmt.Fprintln(bw, ")")
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintln(bw, "func main() {}")
+	return bw.Flush()
+}
+
+// The reasons a package is included in the FormatJSONL output.
+const (
+	reasonChanged   = "changed"
+	reasonDependent = "dependent"
+)
+
+// jsonlPackage is a line of the FormatJSONL output.
+type jsonlPackage struct {
+	ImportPath   string `json:"import_path"`
+	Dir          string `json:"dir,omitempty"`
+	IsCommand    bool   `json:"is_command,omitempty"`
+	Module       string `json:"module,omitempty"`
+	ChangedLines int    `json:"changed_lines,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPackages_Formatted(t *testing.T) {
+	pkgs := &Packages{
+		Dependencies: map[string][]Package{
+			"foo": []Package{
+				{ImportPath: "bar", Dir: "/src/bar"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "foo", Dir: "/src/foo", IsCommand: true},
+		},
+		AllChanges: []Package{
+			{ImportPath: "bar", Dir: "/src/bar"},
+			{ImportPath: "deleted"},
+			{ImportPath: "foo", Dir: "/src/foo", IsCommand: true},
+		},
+	}
+
+	tests := []struct {
+		desc   string
+		format Format
+		want   string
+	}{
+		{
+			desc:   "text",
+			format: FormatText,
+			want: `bar
+deleted
+foo
+`,
+		},
+		{
+			desc:   "json",
+			format: FormatJSON,
+			want: `{"version":1,"dependencies":{"foo":["bar"]},"changes":["foo"],"all_changes":["bar","deleted","foo"]}
+`,
+		},
+		{
+			desc:   "jsonl",
+			format: FormatJSONL,
+			want: `{"import_path":"bar","dir":"/src/bar","reason":"dependent"}
+{"import_path":"deleted","reason":"dependent"}
+{"import_path":"foo","dir":"/src/foo","is_command":true,"reason":"changed"}
+`,
+		},
+		{
+			desc:   "csv",
+			format: FormatCSV,
+			want: `import_path,dir,is_command
+bar,/src/bar,false
+deleted,,false
+foo,/src/foo,true
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := pkgs.Formatted(tt.format).WriteTo(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+
+			if n != int64(buf.Len()) {
+				t.Errorf("n = %d; want %d", n, buf.Len())
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := pkgs.Formatted(Format(-1)).WriteTo(&buf); err == nil {
+			t.Error("expected an error for an unknown format")
+		}
+	})
+}
+
+func TestPackages_BuildConstraintFile(t *testing.T) {
+	pkgs := &Packages{
+		AllChanges: []Package{
+			{ImportPath: "example.com/bar", Dir: "/src/bar"},
+			{ImportPath: "example.com/bar_test", Dir: "/src/bar"},
+			{ImportPath: "example.com/deleted"},
+			{ImportPath: "example.com/foo", Dir: "/src/foo"},
+			{ImportPath: "example.com/foo/cmd", Dir: "/src/foo/cmd", IsCommand: true},
+			{ImportPath: "example.com/foo/internal/baz", Dir: "/src/foo/internal/baz"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := pkgs.BuildConstraintFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), "gta.go", buf.Bytes(), parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("parsing the generated file: %v\n%s", err, buf.String())
+	}
+
+	var got []string
+	for _, spec := range f.Imports {
+		if spec.Name == nil || spec.Name.Name != "_" {
+			t.Errorf("import %s is not a blank import", spec.Path.Value)
+		}
+
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, importPath)
+	}
+
+	want := []string{"example.com/bar", "example.com/foo"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(formatted), buf.String()); diff != "" {
+		t.Errorf("generated file is not gofmt'd (-want, +got)\n%s", diff)
+	}
+}
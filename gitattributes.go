@@ -0,0 +1,172 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitattributes answers questions about the attributes of files from the
+// .gitattributes files in their directories and the directories' ancestors.
+// Only the subset of the gitattributes syntax that is needed to find
+// linguist-generated files is supported.
+type gitattributes struct {
+	// rules is a cache of the parsed .gitattributes files keyed by the
+	// absolute path of the directory that contains them.
+	rules map[string][]attrRule
+}
+
+// attrRule is a single line of a .gitattributes file that sets or unsets the
+// linguist-generated attribute.
+type attrRule struct {
+	pattern   string
+	generated bool
+}
+
+func newGitattributes() *gitattributes {
+	return &gitattributes{
+		rules: make(map[string][]attrRule),
+	}
+}
+
+// isGenerated returns true when abs is marked as linguist-generated. Rules in
+// deeper directories take precedence over rules in their ancestors, and later
+// rules take precedence over earlier rules in the same file.
+func (ga *gitattributes) isGenerated(abs string) (bool, error) {
+	var dirs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+
+		// .gitattributes files outside of the repository do not apply.
+		if exists(filepath.Join(dir, ".git")) || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	var generated bool
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		rules, err := ga.load(dir)
+		if err != nil {
+			return false, err
+		}
+
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil {
+			return false, err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, rule := range rules {
+			if matchAttrPattern(rule.pattern, rel) {
+				generated = rule.generated
+			}
+		}
+	}
+
+	return generated, nil
+}
+
+// load returns the linguist-generated rules of the .gitattributes file in dir.
+func (ga *gitattributes) load(dir string) ([]attrRule, error) {
+	if rules, ok := ga.rules[dir]; ok {
+		return rules, nil
+	}
+
+	f, err := os.Open(filepath.Join(dir, ".gitattributes"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			ga.rules[dir] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []attrRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, attr := range fields[1:] {
+			switch attr {
+			case "linguist-generated", "linguist-generated=true":
+				rules = append(rules, attrRule{pattern: fields[0], generated: true})
+			case "-linguist-generated", "!linguist-generated", "linguist-generated=false":
+				rules = append(rules, attrRule{pattern: fields[0], generated: false})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	ga.rules[dir] = rules
+	return rules, nil
+}
+
+// matchAttrPattern reports whether the slash separated path name, which is
+// relative to the directory of the .gitattributes file, matches pattern. A
+// pattern without a slash matches the base name of a file at any depth, and
+// ** matches any number of directories.
+func matchAttrPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// allGenerated returns true when files, which are relative to dir, are not
+// empty and are all linguist-generated.
+func (ga *gitattributes) allGenerated(dir string, files []string) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
+	}
+
+	for _, fn := range files {
+		generated, err := ga.isGenerated(filepath.Join(dir, fn))
+		if err != nil || !generated {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import "testing"
+
+func TestMatchAttrPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "*.pb.go", name: "foo.pb.go", want: true},
+		{pattern: "*.pb.go", name: "a/b/foo.pb.go", want: true},
+		{pattern: "*.pb.go", name: "a/b/foo.go", want: false},
+		{pattern: "a/*.pb.go", name: "a/foo.pb.go", want: true},
+		{pattern: "/a/*.pb.go", name: "a/foo.pb.go", want: true},
+		{pattern: "a/*.pb.go", name: "b/a/foo.pb.go", want: false},
+		{pattern: "**/gen/*.go", name: "a/b/gen/foo.go", want: true},
+		{pattern: "**/gen/*.go", name: "gen/foo.go", want: true},
+		{pattern: "gen/**", name: "gen/a/b/foo.go", want: true},
+		{pattern: "gen/**", name: "other/foo.go", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchAttrPattern(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchAttrPattern(%q, %q) = %v; want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
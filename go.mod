@@ -6,11 +6,11 @@ require (
 	github.com/google/go-cmp v0.5.2
 	github.com/pkg/errors v0.8.0
 	golang.org/x/crypto v0.31.0
+	golang.org/x/mod v0.20.0
 	golang.org/x/tools v0.13.0
 )
 
 require (
-	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/term v0.27.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,143 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/mod/modfile"
+)
+
+// goModFiles returns the go.mod in dir and the go.mod before it was changed. A
+// go.mod that does not exist is returned as an empty file. It returns
+// ErrNoBase when the go.mod's contents before the change are not known, and a
+// modfile.ErrorList when one of the files cannot be parsed.
+func goModFiles(bd BaseDiffer, dir string) (head, base *modfile.File, err error) {
+	fn := filepath.Join(dir, "go.mod")
+
+	headData, err := os.ReadFile(fn)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	baseData, err := bd.BaseFile(fn)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	// modfile.ParseLax ignores the toolchain and godebug directives.
+	head, err = modfile.Parse(fn, headData, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base, err = modfile.Parse(fn, baseData, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return head, base, nil
+}
+
+// goModChanged returns true when changed reports a difference between the
+// go.mod in dir and the go.mod before it was changed. It returns false when the
+// go.mod's contents before the change are not known, and true when one of the
+// files cannot be parsed, e.g. because it uses directives that are newer than
+// golang.org/x/mod.
+func goModChanged(bd BaseDiffer, dir string, changed func(head, base *modfile.File) bool) (bool, error) {
+	head, base, err := goModFiles(bd, dir)
+	switch {
+	case errors.Is(err, ErrNoBase):
+		return false, nil
+	case errors.As(err, new(modfile.ErrorList)):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	return changed(head, base), nil
+}
+
+// godebugChanged returns true when the godebug settings of the go.mod in dir
+// differ from its godebug settings before it was changed. Unlike goModChanged,
+// it returns true when the go.mod's contents before the change are not known
+// or the go.mod did not exist, because the programs' default GODEBUG settings
+// may have changed then.
+func godebugChanged(bd BaseDiffer, dir string) (bool, error) {
+	head, base, err := goModFiles(bd, dir)
+	switch {
+	case errors.Is(err, ErrNoBase), errors.As(err, new(modfile.ErrorList)):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	// A go.mod that does not exist is returned as an empty file, which does
+	// not have a module directive.
+	if base.Module == nil {
+		return true, nil
+	}
+
+	return !slices.EqualFunc(head.Godebug, base.Godebug, func(h, b *modfile.Godebug) bool {
+		return h.Key == b.Key && h.Value == b.Value
+	}), nil
+}
+
+// goVersionChanged returns true when the go or toolchain directives of the
+// go.mod in dir differ from its directives before it was changed.
+func goVersionChanged(bd BaseDiffer, dir string) (bool, error) {
+	return goModChanged(bd, dir, func(head, base *modfile.File) bool {
+		return goVersion(head) != goVersion(base) || toolchain(head) != toolchain(base)
+	})
+}
+
+// goVersion returns the version of the go directive of f, or an empty string
+// when f does not have one.
+func goVersion(f *modfile.File) string {
+	if f.Go == nil {
+		return ""
+	}
+	return f.Go.Version
+}
+
+// toolchain returns the name of the toolchain directive of f, or an empty
+// string when f does not have one.
+func toolchain(f *modfile.File) string {
+	if f.Toolchain == nil {
+		return ""
+	}
+	return f.Toolchain.Name
+}
+
+// isMainDir returns true when the Go files in dir belong to a main package.
+func isMainDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		if entry.IsDir() || !isAPIFile(entry.Name()) {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, entry.Name()), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		return f.Name.Name == "main"
+	}
+
+	return false
+}
@@ -6,6 +6,12 @@ in the LICENSE file.
 */
 package gta
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Graph is an adjacency list representation of a graph using maps.
 type Graph struct {
 	graph map[string]map[string]bool
@@ -28,3 +34,185 @@ func (g *Graph) Traverse(node string, mark map[string]bool) {
 
 	return
 }
+
+// TraverseDepth is like Traverse, but it only marks the nodes that are at
+// most depth edges away from node. A negative depth does not limit the
+// traversal.
+func (g *Graph) TraverseDepth(node string, depth int, mark map[string]bool) {
+	if depth < 0 {
+		g.Traverse(node, mark)
+		return
+	}
+
+	g.traverseBreadthFirst(node, depth, false, mark)
+}
+
+// traverseBreadthFirst marks the nodes that are at most depth edges away from
+// node. A negative depth does not limit the traversal. When productionOnly is
+// true, the edges to dependents that only import a node from _test.go files
+// are not followed.
+func (g *Graph) traverseBreadthFirst(node string, depth int, productionOnly bool, mark map[string]bool) {
+	// traverse breadth first so that each node is reached by the shortest path
+	// from node.
+	mark[node] = true
+	frontier := []string{node}
+	for i := 0; (depth < 0 || i < depth) && len(frontier) > 0; i++ {
+		var next []string
+		for _, n := range frontier {
+			for edge, nonTest := range g.graph[n] {
+				if mark[edge] || (productionOnly && !nonTest) {
+					continue
+				}
+				mark[edge] = true
+				next = append(next, edge)
+			}
+		}
+		frontier = next
+	}
+}
+
+// traverseTestDependents marks the nodes that are at most depth edges away
+// from node through edges to dependents that import a node from non-test
+// files. The dependents that only import a marked node from _test.go files
+// are marked too, but their own dependents are not, because only their tests
+// are affected. A negative depth does not limit the traversal.
+func (g *Graph) traverseTestDependents(node string, depth int, mark map[string]bool) {
+	mark[node] = true
+
+	// expanded is the set of nodes whose dependents have been marked; a node
+	// that was marked through a test-only edge is still expanded when it is
+	// also reached through a non-test edge.
+	expanded := map[string]bool{node: true}
+	frontier := []string{node}
+	for i := 0; (depth < 0 || i < depth) && len(frontier) > 0; i++ {
+		var next []string
+		for _, n := range frontier {
+			for edge, nonTest := range g.graph[n] {
+				mark[edge] = true
+				if !nonTest || expanded[edge] {
+					continue
+				}
+				expanded[edge] = true
+				next = append(next, edge)
+			}
+		}
+		frontier = next
+	}
+}
+
+// alias returns a copy of g where each alias in aliases (alias import path ->
+// canonical import path) shares its dependents with its canonical import path.
+func (g *Graph) alias(aliases map[string]string) *Graph {
+	graph := make(map[string]map[string]bool, len(g.graph))
+	for k, v := range g.graph {
+		graph[k] = v
+	}
+
+	for alias, canonical := range aliases {
+		merged := make(map[string]bool)
+		for _, node := range []string{alias, canonical} {
+			for edge, v := range g.graph[node] {
+				if edge == alias || edge == canonical {
+					continue
+				}
+				merged[edge] = v
+			}
+		}
+
+		if len(merged) == 0 {
+			continue
+		}
+
+		graph[alias] = merged
+		graph[canonical] = merged
+	}
+
+	return &Graph{graph: graph}
+}
+
+// dependencies returns the set of nodes that node depends on, directly or
+// transitively, including node itself. It is the inverse of Traverse: a change
+// to any of the returned nodes would mark node.
+func (g *Graph) dependencies(node string) map[string]struct{} {
+	inverse := make(map[string][]string)
+	for dependency, dependents := range g.graph {
+		for dependent := range dependents {
+			inverse[dependent] = append(inverse[dependent], dependency)
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var visit func(node string)
+	visit = func(node string) {
+		if _, ok := seen[node]; ok {
+			return
+		}
+		seen[node] = struct{}{}
+
+		for _, dependency := range inverse[node] {
+			visit(dependency)
+		}
+	}
+	visit(node)
+
+	return seen
+}
+
+// nodes returns the set of the nodes of the graph: the packages that are
+// imported and the packages that import them.
+func (g *Graph) nodes() map[string]struct{} {
+	nodes := make(map[string]struct{})
+	for dependency, dependents := range g.graph {
+		nodes[dependency] = struct{}{}
+		for dependent := range dependents {
+			nodes[dependent] = struct{}{}
+		}
+	}
+	return nodes
+}
+
+// validateInternal returns an error that wraps ErrInternalImport and lists the
+// edges of g from internal packages to dependents that are outside of the
+// trees rooted at the parents of their internal directories.
+func (g *Graph) validateInternal() error {
+	var invalid []string
+	for dependency, dependents := range g.graph {
+		parent, ok := internalParent(dependency)
+		if !ok {
+			continue
+		}
+
+		for dependent := range dependents {
+			// the external tests of a package are allowed to import what the
+			// package can import.
+			importer := strings.TrimSuffix(dependent, "_test")
+			if parent == "" || importer == parent || strings.HasPrefix(importer, parent+"/") {
+				continue
+			}
+			invalid = append(invalid, fmt.Sprintf("%s imports %s", dependent, dependency))
+		}
+	}
+
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	sort.Strings(invalid)
+	return fmt.Errorf("%w: %s", ErrInternalImport, strings.Join(invalid, "; "))
+}
+
+// internalParent returns the import path of the parent of the last internal
+// element of importPath, and whether importPath has an internal element at
+// all. The parent is empty when the first element is internal.
+func internalParent(importPath string) (string, bool) {
+	if strings.HasSuffix(importPath, "/internal") {
+		return strings.TrimSuffix(importPath, "/internal"), true
+	}
+	if i := strings.LastIndex(importPath, "/internal/"); i >= 0 {
+		return importPath[:i], true
+	}
+	if importPath == "internal" || strings.HasPrefix(importPath, "internal/") {
+		return "", true
+	}
+	return "", false
+}
@@ -7,6 +7,7 @@ in the LICENSE file.
 package gta
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -91,3 +92,190 @@ func TestGraphTraversal(t *testing.T) {
 		}
 	}
 }
+
+func TestGraphTraverseDepth(t *testing.T) {
+	// A depends on B depends on C depends on D, A depends on D
+	graph := &Graph{
+		graph: map[string]map[string]bool{
+			"D": map[string]bool{
+				"C": true,
+				"A": true,
+			},
+			"C": map[string]bool{
+				"B": true,
+			},
+			"B": map[string]bool{
+				"A": true,
+			},
+		},
+	}
+
+	tests := []struct {
+		depth   int
+		want    map[string]bool
+		comment string
+	}{
+		{
+			comment: "depth 0 only marks the start",
+			depth:   0,
+			want: map[string]bool{
+				"D": true,
+			},
+		},
+		{
+			comment: "depth 1 marks the direct dependents",
+			depth:   1,
+			want: map[string]bool{
+				"A": true,
+				"C": true,
+				"D": true,
+			},
+		},
+		{
+			comment: "depth 2 marks the dependents of the direct dependents",
+			depth:   2,
+			want: map[string]bool{
+				"A": true,
+				"B": true,
+				"C": true,
+				"D": true,
+			},
+		},
+		{
+			comment: "a negative depth marks all dependents",
+			depth:   -1,
+			want: map[string]bool{
+				"A": true,
+				"B": true,
+				"C": true,
+				"D": true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Log(tt.comment)
+		got := map[string]bool{}
+		graph.TraverseDepth("D", tt.depth, got)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func TestGraphTraverseTestDependents(t *testing.T) {
+	// B imports A, C imports A from its tests, D imports C, E imports A from
+	// its tests and B from non-test files.
+	graph := &Graph{
+		graph: map[string]map[string]bool{
+			"A": map[string]bool{
+				"B": true,
+				"C": false,
+				"E": false,
+			},
+			"B": map[string]bool{
+				"E": true,
+			},
+			"C": map[string]bool{
+				"D": true,
+			},
+			"E": map[string]bool{
+				"F": true,
+			},
+		},
+	}
+
+	tests := []struct {
+		depth   int
+		want    map[string]bool
+		comment string
+	}{
+		{
+			comment: "depth 1 marks the direct dependents",
+			depth:   1,
+			want: map[string]bool{
+				"A": true,
+				"B": true,
+				"C": true,
+				"E": true,
+			},
+		},
+		{
+			comment: "a negative depth does not mark the dependents of test dependents",
+			depth:   -1,
+			want: map[string]bool{
+				"A": true,
+				"B": true,
+				"C": true,
+				"E": true,
+				"F": true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Log(tt.comment)
+		got := map[string]bool{}
+		graph.traverseTestDependents("A", tt.depth, got)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func TestGraphValidateInternal(t *testing.T) {
+	tests := []struct {
+		graph   map[string]map[string]bool
+		wantErr bool
+		comment string
+	}{
+		{
+			comment: "packages in the tree of the parent of internal",
+			graph: map[string]map[string]bool{
+				"a/internal/b": map[string]bool{
+					"a":                   true,
+					"a/c":                 true,
+					"a/internal/d":        true,
+					"a/internal/b_test":   false,
+					"a_test":              false,
+					"a/c/internal/e/f/g":  true,
+					"a/internal/b/h/test": true,
+				},
+			},
+		},
+		{
+			comment: "top level internal",
+			graph: map[string]map[string]bool{
+				"internal/a": map[string]bool{
+					"b": true,
+				},
+			},
+		},
+		{
+			comment: "sibling of the parent of internal",
+			graph: map[string]map[string]bool{
+				"a/internal/b": map[string]bool{
+					"ab": true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			comment: "nested internal",
+			graph: map[string]map[string]bool{
+				"a/internal/b/internal": map[string]bool{
+					"a/c": true,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Log(tt.comment)
+		err := (&Graph{graph: tt.graph}).validateInternal()
+		if got := errors.Is(err, ErrInternalImport); got != tt.wantErr {
+			t.Errorf("validateInternal() = %v; want error %t", err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// graphCacheVersion identifies the format of graphCache. It must be
+// incremented whenever graphCache changes so that caches that were written in
+// another format are not used.
+const graphCacheVersion = 7
+
+// graphCacheKeyPrefix is the prefix of the keys of dependency graphs in a
+// Cache, which keeps them apart from other values in the same Cache.
+const graphCacheKeyPrefix = "graph:"
+
+// graphCache is the cached representation of the dependency graph of a
+// packageContext.
+type graphCache struct {
+	Version             int                            `json:"version"`
+	ModuleNamesByDir    map[string]string              `json:"module_names_by_dir"`
+	Forward             map[string]map[string]struct{} `json:"forward"`
+	Reverse             map[string]map[string]bool     `json:"reverse"`
+	PackagesByEmbedFile map[string][]string            `json:"packages_by_embed_file"`
+	EmbedPatterns       map[string][]string            `json:"embed_patterns"`
+	DirsByPackage       map[string]string              `json:"dirs_by_package"`
+	Commands            map[string]struct{}            `json:"commands"`
+	// LoadErrors maps the import paths of the packages that could not be
+	// loaded completely to their errors.
+	LoadErrors map[string]string `json:"load_errors,omitempty"`
+}
+
+// newCachedPackager returns a Packager like newOverlayPackager that loads the
+// packages matched by patterns, or all packages when patterns is empty, but
+// the dependency graph is read from c when it holds a graph for key.
+// Otherwise, the packages are loaded and their dependency graph is stored in
+// c.
+func newCachedPackager(patterns, tags, buildFlags, env []string, overlay map[string][]byte, c Cache, key string) Packager {
+	key = graphCacheKeyPrefix + key
+
+	gc, err := readGraphCache(c, key)
+	if err == nil {
+		mergedTags, _ := mergeTagsFlag(tags, buildFlags)
+		return gc.packageContext(newBuildContext(mergedTags, env))
+	}
+
+	p := newOverlayPackager(patterns, tags, buildFlags, env, overlay).(*packageContext)
+	if p.err == nil {
+		// a graph that cannot be cached only means that the packages will be
+		// loaded again the next time.
+		_ = writeGraphCache(c, key, p.graphCache())
+	}
+
+	return p
+}
+
+// lazyPackager is a Packager whose methods use the Packager returned by load,
+// which is called once, when the first method is called. It defers loading the
+// packages and computing the graph cache key until the changed packages are
+// determined.
+type lazyPackager struct {
+	load func() Packager
+
+	once sync.Once
+	p    Packager
+}
+
+func (l *lazyPackager) packager() Packager {
+	l.once.Do(func() {
+		l.p = l.load()
+	})
+	return l.p
+}
+
+func (l *lazyPackager) PackageFromDir(dir string) (*Package, error) {
+	return l.packager().PackageFromDir(dir)
+}
+
+func (l *lazyPackager) PackageFromEmptyDir(dir string) (*Package, error) {
+	return l.packager().PackageFromEmptyDir(dir)
+}
+
+func (l *lazyPackager) PackageFromImport(importPath string) (*Package, error) {
+	return l.packager().PackageFromImport(importPath)
+}
+
+func (l *lazyPackager) DependentGraph() (*Graph, error) {
+	return l.packager().DependentGraph()
+}
+
+func (l *lazyPackager) EmbeddedBy(fn string) []string {
+	return l.packager().EmbeddedBy(fn)
+}
+
+func (l *lazyPackager) importPaths() []string {
+	if il, ok := l.packager().(importPathLister); ok {
+		return il.importPaths()
+	}
+	return nil
+}
+
+// graphCache returns the representation of p's dependency graph.
+func (p *packageContext) graphCache() *graphCache {
+	var loadErrors map[string]string
+	if ge, ok := p.loadErr.(*graphError); ok {
+		loadErrors = make(map[string]string, len(ge.Errors))
+		for importPath, err := range ge.Errors {
+			loadErrors[importPath] = err.Error()
+		}
+	}
+
+	return &graphCache{
+		Version:             graphCacheVersion,
+		ModuleNamesByDir:    p.modulesNamesByDir,
+		Forward:             p.forward,
+		Reverse:             p.reverse,
+		PackagesByEmbedFile: p.packagesByEmbedFile,
+		EmbedPatterns:       p.embedPatterns,
+		DirsByPackage:       p.dirsByPackage,
+		Commands:            p.commands,
+		LoadErrors:          loadErrors,
+	}
+}
+
+// packageContext returns a packageContext with gc's dependency graph that
+// finds packages in directories with ctx.
+func (gc *graphCache) packageContext(ctx build.Context) *packageContext {
+	var loadErr error
+	if len(gc.LoadErrors) > 0 {
+		ge := &graphError{Errors: make(map[string]error, len(gc.LoadErrors))}
+		for importPath, msg := range gc.LoadErrors {
+			ge.Errors[importPath] = errors.New(msg)
+		}
+		loadErr = ge
+	}
+
+	return &packageContext{
+		ctx:                 &ctx,
+		loadErr:             loadErr,
+		forward:             gc.Forward,
+		reverse:             gc.Reverse,
+		modulesNamesByDir:   gc.ModuleNamesByDir,
+		packagesByEmbedFile: gc.PackagesByEmbedFile,
+		embedPatterns:       gc.EmbedPatterns,
+		dirsByPackage:       gc.DirsByPackage,
+		packagesByDir:       packagesByDir(gc.DirsByPackage),
+		commands:            gc.Commands,
+	}
+}
+
+// ErrUnsupportedGraphVersion is returned by NewJSONPackager when the graph was
+// written by a version of gta whose format differs from this one's.
+var ErrUnsupportedGraphVersion = errors.New("unsupported dependency graph version")
+
+// DumpJSON writes the JSON encoding of p's dependency graph to w, e.g. to
+// compute the graph once per commit and read it with NewJSONPackager instead
+// of loading the packages again. The Packager returned by NewPackager
+// implements it:
+//
+//	p := gta.NewPackager(nil, nil)
+//	err := p.(interface{ DumpJSON(io.Writer) error }).DumpJSON(w)
+func (p *packageContext) DumpJSON(w io.Writer) error {
+	if p.err != nil {
+		return p.err
+	}
+	return json.NewEncoder(w).Encode(p.graphCache())
+}
+
+// NewJSONPackager returns a Packager whose dependency graph is read from r,
+// which holds a graph written by DumpJSON, instead of being loaded with the go
+// command. The packages in changed directories are still found with the
+// default build.Context, so the graph must have been written for the same
+// revision and build tags.
+func NewJSONPackager(r io.Reader) (Packager, error) {
+	gc := new(graphCache)
+	if err := json.NewDecoder(r).Decode(gc); err != nil {
+		return nil, fmt.Errorf("decoding dependency graph: %w", err)
+	}
+	if gc.Version != graphCacheVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedGraphVersion, gc.Version)
+	}
+
+	return gc.packageContext(newBuildContext(nil, nil)), nil
+}
+
+// errGraphCacheMiss is returned by readGraphCache when c does not hold a
+// graph for the key.
+var errGraphCacheMiss = errors.New("dependency graph is not cached")
+
+func readGraphCache(c Cache, key string) (*graphCache, error) {
+	b, ok := c.Get(key)
+	if !ok {
+		return nil, errGraphCacheMiss
+	}
+
+	gc := new(graphCache)
+	if err := json.Unmarshal(b, gc); err != nil {
+		return nil, err
+	}
+	return gc, nil
+}
+
+// writeGraphCache stores gc in c for key.
+func writeGraphCache(c Cache, key string, gc *graphCache) error {
+	b, err := json.Marshal(gc)
+	if err != nil {
+		return err
+	}
+
+	c.Put(key, b)
+	return nil
+}
+
+// graphCacheKey returns the key of the dependency graph of the packages after
+// the changes. It identifies the base revision, the changes to it, the go.mod
+// and go.sum files, the build tags, the overlay and the version of the go
+// command, whose standard library is part of the graph. The key is empty when
+// the graph must not be cached: when the differ does not know the base
+// revision or when a go.mod or go.sum file was changed.
+func (g *GTA) graphCacheKey() (string, error) {
+	rd, ok := g.differ.(BaseRevisionDiffer)
+	if !ok {
+		return "", nil
+	}
+
+	rev, err := rd.BaseRevision()
+	if err != nil {
+		if errors.Is(err, ErrNoBase) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	files, err := g.differ.DiffFiles()
+	if err != nil {
+		return "", err
+	}
+
+	goVersion, err := g.goVersion()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\n", graphCacheVersion)
+	fmt.Fprintf(h, "go version %s\n", goVersion)
+	fmt.Fprintf(h, "revision %s\n", rev)
+	fmt.Fprintf(h, "tags %s\n", strings.Join(g.tags, ","))
+	fmt.Fprintf(h, "modules %s\n", strings.Join(g.modules, ","))
+	fmt.Fprintf(h, "build flags %q\n", g.buildFlags)
+	fmt.Fprintf(h, "env %q\n", g.env())
+	if g.scopedLoad {
+		fmt.Fprintf(h, "patterns %q\n", g.loadPatterns())
+	}
+
+	changed := make([]string, 0, len(files))
+	for fn := range files {
+		if isModuleFile(fn) {
+			return "", nil
+		}
+		changed = append(changed, fn)
+	}
+	sort.Strings(changed)
+
+	for _, fn := range changed {
+		if err := hashFile(h, fn); err != nil {
+			return "", err
+		}
+	}
+
+	overlaid := make([]string, 0, len(g.overlay))
+	for fn := range g.overlay {
+		overlaid = append(overlaid, fn)
+	}
+	sort.Strings(overlaid)
+
+	for _, fn := range overlaid {
+		fmt.Fprintf(h, "overlay %s %x\n", fn, sha256.Sum256(g.overlay[fn]))
+	}
+
+	for _, root := range g.roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				if isIgnoredByGo(path, g.roots) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !isModuleFile(path) {
+				return nil
+			}
+			return hashFile(h, path)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// goVersion returns the version of the go command that loads the packages
+// (e.g. go1.23.4), which depends on GOTOOLCHAIN and the toolchain directives.
+func (g *GTA) goVersion() (string, error) {
+	cmd := exec.Command("go", "env", "GOVERSION")
+	if env := g.env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	b, err := execWithStderr(cmd)
+	if err != nil {
+		return "", fmt.Errorf("could not get the go version: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// isModuleFile returns true when fn is a file that describes modules and their
+// dependencies.
+func isModuleFile(fn string) bool {
+	switch filepath.Base(fn) {
+	case "go.mod", "go.sum", "go.work", "go.work.sum":
+		return true
+	}
+	return false
+}
+
+// hashFile writes the name of the file fn and a digest of its contents to h.
+func hashFile(h hash.Hash, fn string) error {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(h, "deleted %s\n", fn)
+			return nil
+		}
+		return err
+	}
+
+	fmt.Fprintf(h, "file %s %x\n", fn, sha256.Sum256(b))
+	return nil
+}
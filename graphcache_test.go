@@ -0,0 +1,313 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/tools/go/packages/packagestest"
+)
+
+func TestGraphCacheKey(t *testing.T) {
+	root := t.TempDir()
+	dirA := filepath.Join(root, "a")
+	writeFile := func(fn, contents string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(filepath.Join(root, "go.mod"), "module example.com/a\n")
+	writeFile(filepath.Join(root, "go.sum"), "example.com/b v1.0.0 h1:aaa=\n")
+	writeFile(filepath.Join(dirA, "a.go"), "package a\n")
+
+	key := func(difr *testDiffer) string {
+		t.Helper()
+		sut, err := New(SetDiffer(difr), SetPackager(&testPackager{}), SetRoots(root))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := sut.graphCacheKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			dirA: {Exists: true, Files: []string{"a.go"}},
+		},
+		revision: "abc",
+	}
+
+	want := key(difr)
+	if want == "" {
+		t.Fatal("expected a key")
+	}
+
+	if got := key(difr); got != want {
+		t.Errorf("key = %q; want %q for the same changes", got, want)
+	}
+
+	if got := key(&testDiffer{diff: difr.diff, revision: "def"}); got == want {
+		t.Error("key did not change when the base revision changed")
+	}
+
+	if got := key(&testDiffer{diff: difr.diff}); got != "" {
+		t.Errorf("key = %q; want no key when the base revision is not known", got)
+	}
+
+	writeFile(filepath.Join(dirA, "a.go"), "package a\n\nimport _ \"example.com/b\"\n")
+	if got := key(difr); got == want {
+		t.Error("key did not change when a changed file changed")
+	}
+	want = key(difr)
+
+	writeFile(filepath.Join(root, "go.sum"), "example.com/b v1.1.0 h1:bbb=\n")
+	if got := key(difr); got == want {
+		t.Error("key did not change when go.sum changed")
+	}
+
+	difr.diff[root] = Directory{Exists: true, Files: []string{"go.sum"}}
+	if got := key(difr); got != "" {
+		t.Errorf("key = %q; want no key when go.sum is one of the changes", got)
+	}
+}
+
+func TestNewCachedPackager(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		c := newMemCache()
+
+		err := writeGraphCache(c, graphCacheKeyPrefix+"key", &graphCache{
+			Forward: map[string]map[string]struct{}{
+				"A": {"B": struct{}{}},
+				"B": {},
+			},
+			Reverse: map[string]map[string]bool{
+				"B": {"A": true},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Graph{
+			graph: map[string]map[string]bool{
+				"B": {"A": true},
+			},
+		}
+
+		got, err := newCachedPackager(nil, nil, nil, nil, nil, c, "key").DependentGraph()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want.graph, got.graph); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+
+		if c.hits != 1 || c.misses != 0 {
+			t.Errorf("hits, misses = %d, %d; want 1, 0", c.hits, c.misses)
+		}
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		c := newMemCache()
+
+		// another key must not be used.
+		err := writeGraphCache(c, graphCacheKeyPrefix+"stale", &graphCache{
+			Reverse: map[string]map[string]bool{
+				"B": {"A": true},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readGraphCache(c, graphCacheKeyPrefix+"key"); err != errGraphCacheMiss {
+			t.Errorf("err = %v; want %v", err, errGraphCacheMiss)
+		}
+		if c.hits != 0 || c.misses != 1 {
+			t.Errorf("hits, misses = %d, %d; want 0, 1", c.hits, c.misses)
+		}
+	})
+
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		exportGTATest(t, exporter, testModule)
+
+		c := &singleFileCache{fn: filepath.Join(t.TempDir(), "graph")}
+
+		// a cache with another key must be replaced.
+		err := writeGraphCache(c, graphCacheKeyPrefix+"stale", &graphCache{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := newCachedPackager(nil, nil, nil, nil, nil, c, "key").DependentGraph()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readGraphCache(c, graphCacheKeyPrefix+"key"); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := newCachedPackager(nil, nil, nil, nil, nil, c, "key").DependentGraph()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want.graph, got.graph); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		// testdata/unbuildable has a package that cannot be parsed, and its
+		// error must be returned from the cache too.
+		exportTestdata(t, exporter, testModule, "unbuildable")
+
+		c := &singleFileCache{fn: filepath.Join(t.TempDir(), "graph")}
+
+		want, wantErr := newCachedPackager(nil, nil, nil, nil, nil, c, "key").DependentGraph()
+		if want == nil {
+			t.Fatal(wantErr)
+		}
+		if wantErr == nil {
+			t.Fatal("err = nil; want the error of the package that cannot be parsed")
+		}
+
+		got, gotErr := newCachedPackager(nil, nil, nil, nil, nil, c, "key").DependentGraph()
+		if got == nil {
+			t.Fatal(gotErr)
+		}
+
+		if diff := cmp.Diff(want.graph, got.graph); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+
+		if diff := cmp.Diff(fmt.Sprint(wantErr), fmt.Sprint(gotErr)); diff != "" {
+			t.Errorf("error (-want, +got)\n%s", diff)
+		}
+	})
+}
+
+// revisionErrorDiffer is a testDiffer whose BaseRevision fails.
+type revisionErrorDiffer struct {
+	*testDiffer
+}
+
+func (revisionErrorDiffer) BaseRevision() (string, error) {
+	return "", errors.New("git failed")
+}
+
+func TestGTA_GraphCacheKeyError(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		difr := revisionErrorDiffer{&testDiffer{
+			diff: map[string]Directory{
+				exporter.Filename(e, testModule, "foo"): {Exists: true, Files: []string{"foo.go"}},
+			},
+		}}
+
+		// the key is computed when the changed packages are, so New must not
+		// fail.
+		c := newMemCache()
+		sut, err := New(SetDiffer(difr), SetCache(c), SetPrefixes(testModule+"/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			testModule + "/fooclient",
+			testModule + "/fooclientclient",
+		}
+		if diff := cmp.Diff(want, stringify(got.Dependencies[testModule+"/foo"])); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+
+		if len(c.values) != 0 || c.hits != 0 || c.misses != 0 {
+			t.Errorf("the cache was used with %d values, %d hits and %d misses; want the packages to be loaded without it", len(c.values), c.hits, c.misses)
+		}
+	})
+}
+
+func TestJSONPackager(t *testing.T) {
+	t.Run("unsupported version", func(t *testing.T) {
+		_, err := NewJSONPackager(strings.NewReader(`{"version":1}`))
+		if !errors.Is(err, ErrUnsupportedGraphVersion) {
+			t.Errorf("err = %v; want %v", err, ErrUnsupportedGraphVersion)
+		}
+	})
+
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		exportGTATest(t, exporter, testModule)
+
+		want := newOverlayPackager(nil, nil, nil, nil, nil).(*packageContext)
+
+		var buf bytes.Buffer
+		if err := want.DumpJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := NewJSONPackager(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want.graphCache(), got.(*packageContext).graphCache()); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+
+		wantGraph, wantErr := want.DependentGraph()
+		gotGraph, gotErr := got.DependentGraph()
+		if diff := cmp.Diff(wantGraph.graph, gotGraph.graph); diff != "" {
+			t.Errorf("graph (-want, +got)\n%s", diff)
+		}
+		if diff := cmp.Diff(fmt.Sprint(wantErr), fmt.Sprint(gotErr)); diff != "" {
+			t.Errorf("error (-want, +got)\n%s", diff)
+		}
+
+		importPath := testModule + "/foo"
+		wantPkg, err := want.PackageFromImport(importPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotPkg, err := got.PackageFromImport(importPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(wantPkg, gotPkg); diff != "" {
+			t.Errorf("PackageFromImport(%q) (-want, +got)\n%s", importPath, diff)
+		}
+	})
+}
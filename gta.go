@@ -12,10 +12,13 @@ import (
 	"fmt"
 	"go/build"
 	"go/scanner"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
@@ -25,10 +28,37 @@ var (
 	ErrNoDiffer = errors.New("there is no differ set")
 	// ErrNoPackager is returned when there is no packager set on the GTA.
 	ErrNoPackager = errors.New("there is no packager set")
+	// ErrInternalImport is returned when the dependency graph is validated
+	// and a package imports an internal package that it is not allowed to
+	// import.
+	ErrInternalImport = errors.New("use of internal package not allowed")
+	// ErrTooManyChanges is wrapped by the *TooManyChangesError returned when
+	// more packages changed than allowed by SetMaxChangedPackages.
+	ErrTooManyChanges = errors.New("too many changed packages")
 )
 
+// TooManyChangesError is returned by ChangedPackages when more packages
+// changed than allowed by SetMaxChangedPackages.
+type TooManyChangesError struct {
+	// Count is the number of changed packages, including the dependents.
+	Count int
+	// Max is the maximum number of changed packages.
+	Max int
+}
+
+func (e *TooManyChangesError) Error() string {
+	return fmt.Sprintf("%v: %d packages changed, more than the maximum of %d", ErrTooManyChanges, e.Count, e.Max)
+}
+
+// Unwrap returns ErrTooManyChanges.
+func (e *TooManyChangesError) Unwrap() error {
+	return ErrTooManyChanges
+}
+
 // Packages contains various detailed information about the structure of
-// packages GTA has detected.
+// packages GTA has detected. The slices of packages returned by
+// ChangedPackages are sorted by import path so that its results do not depend
+// on the order in which maps are iterated.
 type Packages struct {
 	// Dependencies contains a map of changed packages to their dependencies
 	Dependencies map[string][]Package
@@ -39,51 +69,203 @@ type Packages struct {
 	// AllChanges represents all packages that are dirty including the initial
 	// changed packages.
 	AllChanges []Package
+
+	// Directories contains a map of the absolute paths of the directories the
+	// differ reported as changed to the names of their changed files. It is
+	// only populated when requested with SetIncludeDirectories.
+	Directories map[string][]string
+
+	// Moves contains pairs of a deleted package and an added package that have
+	// the same exported API, which likely means the package was moved. It is
+	// only populated when requested with SetDetectMoves.
+	Moves [][2]Package
+
+	// TestOnlyChanges contains the packages of AllChanges that are only
+	// affected by the changes through _test.go files: packages whose only
+	// changes were to their tests and dependents that only import affected
+	// packages from _test.go files, directly or transitively. It is only
+	// populated when requested with SetIncludeTestOnlyChanges.
+	TestOnlyChanges []Package
+
+	// Reasons contains a map of the import paths of the packages in Changes to
+	// the reasons they were changed: "deleted", "changed go files", "changed
+	// embedded files", "changed go.mod", "changed go.sum" or "changed test
+	// files". When several reasons apply to a package, the first of them in
+	// that order is used. It is only populated when requested with
+	// SetIncludeReasons.
+	Reasons map[string]string
+
+	// GoVersionChanged is true when the go or toolchain directive of a changed
+	// go.mod file was changed, which can change how every package of the module
+	// is built, e.g. so that callers can choose to build everything. It is
+	// only set when the differ provides the contents of files before they were
+	// changed, e.g. a differ created by NewGitDiffer.
+	GoVersionChanged bool
 }
 
+// JSONVersion is the version of the JSON encoding of Packages, which is
+// written to its version field. It is incremented when the shape of the
+// encoding changes so that consumers can tell the encodings apart. Encodings
+// without a version field predate it and are version 1.
+const JSONVersion = 1
+
+// ErrUnsupportedJSONVersion is returned when decoding the JSON encoding of
+// Packages whose version is newer than JSONVersion.
+var ErrUnsupportedJSONVersion = errors.New("unsupported JSON version")
+
 type packagesJSON struct {
+	Version      int                 `json:"version"`
 	Dependencies map[string][]string `json:"dependencies,omitempty"`
 	Changes      []string            `json:"changes,omitempty"`
 	AllChanges   []string            `json:"all_changes,omitempty"`
+	Directories  map[string][]string `json:"directories,omitempty"`
+	Moves        [][2]string         `json:"moves,omitempty"`
+
+	TestOnlyChanges  []string          `json:"test_only_changes,omitempty"`
+	Reasons          map[string]string `json:"reasons,omitempty"`
+	GoVersionChanged bool              `json:"go_version_changed,omitempty"`
 }
 
-// MarshalJSON implements the json.Marshaler interface.
+// MarshalJSON implements the json.Marshaler interface. The keys of maps are
+// sorted by encoding/json, so the encoding of the value returned by
+// ChangedPackages is the same for the same changes. The encoding includes its
+// version, JSONVersion.
 func (p *Packages) MarshalJSON() ([]byte, error) {
 	s := packagesJSON{
+		Version:      JSONVersion,
 		Dependencies: mapify(p.Dependencies),
 		Changes:      stringify(p.Changes),
 		AllChanges:   stringify(p.AllChanges),
+		Directories:  p.Directories,
+
+		TestOnlyChanges:  stringify(p.TestOnlyChanges),
+		Reasons:          p.Reasons,
+		GoVersionChanged: p.GoVersionChanged,
+	}
+	for _, move := range p.Moves {
+		s.Moves = append(s.Moves, [2]string{move[0].ImportPath, move[1].ImportPath})
+	}
+	return json.Marshal(s)
+}
+
+// packagesFullJSON is the JSON representation of Packages where each package
+// is an object instead of an import path.
+type packagesFullJSON struct {
+	Version      int                      `json:"version"`
+	Dependencies map[string][]packageJSON `json:"dependencies,omitempty"`
+	Changes      []packageJSON            `json:"changes,omitempty"`
+	AllChanges   []packageJSON            `json:"all_changes,omitempty"`
+	Directories  map[string][]string      `json:"directories,omitempty"`
+	Moves        [][2]packageJSON         `json:"moves,omitempty"`
+
+	TestOnlyChanges  []packageJSON     `json:"test_only_changes,omitempty"`
+	Reasons          map[string]string `json:"reasons,omitempty"`
+	GoVersionChanged bool              `json:"go_version_changed,omitempty"`
+}
+
+type packageJSON struct {
+	ImportPath   string `json:"import_path"`
+	Dir          string `json:"dir,omitempty"`
+	IsCommand    bool   `json:"is_command,omitempty"`
+	Module       string `json:"module,omitempty"`
+	ChangedLines int    `json:"changed_lines,omitempty"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. A package may be
+// represented by either its import path or an object.
+func (p *packageJSON) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &p.ImportPath)
+	}
+
+	type plain packageJSON
+	return json.Unmarshal(b, (*plain)(p))
+}
+
+// MarshalJSONFull returns the JSON encoding of p. Unlike MarshalJSON, each
+// package is encoded as an object with its import path, directory and module
+// instead of only its import path.
+func (p *Packages) MarshalJSONFull() ([]byte, error) {
+	s := packagesFullJSON{
+		Version:      JSONVersion,
+		Dependencies: make(map[string][]packageJSON),
+		Changes:      objectify(p.Changes),
+		AllChanges:   objectify(p.AllChanges),
+		Directories:  p.Directories,
+
+		TestOnlyChanges:  objectify(p.TestOnlyChanges),
+		Reasons:          p.Reasons,
+		GoVersionChanged: p.GoVersionChanged,
+	}
+	for k, v := range p.Dependencies {
+		s.Dependencies[k] = objectify(v)
+	}
+	for _, move := range p.Moves {
+		moved := objectify(move[:])
+		s.Moves = append(s.Moves, [2]packageJSON{moved[0], moved[1]})
 	}
 	return json.Marshal(s)
 }
 
 // UnmarshalJSON used by gtartifacts when providing a changed package list
-// see `useChangedPackagesFrom()`
+// see `useChangedPackagesFrom()`. It accepts the output of both MarshalJSON
+// and MarshalJSONFull, with or without a version, and returns an error that
+// wraps ErrUnsupportedJSONVersion when the version is newer than JSONVersion.
 func (p *Packages) UnmarshalJSON(b []byte) error {
-	s := new(packagesJSON)
+	s := new(packagesFullJSON)
 
 	if err := json.Unmarshal(b, &s); err != nil {
 		return err
 	}
 
+	if s.Version > JSONVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedJSONVersion, s.Version)
+	}
+
 	p.Dependencies = make(map[string][]Package)
 	for k, v := range s.Dependencies {
 		for _, vv := range v {
-			p.Dependencies[k] = append(p.Dependencies[k], Package{ImportPath: vv})
+			p.Dependencies[k] = append(p.Dependencies[k], Package{ImportPath: vv.ImportPath, Dir: vv.Dir, IsCommand: vv.IsCommand, Module: vv.Module, ChangedLines: vv.ChangedLines})
 		}
 	}
 
 	for _, v := range s.Changes {
-		p.Changes = append(p.Changes, Package{ImportPath: v})
+		p.Changes = append(p.Changes, Package{ImportPath: v.ImportPath, Dir: v.Dir, IsCommand: v.IsCommand, Module: v.Module, ChangedLines: v.ChangedLines})
 	}
 
 	for _, v := range s.AllChanges {
-		p.AllChanges = append(p.AllChanges, Package{ImportPath: v})
+		p.AllChanges = append(p.AllChanges, Package{ImportPath: v.ImportPath, Dir: v.Dir, IsCommand: v.IsCommand, Module: v.Module, ChangedLines: v.ChangedLines})
+	}
+
+	p.Directories = s.Directories
+	p.Reasons = s.Reasons
+	p.GoVersionChanged = s.GoVersionChanged
+
+	for _, v := range s.TestOnlyChanges {
+		p.TestOnlyChanges = append(p.TestOnlyChanges, Package{ImportPath: v.ImportPath, Dir: v.Dir, IsCommand: v.IsCommand, Module: v.Module, ChangedLines: v.ChangedLines})
+	}
+
+	for _, v := range s.Moves {
+		p.Moves = append(p.Moves, [2]Package{
+			{ImportPath: v[0].ImportPath, Dir: v[0].Dir, IsCommand: v[0].IsCommand, Module: v[0].Module, ChangedLines: v[0].ChangedLines},
+			{ImportPath: v[1].ImportPath, Dir: v[1].Dir, IsCommand: v[1].IsCommand, Module: v[1].Module, ChangedLines: v[1].ChangedLines},
+		})
 	}
 
 	return nil
 }
 
+// BlastRadius returns the number of packages that each package in Changes
+// affects: the package itself and its dependents in Dependencies. The keys
+// are the import paths of the changed packages.
+func (p *Packages) BlastRadius() map[string]int {
+	radius := make(map[string]int, len(p.Changes))
+	for _, pkg := range p.Changes {
+		radius[pkg.ImportPath] = len(p.Dependencies[pkg.ImportPath]) + 1
+	}
+	return radius
+}
+
 // A GTA provides a method of building dirty packages, and their dependent
 // packages.
 type GTA struct {
@@ -92,13 +274,52 @@ type GTA struct {
 	prefixes []string
 	tags     []string
 	roots    []string
+	aliases  map[string]string
+
+	useGitattributes   bool
+	includeDirectories bool
+	overlay            map[string][]byte
+	useGoSum           bool
+	detectMoves        bool
+	apiChangeDetection bool
+
+	goModChangesWholeModule bool
+	godebugChangesMains     bool
+	maxPackageDepth         int
+	cache                   Cache
+	maxDepth                int
+	unifyTestAndProd        bool
+	includeTestOnlyChanges  bool
+	strict                  bool
+	includeUnbuildable      bool
+	logger                  *slog.Logger
+	modules                 []string
+	includeChangedLines     bool
+	ignoreFilePatterns      []string
+	buildFlags              []string
+	cgo                     *bool
+	excludes                []string
+	includeRegexps          []*regexp.Regexp
+	includeReasons          bool
+	validateInternal        bool
+	stripPrefix             string
+	scopedLoad              bool
+	maxChangedPackages      int
+	ignoreTestFiles         bool
+
+	// propagateThroughTestImports is true when changes propagate through the
+	// imports from _test.go files.
+	propagateThroughTestImports bool
 }
 
 // New returns a new GTA with various options passed to New. Options will be
 // applied in order so that later options can override earlier options.
 func New(opts ...Option) (*GTA, error) {
 	gta := &GTA{
-		differ: NewGitDiffer(),
+		differ:   NewGitDiffer(),
+		maxDepth: -1,
+
+		propagateThroughTestImports: true,
 	}
 
 	for _, opt := range opts {
@@ -108,6 +329,10 @@ func New(opts ...Option) (*GTA, error) {
 		}
 	}
 
+	if gta.roots == nil && len(gta.modules) > 0 {
+		gta.roots = gta.modules
+	}
+
 	if gta.roots == nil {
 		roots, err := toplevel()
 		if err != nil {
@@ -116,31 +341,96 @@ func New(opts ...Option) (*GTA, error) {
 		gta.roots = roots
 	}
 
+	excludes, err := gta.gtaignore()
+	if err != nil {
+		return nil, err
+	}
+	gta.excludes = append(excludes, gta.excludes...)
+
 	// set the default packager after applying option so that the default
 	// packager implementation does not load packages unnecessarily when the
 	// packager is provided as an option.
 	if gta.packager == nil {
-		/*
-			patterns, err := patternsFrom(gta.differ, gta.prefixes)
-			if err != nil {
-				return nil, err
-			}
-
-			gta.packager = NewPackager(patterns, gta.tags)
-		*/
-
 		// Cause NewPackager to return a packager that loads all packages by
-		// passing a nil pattern.  This is important to ensure that all packages
-		// are loaded and that nothing is skipped based on build tag constraints
-		// when a file is changed. e.g. if a vendored file that is constrained to
-		// Windows is changed, that package wouldn't load at all and trying to find
-		// the package's dependencies would fail.
-		gta.packager = NewPackager(nil, gta.tags)
+		// passing a nil pattern, unless SetScopedLoad opted into loading only
+		// the packages of the prefixes.  This is important to ensure that all
+		// packages are loaded and that nothing is skipped based on build tag
+		// constraints when a file is changed. e.g. if a vendored file that is
+		// constrained to Windows is changed, that package wouldn't load at all
+		// and trying to find the package's dependencies would fail.
+		gta.packager = gta.defaultPackager()
 	}
 
 	return gta, nil
 }
 
+// defaultPackager returns the packager to use when no packager was provided as
+// an option. When a graph cache is set, the packages are loaded, or their
+// dependency graph is read from the cache, when the packager is first used,
+// because the cache key depends on the changes.
+func (g *GTA) defaultPackager() Packager {
+	if g.cache == nil {
+		return newOverlayPackager(g.loadPatterns(), g.tags, g.buildFlags, g.env(), g.overlay)
+	}
+
+	return &lazyPackager{load: g.cachedPackager}
+}
+
+// cachedPackager returns a packager whose dependency graph is read from the
+// graph cache when it is valid for the changes. The packages are loaded
+// without the cache when the cache key cannot be computed.
+func (g *GTA) cachedPackager() Packager {
+	key, err := g.graphCacheKey()
+	if err != nil {
+		g.log().Warn("computing the graph cache key failed; loading the packages without the cache", "error", err)
+		key = ""
+	}
+
+	if key == "" {
+		return newOverlayPackager(g.loadPatterns(), g.tags, g.buildFlags, g.env(), g.overlay)
+	}
+
+	return newCachedPackager(g.loadPatterns(), g.tags, g.buildFlags, g.env(), g.overlay, g.cache, key)
+}
+
+// env returns the environment variables, as key=value pairs, that are added
+// to the environment of the go command when the packages are loaded.
+func (g *GTA) env() []string {
+	if g.cgo == nil {
+		return nil
+	}
+	if *g.cgo {
+		return []string{"CGO_ENABLED=1"}
+	}
+	return []string{"CGO_ENABLED=0"}
+}
+
+// loadPatterns returns the patterns of the packages that the default packager
+// loads: the patterns of the prefixes when the load is scoped with
+// SetScopedLoad, and otherwise the patterns of the modules.
+func (g *GTA) loadPatterns() []string {
+	if !g.scopedLoad || len(g.prefixes) == 0 {
+		return g.modulePatterns()
+	}
+
+	patterns := make([]string, 0, len(g.prefixes))
+	for _, prefix := range g.prefixes {
+		patterns = append(patterns, path.Join(prefix, "..."))
+	}
+	return patterns
+}
+
+// modulePatterns returns the patterns of the packages of the modules set with
+// SetModules. It returns nil when no modules were set so that all packages are
+// loaded.
+func (g *GTA) modulePatterns() []string {
+	var patterns []string
+	for _, dir := range g.modules {
+		patterns = append(patterns, filepath.Join(dir, "..."))
+	}
+	return patterns
+}
+
 // ChangedPackages uses the differ and packager to build a map of changed root
 // packages to their dependent packages where dependent is defined as "changed"
 // as well due to their dependency to the changed packages. It returns the
@@ -162,102 +452,685 @@ func New(opts ...Option) (*GTA, error) {
 //	Dependencies = {"foo": ["bar", "qux"], "foo2" : ["afa", "bar", "qux"]}
 //	Changes      = ["foo", "foo2"]
 //	AllChanges   = ["foo", "foo2", "afa", "bar", "qux]
+//
+// ChangedPackages does not modify g or its packager, and each call returns a
+// new *Packages that shares no state with the results of other calls, so it
+// may be called repeatedly or concurrently. The differs returned by
+// NewGitDiffer compute the changes once, so the result does not change
+// between calls unless the differ reports different changes; use a new GTA
+// with a new differ to analyze new changes.
+//
+// ChangedPackages collects all the packages before returning them; use
+// WalkChanged to process them as they are marked.
 func (g *GTA) ChangedPackages() (*Packages, error) {
-	paths, err := g.markedPackages()
+	cp := &Packages{
+		Dependencies: map[string][]Package{},
+	}
+
+	// build our packages
+	allChanges := map[string]Package{}
+	testOnly, reasons, err := g.walkChanged(func(changed string, pkg Package) error {
+		// a vendored package has the same import path as the package it is a
+		// copy of once vendor is stripped; keep the one with a directory.
+		if existing, ok := allChanges[pkg.ImportPath]; !ok || existing.Dir == "" {
+			allChanges[pkg.ImportPath] = pkg
+		}
+		if changed == pkg.ImportPath {
+			cp.Changes = append(cp.Changes, pkg)
+		} else {
+			cp.Dependencies[changed] = append(cp.Dependencies[changed], pkg)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	cp := &Packages{
-		Dependencies: map[string][]Package{},
+	for changed, packages := range cp.Dependencies {
+		sort.Sort(byPackageImportPath(packages))
+		cp.Dependencies[changed] = dedupePackages(packages)
+	}
+
+	for _, pkg := range allChanges {
+		cp.AllChanges = append(cp.AllChanges, pkg)
+	}
+	sort.Sort(byPackageImportPath(cp.AllChanges))
+	sort.Sort(byPackageImportPath(cp.Changes))
+	cp.Changes = dedupePackages(cp.Changes)
+
+	if g.includeReasons {
+		cp.Reasons = make(map[string]string, len(cp.Changes))
+		for _, pkg := range cp.Changes {
+			cp.Reasons[pkg.ImportPath] = reasons[pkg.ImportPath].String()
+		}
+	}
+
+	if g.includeTestOnlyChanges {
+		for _, pkg := range cp.AllChanges {
+			if _, ok := testOnly[pkg.ImportPath]; ok {
+				cp.TestOnlyChanges = append(cp.TestOnlyChanges, pkg)
+			}
+		}
+	}
+
+	if g.unifyTestAndProd {
+		unifyTestPackages(cp)
+	}
+
+	if g.maxChangedPackages > 0 && len(cp.AllChanges) > g.maxChangedPackages {
+		return nil, &TooManyChangesError{Count: len(cp.AllChanges), Max: g.maxChangedPackages}
+	}
+
+	if g.detectMoves {
+		cp.Moves, err = g.moves(cp.Changes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if g.includeDirectories {
+		dirs, err := g.differ.Diff()
+		if err != nil {
+			return nil, fmt.Errorf("diffing directory for changed directories, %v", err)
+		}
+
+		cp.Directories = make(map[string][]string, len(dirs))
+		for abs, dir := range dirs {
+			files := append([]string(nil), dir.Files...)
+			sort.Strings(files)
+			cp.Directories[abs] = files
+		}
+	}
+
+	if bd, ok := g.differ.(BaseDiffer); ok {
+		cp.GoVersionChanged, err = g.goVersionChanged(bd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if g.includeChangedLines {
+		if ld, ok := g.differ.(LineDiffer); ok {
+			if err := setChangedLines(ld, cp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if g.stripPrefix != "" {
+		stripPrefix(cp, g.stripPrefix)
+	}
+
+	return cp, nil
+}
+
+// goVersionChanged returns true when the go or toolchain directive of one of
+// the go.mod files that bd reports as changed was changed.
+func (g *GTA) goVersionChanged(bd BaseDiffer) (bool, error) {
+	dirs, err := bd.Diff()
+	if err != nil {
+		return false, fmt.Errorf("diffing directory for changed go.mod files, %v", err)
+	}
+
+	for abs, dir := range dirs {
+		if !dir.Exists || !hasFile(dir.Files, "go.mod") {
+			continue
+		}
+
+		changed, err := goVersionChanged(bd, abs)
+		if err != nil {
+			return false, fmt.Errorf("comparing go version of module %q, %v", abs, err)
+		}
+		if changed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dedupePackages returns pkgs, which are sorted by import path, without the
+// packages whose import paths are repeated. Of the packages with the same
+// import path, the first one with a directory is kept.
+func dedupePackages(pkgs []Package) []Package {
+	if len(pkgs) == 0 {
+		return pkgs
+	}
+
+	out := pkgs[:1]
+	for _, pkg := range pkgs[1:] {
+		last := &out[len(out)-1]
+		if pkg.ImportPath != last.ImportPath {
+			out = append(out, pkg)
+			continue
+		}
+		if last.Dir == "" {
+			*last = pkg
+		}
+	}
+	return out
+}
+
+// setChangedLines sets the ChangedLines field of the packages in cp to the
+// number of lines that were changed in their directories according to ld.
+func setChangedLines(ld LineDiffer, cp *Packages) error {
+	lines, err := ld.DiffLines()
+	if err != nil {
+		return fmt.Errorf("diffing lines of changed files, %v", err)
+	}
+
+	dirLines := make(map[string]int)
+	for abs, n := range lines {
+		dirLines[filepath.Dir(abs)] += n
+	}
+
+	set := func(packages []Package) {
+		for i := range packages {
+			if packages[i].Dir != "" {
+				packages[i].ChangedLines = dirLines[packages[i].Dir]
+			}
+		}
+	}
+
+	set(cp.Changes)
+	set(cp.AllChanges)
+	set(cp.TestOnlyChanges)
+	for _, packages := range cp.Dependencies {
+		set(packages)
+	}
+	return nil
+}
+
+// Changes returns the packages that were changed according to the differ. It
+// is equivalent to the Changes field of the value returned by
+// ChangedPackages, but does not mark the dependents of the changed packages.
+func (g *GTA) Changes() ([]Package, error) {
+	changed, err := g.MarkedRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Package
+	for importPath, deleted := range changed {
+		if !g.includes(importPath) {
+			g.log().Debug("excluded package", "package", importPath)
+			continue
+		}
+
+		pkg := &Package{ImportPath: importPath}
+		if !deleted {
+			pkg, err = g.packager.PackageFromImport(importPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		changes = append(changes, *pkg)
+	}
+	sort.Sort(byPackageImportPath(changes))
+
+	if g.unifyTestAndProd {
+		changes = unifyTestPackageList(changes)
+	}
+
+	return changes, nil
+}
+
+// AllPackages returns all of the packages that the packager loaded and that
+// match the prefixes, sorted by import path, regardless of the differ, e.g. to
+// compare the packages returned by ChangedPackages with those of a full build.
+// Like the directories returned by DependencyDirs, only the packages in the
+// roots are included, which leaves out the standard library and the modules
+// that the roots depend on.
+func (g *GTA) AllPackages() ([]Package, error) {
+	if g.packager == nil {
+		return nil, ErrNoPackager
+	}
+
+	graph, err := g.dependentGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	importPaths := graph.nodes()
+	if l, ok := g.packager.(importPathLister); ok {
+		importPaths = make(map[string]struct{})
+		for _, importPath := range l.importPaths() {
+			importPaths[importPath] = struct{}{}
+		}
+	}
+
+	var pkgs []Package
+	for importPath := range importPaths {
+		if !g.includes(importPath) {
+			continue
+		}
+
+		pkg, err := g.packager.PackageFromImport(importPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.IsAbs(pkg.Dir) && isWithinRoots(pkg.Dir, g.roots) {
+			pkgs = append(pkgs, *pkg)
+		}
+	}
+	sort.Sort(byPackageImportPath(pkgs))
+	pkgs = dedupePackages(pkgs)
+
+	if g.unifyTestAndProd {
+		pkgs = unifyTestPackageList(pkgs)
+	}
+
+	return pkgs, nil
+}
+
+// Dependents returns the packages that would be marked as dependents if the
+// package identified by importPath changed, regardless of the differ. The
+// dependents are filtered by the prefixes and limited by the maximum depth like
+// the dependents of ChangedPackages, and they are sorted by import path. The
+// package itself is not included. An error is returned when the package is not
+// in the dependency graph.
+func (g *GTA) Dependents(importPath string) ([]Package, error) {
+	if g.packager == nil {
+		return nil, ErrNoPackager
+	}
+
+	graph, err := g.dependentGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := g.packager.PackageFromImport(importPath); err != nil {
+		return nil, err
+	}
+
+	marked := make(map[string]bool)
+	g.traverse(graph, importPath, marked)
+
+	var dependents []Package
+	for dependent := range marked {
+		if dependent == importPath || !g.includes(dependent) {
+			continue
+		}
+
+		pkg, err := g.packager.PackageFromImport(dependent)
+		if err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, *pkg)
+	}
+	sort.Sort(byPackageImportPath(dependents))
+
+	return dependents, nil
+}
+
+// traverse marks the dependents of node in graph according to the configured
+// maximum depth and whether changes propagate through test imports.
+func (g *GTA) traverse(graph *Graph, node string, marked map[string]bool) {
+	if !g.propagateThroughTestImports {
+		graph.traverseTestDependents(node, g.maxDepth, marked)
+		return
+	}
+	graph.TraverseDepth(node, g.maxDepth, marked)
+}
+
+// Watchers returns the directories whose changes would mark the package
+// identified by importPath as changed: the directories of the package and of
+// the packages it depends on, directly or transitively. Only directories
+// below the roots are returned, so the directories of packages in the
+// standard library or in the module cache are omitted. The returned
+// directories are sorted.
+func (g *GTA) Watchers(importPath string) ([]string, error) {
+	if g.packager == nil {
+		return nil, ErrNoPackager
+	}
+
+	graph, err := g.dependentGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for dependency := range graph.dependencies(importPath) {
+		pkg, err := g.packager.PackageFromImport(dependency)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.IsAbs(pkg.Dir) && isWithinRoots(pkg.Dir, g.roots) {
+			dirs = append(dirs, pkg.Dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// MarkedRoots returns the packages that were changed according to the differ,
+// from which their dependents are marked. The keys of the returned map are
+// import paths, and its values are true when the package was deleted and false
+// when it exists. Like MarkedPackages, the packages are not filtered by the
+// prefixes. The keys are the same as those of the map returned by
+// MarkedPackages.
+func (g *GTA) MarkedRoots() (map[string]bool, error) {
+	changed, _, _, _, err := g.seedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	// the go.sum changes can only be applied to packages in the dependency
+	// graph.
+	if g.useGoSum {
+		graph, err := g.dependentGraph()
+		if err != nil {
+			return nil, err
+		}
+
+		err = g.markGoSum(graph, changed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// MarkedPackages returns the packages that are marked by the changes before
+// they are flattened into Packages. The keys of the returned map are the import
+// paths of the packages that were changed according to the differ, and its
+// values map the import paths of the marked packages, which include the
+// changed package and its dependents, to true when the package exists and
+// false when it was deleted. The packages are not filtered by the prefixes. It
+// is mostly useful for debugging unexpected results.
+func (g *GTA) MarkedPackages() (map[string]map[string]bool, error) {
+	paths, _, err := g.markedPackages()
+	return paths, err
+}
+
+// WalkChanged calls fn for each of the packages in the AllChanges field of the
+// value returned by ChangedPackages as soon as the package is marked, so that
+// the packages can be processed before the dependents of all the changes are
+// found. Each package is passed to fn once, in no particular order. The
+// packages are passed as they are loaded by the packager; the options that
+// only apply to the result of ChangedPackages (e.g. SetUnifyTestAndProd and
+// SetIncludeChangedLines) have no effect. WalkChanged stops and returns the
+// error when fn returns an error.
+func (g *GTA) WalkChanged(fn func(Package) error) error {
+	seen := make(map[string]struct{})
+	_, _, err := g.walkChanged(func(_ string, pkg Package) error {
+		if _, ok := seen[pkg.ImportPath]; ok {
+			return nil
+		}
+		seen[pkg.ImportPath] = struct{}{}
+		return fn(pkg)
+	})
+	return err
+}
+
+// walkChanged calls fn with the import path of each package that was changed
+// according to g.differ and each of the included packages that are marked by
+// it, which include the changed package itself. A package that is marked by
+// multiple changed packages is passed to fn once for each of them. It returns
+// the set of marked packages that are only affected by the changes through
+// _test.go files and the reasons the changed packages were changed.
+func (g *GTA) walkChanged(fn func(changed string, pkg Package) error) (map[string]struct{}, map[string]reason, error) {
+	excluded := make(map[string]struct{})
+	return g.walkMarked(func(changed string, marked map[string]bool) error {
+		// add any dependents of the changed package; the changed package will be included in marked.
+		for path, check := range marked {
+			pkg := &Package{ImportPath: path}
+			if check {
+				var err error
+				pkg, err = g.packager.PackageFromImport(path)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !g.includes(pkg.ImportPath) {
+				if _, ok := excluded[pkg.ImportPath]; !ok {
+					excluded[pkg.ImportPath] = struct{}{}
+					g.log().Debug("excluded package", "package", pkg.ImportPath)
+				}
+				continue
+			}
+
+			if err := fn(changed, *pkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// markedPackages returns a map of maps. The outer map's key is the import path
+// of a package that was changed according to g.differ. The inner maps' (i.e.
+// the values of the outer map) keys are import paths of the dependents of the
+// packages in respective key of the outer map. The inner maps' boolean values
+// are true when the respective package exists and false when the respective
+// package was deleted. testOnly is the set of marked packages that are only
+// affected by the changes through _test.go files.
+func (g *GTA) markedPackages() (map[string]map[string]bool, map[string]struct{}, error) {
+	paths := map[string]map[string]bool{}
+	testOnly, _, err := g.walkMarked(func(changed string, marked map[string]bool) error {
+		paths[changed] = marked
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paths, testOnly, nil
+}
+
+// walkMarked calls fn with the import path of each package that was changed
+// according to g.differ and the packages it marks as soon as they are
+// marked. The keys of marked are the import paths of the changed package and
+// its dependents, and its values are true when the respective package exists
+// and false when it was deleted. It returns the set of marked packages that
+// are only affected by the changes through _test.go files and the reasons the
+// changed packages were changed.
+func (g *GTA) walkMarked(fn func(changed string, marked map[string]bool) error) (map[string]struct{}, map[string]reason, error) {
+	changed, isolated, testOnlySeeds, reasons, err := g.seedPackages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	graph, err := g.dependentGraph()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	packageFromImport := func(path string) (*Package, error) {
-		pkg, err := g.packager.PackageFromImport(path)
+	if g.useGoSum {
+		err = g.markGoSum(graph, changed)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		return pkg, nil
+		// the packages that were not changed according to the differ were
+		// marked because of their modules' checksums.
+		for importPath := range changed {
+			if _, ok := reasons[importPath]; !ok {
+				reasons[importPath] = reasonGoSum
+			}
+		}
 	}
 
-	// build our packages
-	allChanges := map[string]Package{}
-	for changed, marked := range paths {
-		var packages []Package
+	// production is the set of packages that are affected by the changes
+	// through their non-test files.
+	production := make(map[string]bool)
 
-		// add any dependents of the changed package; the changed package will be included in marked.
-		for path, check := range marked {
-			pkg := new(Package)
-			pkg.ImportPath = path
+	// all is the set of marked packages.
+	all := make(map[string]struct{})
+	for change := range changed {
+		marked := make(map[string]bool)
 
-			if check {
-				pkg2, err := packageFromImport(path)
-				if err != nil {
-					return nil, err
-				}
-				pkg = pkg2
+		if _, ok := isolated[change]; ok {
+			if _, ok := testOnlySeeds[change]; !ok {
+				production[change] = true
+			}
+			marked[change] = !changed[change]
+		} else {
+			// we traverse the graph and build our list of mark all dependents
+			g.traverse(graph, change, marked)
+
+			markedProduction := make(map[string]bool)
+			graph.traverseBreadthFirst(change, g.maxDepth, true, markedProduction)
+			for importPath := range markedProduction {
+				production[importPath] = true
 			}
 
-			addPackage := func(pkg Package) {
-				allChanges[pkg.ImportPath] = pkg
-				if changed == pkg.ImportPath {
-					cp.Changes = append(cp.Changes, pkg)
-				} else {
-					packages = append(packages, pkg)
+			// clear the boolean value on the paths that no longer contain packages (i.e.
+			// the Go files were deleted...).
+			for importPath := range marked {
+				if changed[importPath] {
+					marked[importPath] = false
 				}
 			}
+		}
 
-			if hasPrefixIn(pkg.ImportPath, g.prefixes) {
-				addPackage(*pkg)
-			}
+		g.log().Debug("changed package", "package", change, "deleted", changed[change], "marked", len(marked))
+
+		for importPath := range marked {
+			all[importPath] = struct{}{}
 		}
 
-		if len(packages) != 0 {
-			sort.Sort(byPackageImportPath(packages))
-			cp.Dependencies[changed] = packages
+		if err := fn(change, marked); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	for _, pkg := range allChanges {
-		cp.AllChanges = append(cp.AllChanges, pkg)
+	testOnly := make(map[string]struct{})
+	for importPath := range all {
+		if !production[importPath] {
+			testOnly[importPath] = struct{}{}
+		}
 	}
-	sort.Sort(byPackageImportPath(cp.AllChanges))
-	sort.Sort(byPackageImportPath(cp.Changes))
 
-	return cp, nil
+	return testOnly, reasons, nil
 }
 
-// markedPackages returns a map of maps. The outer map's key is the import path
-// of a package that was changed according to g.differ. The inner maps' (i.e.
-// the values of the outer map) keys are import paths of the dependents of the
-// packages in respective key of the outer map. The inner maps' boolean values
-// are true when the respective package exists and false when the respective
-// package was deleted.
-func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
+// withoutIgnoredFiles returns a copy of dirs without the files whose names
+// match the ignore patterns, and without the _test.go files when test files are
+// ignored. Directories whose changed files are all ignored are omitted.
+func (g *GTA) withoutIgnoredFiles(dirs map[string]Directory) map[string]Directory {
+	if len(g.ignoreFilePatterns) == 0 && !g.ignoreTestFiles {
+		return dirs
+	}
+
+	filtered := make(map[string]Directory, len(dirs))
+	for abs, dir := range dirs {
+		var files []string
+		for _, f := range dir.Files {
+			if matchesAny(g.ignoreFilePatterns, f) || (g.ignoreTestFiles && strings.HasSuffix(f, "_test.go")) {
+				g.log().Debug("ignored file", "dir", abs, "file", f)
+				continue
+			}
+			files = append(files, f)
+		}
+
+		if len(dir.Files) > 0 && len(files) == 0 {
+			continue
+		}
+
+		dir.Files = files
+		filtered[abs] = dir
+	}
+	return filtered
+}
+
+// matchesAny returns true when the base name of fn matches any of patterns.
+// The patterns are expected to be valid.
+func matchesAny(patterns []string, fn string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(fn)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// seedPackages returns the packages that were changed according to g.differ.
+// The keys of changed are import paths, and its values are true when the
+// package was deleted. isolated is the set of the changed packages whose
+// dependents are not affected by the changes (e.g. only the package's tests
+// were changed). testOnly is the subset of isolated whose tests are the only
+// things affected by the changes. reasons maps the keys of changed to the
+// reasons they were changed.
+func (g *GTA) seedPackages() (changed map[string]bool, isolated, testOnly map[string]struct{}, reasons map[string]reason, err error) {
 	if g.differ == nil {
-		return nil, ErrNoDiffer
+		return nil, nil, nil, nil, ErrNoDiffer
 	}
 	if g.packager == nil {
-		return nil, ErrNoPackager
+		return nil, nil, nil, nil, ErrNoPackager
 	}
 
 	// get our diff'd directories
 	dirs, err := g.differ.Diff()
 	if err != nil {
-		return nil, fmt.Errorf("diffing directory for dirty packages, %v", err)
+		return nil, nil, nil, nil, fmt.Errorf("diffing directory for dirty packages, %w", err)
+	}
+	dirs = g.withoutIgnoredFiles(dirs)
+	for abs, dir := range dirs {
+		g.log().Debug("changed directory", "dir", abs, "exists", dir.Exists, "files", dir.Files)
 	}
 
 	// We build our set of initial dirty packages from the git diff. The map
 	// value is true when the package was deleted. The map keys are package
 	// import paths.
-	changed := make(map[string]bool)
+	changed = make(map[string]bool)
+	reasons = make(map[string]reason)
 	embeddedChanged := make(map[string]struct{})
 	onlyTestsAffected := make(map[string]struct{})
 	onlyTestPackagesChanged := make(map[string]struct{})
+	onlyGeneratedChanged := make(map[string]struct{})
+	onlyInternalChanged := make(map[string]struct{})
+	goModChanged := make(map[string]struct{})
+
+	var attrs *gitattributes
+	if g.useGitattributes {
+		attrs = newGitattributes()
+	}
+
 	for abs, dir := range dirs {
 		// TODO(bc): handle changes to go.mod when vendoring is not being used.
 
+		// Add all packages of the module when its go.mod was changed.
+		if g.goModChangesWholeModule && dir.Exists && hasFile(dir.Files, "go.mod") {
+			importPaths, err := g.modulePackages(abs, nil)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("listing packages of module %q, %v", abs, err)
+			}
+
+			for _, importPath := range importPaths {
+				goModChanged[importPath] = struct{}{}
+				changed[importPath] = false
+				markReason(reasons, importPath, reasonGoMod)
+			}
+		}
+
+		// Add the main packages of the module when its godebug settings were
+		// changed, because they change the programs' default GODEBUG settings.
+		if bd, ok := g.differ.(BaseDiffer); ok && g.godebugChangesMains && dir.Exists && hasFile(dir.Files, "go.mod") {
+			changedGodebug, err := godebugChanged(bd, abs)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("comparing godebug settings of module %q, %v", abs, err)
+			}
+
+			if changedGodebug {
+				importPaths, err := g.modulePackages(abs, isMainDir)
+				if err != nil {
+					return nil, nil, nil, nil, fmt.Errorf("listing main packages of module %q, %v", abs, err)
+				}
+
+				for _, importPath := range importPaths {
+					goModChanged[importPath] = struct{}{}
+					changed[importPath] = false
+					markReason(reasons, importPath, reasonGoMod)
+				}
+			}
+		}
+
 		// Add packages that embed the files of dir.
 		for _, f := range dir.Files {
 			// An embedded file may:
@@ -270,6 +1143,7 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 				embeddedChanged[importPath] = struct{}{}
 				// Set the value to false, because the package is known to exist.
 				changed[importPath] = false
+				markReason(reasons, importPath, reasonEmbeddedFiles)
 			}
 		}
 
@@ -284,8 +1158,13 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 				continue
 			}
 
-			// TODO(bc): take GOPATH / module root into account and don't try going above them?
-			if absAncestor == "/" {
+			// there is no package to mark when no directory above the testdata
+			// directory is unignored: the ancestor is then the root of the file
+			// system or, when the path is relative, "." whose package would
+			// depend on the current directory. A testdata directory directly
+			// below one of g.roots resolves to the root, which is never
+			// ignored.
+			if absAncestor == "." || filepath.Dir(absAncestor) == absAncestor {
 				continue
 			}
 
@@ -317,6 +1196,11 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 		if err != nil {
 			switch err.(type) {
 			case *build.NoGoError:
+				// the Go files that remain may all be excluded by build constraints
+				// (e.g. a constraint that excludes the host platform was added). The
+				// package is still tracked, but without a directory because it
+				// cannot be built, and its dependents are marked because the
+				// platforms on which it is available changed.
 				if hasGoFile(dir.Files) {
 					importPath, err := g.findImportPath(abs)
 					if err != nil {
@@ -334,7 +1218,25 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 				// so no dirty packages
 				continue
 			case scanner.ErrorList:
-				// same, package is not buildable, so no dirty packages
+				// same, package is not buildable, so no dirty packages unless
+				// unbuildable packages are included so that the breakage is
+				// caught by whatever consumes the changes.
+				if !g.includeUnbuildable || !hasGoFile(dir.Files) {
+					continue
+				}
+
+				importPath, err := g.findImportPath(abs)
+				if err != nil {
+					continue
+				}
+
+				changed[importPath] = false
+				if _, ok := onlyTestsAffected[abs]; ok {
+					onlyTestPackagesChanged[importPath] = struct{}{}
+					markReason(reasons, importPath, reasonTestFiles)
+				} else {
+					markReason(reasons, importPath, reasonGoFiles)
+				}
 				continue
 			default:
 				if !dir.Exists && hasGoFile(dir.Files) {
@@ -350,7 +1252,7 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 					continue
 				}
 			}
-			return nil, fmt.Errorf("pulling package information for %q, %v", abs, err)
+			return nil, nil, nil, nil, fmt.Errorf("pulling package information for %q, %v", abs, err)
 		}
 
 		// create a simple set of changed pkgs by import path. The packages that are tracked have at least one of the following properties:
@@ -369,69 +1271,228 @@ func (g *GTA) markedPackages() (map[string]map[string]bool, error) {
 
 		if shouldMark {
 			changed[pkg.ImportPath] = false
+			if _, ok := onlyTestPackagesChanged[pkg.ImportPath]; ok {
+				markReason(reasons, pkg.ImportPath, reasonTestFiles)
+			} else {
+				markReason(reasons, pkg.ImportPath, reasonGoFiles)
+			}
+		}
+
+		// changes that are limited to generated files do not propagate to the
+		// package's dependents.
+		if shouldMark && attrs != nil {
+			generated, err := attrs.allGenerated(abs, dir.Files)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("reading .gitattributes for %q, %v", abs, err)
+			}
+			if generated {
+				onlyGeneratedChanged[pkg.ImportPath] = struct{}{}
+			}
+		}
+
+		// changes that do not change the package's exported API do not
+		// propagate to the package's dependents.
+		if shouldMark && g.apiChangeDetection {
+			if _, ok := onlyTestsAffected[abs]; !ok {
+				if bd, ok := g.differ.(BaseDiffer); ok {
+					changedAPI, err := apiChanged(bd, abs, dir.Files)
+					if err != nil {
+						return nil, nil, nil, nil, fmt.Errorf("comparing the exported API of %q, %v", abs, err)
+					}
+					if !changedAPI {
+						onlyInternalChanged[pkg.ImportPath] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	isolated = make(map[string]struct{}, len(onlyTestPackagesChanged)+len(onlyGeneratedChanged)+len(onlyInternalChanged))
+	for _, m := range []map[string]struct{}{onlyTestPackagesChanged, onlyGeneratedChanged, onlyInternalChanged} {
+		for k := range m {
+			isolated[k] = struct{}{}
 		}
 	}
 
 	// do not assume that only tests are affected if the package's embedded files
 	// were changed. We do not have enough information to know whether the
 	// embedded files are exclusively used by the tests, so assume that are used
-	// by more than the tests.
+	// by more than the tests. Likewise, a change to the package's go.mod may
+	// affect all of it.
+	testOnly = make(map[string]struct{}, len(onlyTestPackagesChanged))
 	for k := range onlyTestPackagesChanged {
-		if _, ok := embeddedChanged[k]; ok {
-			delete(onlyTestPackagesChanged, k)
+		testOnly[k] = struct{}{}
+	}
+
+	for _, m := range []map[string]struct{}{embeddedChanged, goModChanged} {
+		for k := range m {
+			delete(isolated, k)
+			delete(testOnly, k)
 		}
 	}
 
-	// we build the dependent graph
-	graph, err := g.packager.DependentGraph()
-	if err != nil {
-		return nil, fmt.Errorf("building dependency graph, %v", err)
+	for importPath, deleted := range changed {
+		if deleted {
+			reasons[importPath] = reasonDeleted
+		}
 	}
 
-	paths := map[string]map[string]bool{}
-	for change := range changed {
-		marked := make(map[string]bool)
+	return changed, isolated, testOnly, reasons, nil
+}
 
-		if _, ok := onlyTestPackagesChanged[change]; ok {
-			marked[change] = !changed[change]
-			paths[change] = marked
+// modulePackages returns the import paths of the packages in the module whose
+// root directory is root that match g.prefixes. Directories that are ignored
+// by the go tool and nested modules are skipped, as are directories for which
+// filter returns false when filter is not nil.
+func (g *GTA) modulePackages(root string, filter func(dir string) bool) ([]string, error) {
+	dirs, err := g.moduleDirs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var importPaths []string
+	for _, dir := range dirs {
+		if filter != nil && !filter(dir) {
 			continue
 		}
 
-		// we traverse the graph and build our list of mark all dependents
-		graph.Traverse(change, marked)
+		pkg, err := g.packager.PackageFromDir(dir)
+		if err != nil {
+			switch err.(type) {
+			case *build.NoGoError, scanner.ErrorList:
+				continue
+			}
+			return nil, err
+		}
+
+		if g.includes(pkg.ImportPath) {
+			importPaths = append(importPaths, pkg.ImportPath)
+		}
+	}
 
-		// clear the boolean value on the paths that no longer contain packages (i.e.
-		// the Go files were deleted...).
-		for importPath := range marked {
-			if changed[importPath] {
-				marked[importPath] = false
+	return importPaths, nil
+}
+
+// moduleDirs returns the directories that contain Go files in the module whose
+// root directory is root. Directories that are ignored by the go tool and
+// nested modules are skipped.
+func (g *GTA) moduleDirs(root string) ([]string, error) {
+	roots := append([]string{root}, g.roots...)
+
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			if filepath.Ext(path) == ".go" && (len(dirs) == 0 || dirs[len(dirs)-1] != filepath.Dir(path)) {
+				dirs = append(dirs, filepath.Dir(path))
 			}
+			return nil
+		}
+
+		if path == root {
+			return nil
+		}
+
+		if isIgnoredByGo(path, roots) || exists(filepath.Join(path, "go.mod")) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+// dependentGraph returns the packager's dependent graph with g.aliases
+// applied.
+func (g *GTA) dependentGraph() (*Graph, error) {
+	graph, err := g.packager.DependentGraph()
+	if err != nil {
+		// the graph is still usable when only some packages could not be
+		// loaded, though it may be missing their imports.
+		var ge *graphError
+		if g.strict || graph == nil || !errors.As(err, &ge) {
+			return nil, fmt.Errorf("building dependency graph, %w", err)
+		}
+		for importPath, err := range ge.Errors {
+			g.log().Warn("package could not be loaded", "package", importPath, "error", err)
+		}
+	}
+
+	if g.validateInternal {
+		if err := graph.validateInternal(); err != nil {
+			return nil, fmt.Errorf("validating dependency graph, %w", err)
 		}
+	}
+
+	if len(g.aliases) > 0 {
+		graph = graph.alias(g.aliases)
+	}
+
+	return graph, nil
+}
+
+// log returns the logger set with SetLogger, or a logger that discards all
+// records when none was set.
+func (g *GTA) log() *slog.Logger {
+	if g.logger == nil {
+		return discardLogger
+	}
+	return g.logger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// markGoSum adds the packages in graph that belong to the modules whose go.sum
+// checksums changed to changed.
+func (g *GTA) markGoSum(graph *Graph, changed map[string]bool) error {
+	d, ok := g.differ.(GoSumDiffer)
+	if !ok {
+		return nil
+	}
+
+	modules, err := d.DiffGoSum()
+	if err != nil {
+		return fmt.Errorf("diffing go.sum for changed modules, %v", err)
+	}
 
-		paths[change] = marked
+	// mark the packages of the modules whose checksums changed that are
+	// imported by other packages. Vendored packages are matched by their import
+	// path without the vendor directory so that changes to the checksums of
+	// vendored modules are detected in GOPATH mode, too.
+	for importPath := range graph.graph {
+		if inModules(unvendor(importPath), modules) {
+			changed[importPath] = false
+		}
 	}
 
-	return paths, nil
+	return nil
 }
 
 var errImportPathNotFound = errors.New("could not find import path")
 
 // findImportPath walks a directory up, trying to find an import path for
-// parent directories.
+// parent directories. It does not walk above g.roots; errImportPathNotFound is
+// returned when no import path can be found for abs or any of its parents
+// below a root.
 func (g *GTA) findImportPath(abs string) (string, error) {
 	base := filepath.Base(abs)
 	parent := filepath.Dir(abs)
 
-	if base == abs {
+	if base == abs || !isWithinRoots(abs, g.roots) {
 		return "", errImportPathNotFound
 	}
 
 	if !exists(abs) {
 		//	recurse when the directory doesn't exist
 		importPath, err := g.findImportPath(parent)
-		if err != nil && err == errImportPathNotFound {
-			return path.Join(importPath, base), err
+		if err != nil {
+			return "", err
 		}
 		return path.Join(importPath, base), nil
 	}
@@ -444,13 +1505,46 @@ func (g *GTA) findImportPath(abs string) (string, error) {
 				return pkg.ImportPath, nil
 			}
 		}
+
+		if isRoot(abs, g.roots) {
+			return "", errImportPathNotFound
+		}
+
 		importPath, err := g.findImportPath(parent)
-		return path.Join(importPath, base), err
+		if err != nil {
+			return "", err
+		}
+		return path.Join(importPath, base), nil
 	}
 
 	return pkg.ImportPath, nil
 }
 
+// isWithinRoots returns true when name is one of roots or is below one of
+// them. It always returns true when there are no roots.
+func isWithinRoots(name string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+
+	for _, root := range roots {
+		if name == root || strings.HasPrefix(name, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot returns true when name is one of roots.
+func isRoot(name string, roots []string) bool {
+	for _, root := range roots {
+		if name == root {
+			return true
+		}
+	}
+	return false
+}
+
 type byPackageImportPath []Package
 
 func (b byPackageImportPath) Len() int               { return len(b) }
@@ -465,6 +1559,14 @@ func stringify(pkgs []Package) []string {
 	return out
 }
 
+func objectify(pkgs []Package) []packageJSON {
+	var out []packageJSON
+	for _, pkg := range pkgs {
+		out = append(out, packageJSON{ImportPath: pkg.ImportPath, Dir: pkg.Dir, IsCommand: pkg.IsCommand, Module: pkg.Module, ChangedLines: pkg.ChangedLines})
+	}
+	return out
+}
+
 func mapify(pkgs map[string][]Package) map[string][]string {
 	out := map[string][]string{}
 	for key, pkgs := range pkgs {
@@ -482,6 +1584,109 @@ func hasGoFile(files []string) bool {
 	return false
 }
 
+// unvendor returns importPath without the path of the vendor directory that
+// contains it, if any.
+func unvendor(importPath string) string {
+	segment := "/vendor/"
+	if idx := strings.LastIndex(importPath, segment); idx > -1 {
+		return importPath[idx+len(segment):]
+	}
+	return strings.TrimPrefix(importPath, "vendor/")
+}
+
+// inModules returns true when importPath is the import path of a package in
+// one of modules. Nested modules are not taken into account, so a package of
+// a nested module is considered to be in the modules that enclose it too.
+func inModules(importPath string, modules map[string]struct{}) bool {
+	for module := range modules {
+		if importPath == module || strings.HasPrefix(importPath, module+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFile(files []string, name string) bool {
+	for _, fn := range files {
+		if fn == name {
+			return true
+		}
+	}
+	return false
+}
+
+// includes returns true when importPath has one of g.prefixes or matches one
+// of g.includeRegexps, does not have one of g.excludes and is not deeper than
+// g.maxPackageDepth.
+func (g *GTA) includes(importPath string) bool {
+	if g.maxPackageDepth > 0 && strings.Count(importPath, "/")+1 > g.maxPackageDepth {
+		return false
+	}
+
+	for _, exclude := range g.excludes {
+		if strings.HasPrefix(importPath, exclude) {
+			return false
+		}
+	}
+
+	if len(g.includeRegexps) == 0 {
+		return hasPrefixIn(importPath, g.prefixes)
+	}
+
+	for _, re := range g.includeRegexps {
+		if re.MatchString(importPath) {
+			return true
+		}
+	}
+	return len(g.prefixes) > 0 && hasPrefixIn(importPath, g.prefixes)
+}
+
+// gtaignoreFile is the name of the file that lists the import path prefixes of
+// the packages to exclude, one per line.
+const gtaignoreFile = ".gtaignore"
+
+// gtaignore returns the import path prefixes listed in the .gtaignore file at
+// the root of the git repository of the differ. The .gtaignore files at the
+// roots are read instead when the differ does not know the root of a git
+// repository, e.g. because it was created by NewFileDiffer or because the
+// current directory is not in a git repository.
+func (g *GTA) gtaignore() ([]string, error) {
+	dirs := g.roots
+	if rd, ok := g.differ.(rootDiffer); ok {
+		if root, err := rd.root(); err == nil {
+			dirs = []string{root}
+		}
+	}
+
+	var excludes []string
+	for _, dir := range dirs {
+		b, err := os.ReadFile(filepath.Join(dir, gtaignoreFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", gtaignoreFile, err)
+		}
+		excludes = append(excludes, parseGtaignore(b)...)
+	}
+	return excludes, nil
+}
+
+// parseGtaignore returns the import path prefixes in the contents of a
+// .gtaignore file. Blank lines and everything following a # are ignored.
+func parseGtaignore(b []byte) []string {
+	var excludes []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			excludes = append(excludes, line)
+		}
+	}
+	return excludes
+}
+
 func hasPrefixIn(s string, prefixes []string) bool {
 	if len(prefixes) == 0 {
 		return true
@@ -502,46 +1707,47 @@ func isIgnoredByGo(name string, roots []string) bool {
 		}
 	}
 
-	base := filepath.Base(name)
-	if base[0] == filepath.Separator {
+	// the root of the file system or of a volume (e.g. / or C:\) is never
+	// ignored.
+	dir := filepath.Dir(name)
+	if dir == name {
 		return false
 	}
 
 	// Avoid .foo, _foo, and testdata directory trees how the go tool does!
 	// See https://github.com/golang/tools/blob/3a85b8d/go/buildutil/allpackages.go#L93
 	// Above link is not guaranteed to work.
+	base := filepath.Base(name)
 	if base == "" || base[0] == '.' || base[0] == '_' || base == "testdata" {
 		return true
 	}
 
-	dir := filepath.Dir(name)
 	if dir == "." {
 		return false
 	}
 
-	return isIgnoredByGo(filepath.Dir(name), roots)
+	return isIgnoredByGo(dir, roots)
 }
 
 func isTestData(name string) bool {
-	base := filepath.Base(name)
-	if base[0] == filepath.Separator {
+	dir := filepath.Dir(name)
+	if dir == name {
 		return false
 	}
 
-	if name == "testdata" || base == "testdata" {
+	if name == "testdata" || filepath.Base(name) == "testdata" {
 		return true
 	}
 
-	dir := filepath.Dir(name)
 	if dir == "." {
 		return false
 	}
 
-	return isTestData(filepath.Dir(name))
+	return isTestData(dir)
 }
 
 func deepestUnignoredDir(name string, roots []string) string {
-	if name == "." || name == "/" {
+	if name == "." || filepath.Dir(name) == name {
 		return name
 	}
 
@@ -566,12 +1772,7 @@ func toplevel() ([]string, error) {
 		return gopaths()
 	}
 
-	root, err := moduleroot()
-	if err != nil {
-		return nil, err
-	}
-	return []string{root}, nil
-
+	return moduleroots()
 }
 
 func gopaths() ([]string, error) {
@@ -588,12 +1789,19 @@ func gopaths() ([]string, error) {
 	return roots, nil
 }
 
-func moduleroot() (string, error) {
-	cmd := exec.Command("go", "list", "-m", "-f", "{{.Dir}}")
-	b, err := cmd.CombinedOutput()
+// moduleroots returns the root directories of the main modules. There is a
+// main module for each module in the workspace when a go.work file is used.
+func moduleroots() ([]string, error) {
+	b, err := execWithStderr(exec.Command("go", "list", "-m", "-f", "{{.Dir}}"))
 	if err != nil {
-		return "", fmt.Errorf("could get not get module root: %w", err)
+		return nil, fmt.Errorf("could get not get module roots: %w", err)
 	}
 
-	return strings.TrimSpace(string(b)), nil
+	var roots []string
+	for _, v := range strings.Split(string(b), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			roots = append(roots, v)
+		}
+	}
+	return roots, nil
 }
@@ -7,24 +7,41 @@ in the LICENSE file.
 package gta
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"go/build"
+	"log"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/tools/go/packages/packagestest"
 )
 
-var _ Differ = &testDiffer{}
+var (
+	_ GoSumDiffer = &testDiffer{}
+	_ BaseDiffer  = &testDiffer{}
+)
 
 type testDiffer struct {
-	diff map[string]Directory
+	diff  map[string]Directory
+	goSum map[string]struct{}
+	// base is a map of absolute file paths to their contents before they were
+	// changed. BaseFile returns ErrNoBase when base is nil.
+	base map[string][]byte
+	// revision is the revision that the changes are compared to. BaseRevision
+	// returns ErrNoBase when revision is empty.
+	revision string
 }
 
 func (t *testDiffer) Diff() (map[string]Directory, error) {
@@ -32,7 +49,36 @@ func (t *testDiffer) Diff() (map[string]Directory, error) {
 }
 
 func (t *testDiffer) DiffFiles() (map[string]bool, error) {
-	panic("not implemented")
+	files := make(map[string]bool)
+	for abs, dir := range t.diff {
+		for _, fn := range dir.Files {
+			files[filepath.Join(abs, fn)] = dir.Exists
+		}
+	}
+	return files, nil
+}
+
+func (t *testDiffer) DiffGoSum() (map[string]struct{}, error) {
+	return t.goSum, nil
+}
+
+func (t *testDiffer) BaseFile(abs string) ([]byte, error) {
+	if t.base == nil {
+		return nil, ErrNoBase
+	}
+
+	b, ok := t.base[abs]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: abs, Err: os.ErrNotExist}
+	}
+	return b, nil
+}
+
+func (t *testDiffer) BaseRevision() (string, error) {
+	if t.revision == "" {
+		return "", ErrNoBase
+	}
+	return t.revision, nil
 }
 
 var _ Packager = &testPackager{}
@@ -232,8 +278,9 @@ func TestGTA_ChangedPackages(t *testing.T) {
 	// values that will be expanded and provided as a differ via testDiffer.
 	// shouldRemoveFile is a function that returns a boolean value indicating
 	// whether a file identified by a filename fragment should be deleted. want
-	// is the expected value from ChangedPackages().
-	testChangedPackages := func(t *testing.T, diff map[string]Directory, shouldRemoveFile func(string) bool, want *Packages) {
+	// is the expected value from ChangedPackages(). opts are applied after the
+	// differ and packager options.
+	testChangedPackages := func(t *testing.T, diff map[string]Directory, shouldRemoveFile func(string) bool, want *Packages, opts ...Option) {
 		t.Helper()
 
 		packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
@@ -280,6 +327,9 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			}
 
 			qualifyPackages := func(pkgs []Package) []Package {
+				if pkgs == nil {
+					return nil
+				}
 				qualified := make([]Package, len(pkgs))
 				for i, pkg := range pkgs {
 					pkg.ImportPath = fmt.Sprintf("%s/%s", testModule, pkg.ImportPath)
@@ -304,6 +354,15 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			qualifiedWant.Dependencies = deps
 			qualifiedWant.Changes = qualifyPackages(want.Changes)
 			qualifiedWant.AllChanges = qualifyPackages(want.AllChanges)
+			if want.TestOnlyChanges != nil {
+				qualifiedWant.TestOnlyChanges = qualifyPackages(want.TestOnlyChanges)
+			}
+			if want.Reasons != nil {
+				qualifiedWant.Reasons = make(map[string]string, len(want.Reasons))
+				for k, v := range want.Reasons {
+					qualifiedWant.Reasons[fmt.Sprintf("%s/%s", testModule, k)] = v
+				}
+			}
 
 			popd := chdir(t, exporter.Filename(e, testModule, ""))
 			t.Cleanup(popd)
@@ -330,7 +389,8 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			}
 			defer AllSetenv(t, e.Config.Env)()
 
-			sut, err := New(SetDiffer(difr), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"})))
+			sutOpts := append([]Option{SetDiffer(difr), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"}))}, opts...)
+			sut, err := New(sutOpts...)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -341,11 +401,38 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			}
 
 			packagesEqual := func(pkg1, pkg2 Package) bool {
-				return pkg1.ImportPath == pkg2.ImportPath && (len(pkg1.Dir) == 0) == (len(pkg2.Dir) == 0)
+				return pkg1.ImportPath == pkg2.ImportPath && (len(pkg1.Dir) == 0) == (len(pkg2.Dir) == 0) && pkg1.TestHelper == pkg2.TestHelper && pkg1.IsCommand == pkg2.IsCommand
 			}
 			if diff := cmp.Diff(qualifiedWant, got, cmp.Comparer(packagesEqual)); diff != "" {
 				t.Errorf("(-want, +got)\n%s", diff)
 			}
+
+			changes, err := sut.Changes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got.Changes, changes); diff != "" {
+				t.Errorf("Changes() (-want, +got)\n%s", diff)
+			}
+
+			// the roots are the changed packages from which the dependents are
+			// marked, and each root marks itself as existing unless it was
+			// deleted.
+			roots, err := sut.MarkedRoots()
+			if err != nil {
+				t.Fatal(err)
+			}
+			marked, err := sut.MarkedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantRoots := make(map[string]bool, len(marked))
+			for root, m := range marked {
+				wantRoots[root] = !m[root]
+			}
+			if diff := cmp.Diff(wantRoots, roots); diff != "" {
+				t.Errorf("MarkedRoots() (-want, +got)\n%s", diff)
+			}
 		})
 	}
 
@@ -462,7 +549,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			Dependencies: map[string][]Package{
 				"foo": {
 					{ImportPath: "fooclient", Dir: "fooclient"},
-					{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 				},
 			},
 			Changes: []Package{
@@ -471,7 +558,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			AllChanges: []Package{
 				{ImportPath: "foo", Dir: "foo"},
 				{ImportPath: "fooclient", Dir: "fooclient"},
-				{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 			},
 		}
 		testChangedPackages(t, diff, nil, want)
@@ -486,7 +573,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			Dependencies: map[string][]Package{
 				"foo": {
 					{ImportPath: "fooclient", Dir: "fooclient"},
-					{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 				},
 			},
 			Changes: []Package{
@@ -495,7 +582,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			AllChanges: []Package{
 				{ImportPath: "foo", Dir: "foo"},
 				{ImportPath: "fooclient", Dir: "fooclient"},
-				{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 			},
 		}
 
@@ -512,7 +599,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			Dependencies: map[string][]Package{
 				"foo": {
 					{ImportPath: "fooclient", Dir: "fooclient"},
-					{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 				},
 			},
 			Changes: []Package{
@@ -522,7 +609,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			AllChanges: []Package{
 				{ImportPath: "foo", Dir: "foo"},
 				{ImportPath: "fooclient", Dir: "fooclient"},
-				{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 			},
 		}
 
@@ -555,7 +642,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			Dependencies: map[string][]Package{
 				"foo": {
 					{ImportPath: "fooclient", Dir: "fooclient"},
-					{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 				},
 			},
 			Changes: []Package{
@@ -564,7 +651,7 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			AllChanges: []Package{
 				{ImportPath: "foo", Dir: "foo"},
 				{ImportPath: "fooclient", Dir: "fooclient"},
-				{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 			},
 		}
 
@@ -587,6 +674,61 @@ func TestGTA_ChangedPackages(t *testing.T) {
 
 		testChangedPackages(t, diff, nil, want)
 	})
+	t.Run("change test ignoring test files", func(t *testing.T) {
+		diff := map[string]Directory{
+			"foo":       {Exists: true, Files: []string{"foo_test.go"}},
+			"fooclient": {Exists: true, Files: []string{"fooclient_test.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIgnoreTestFiles(true))
+	})
+	t.Run("change external ignoring test files", func(t *testing.T) {
+		diff := map[string]Directory{
+			"foo": {Exists: true, Files: []string{"foo.go", "foo_test.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"foo": {
+					{ImportPath: "fooclient", Dir: "fooclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+				{ImportPath: "fooclient", Dir: "fooclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIgnoreTestFiles(true))
+	})
+	t.Run("change example", func(t *testing.T) {
+		// examples are in _test.go files and are only relevant to the package's
+		// tests even though they exercise its exported API.
+		diff := map[string]Directory{
+			"foo": {Exists: true, Files: []string{"example_test.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
 	t.Run("change badly named package", func(t *testing.T) {
 		diff := map[string]Directory{
 			"bar_test": {Exists: true, Files: []string{"util.go"}},
@@ -596,16 +738,141 @@ func TestGTA_ChangedPackages(t *testing.T) {
 			Dependencies: map[string][]Package{
 				"bar_test": {
 					{ImportPath: "fooclient", Dir: "fooclient"},
-					{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
+			},
+			AllChanges: []Package{
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
+				{ImportPath: "fooclient", Dir: "fooclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+	t.Run("change command", func(t *testing.T) {
+		// nothing imports a main package, so a change to a command only marks
+		// the command.
+		diff := map[string]Directory{
+			"fooclientclient": {Exists: true, Files: []string{"fooclientclient.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+			AllChanges: []Package{
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+	t.Run("change badly named package with test only changes", func(t *testing.T) {
+		// fooclient only imports bar_test from fooclient_test.go, so neither
+		// fooclient nor its dependents are affected through their non-test files.
+		diff := map[string]Directory{
+			"bar_test": {Exists: true, Files: []string{"util.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"bar_test": {
+					{ImportPath: "fooclient", Dir: "fooclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
+			},
+			AllChanges: []Package{
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
+				{ImportPath: "fooclient", Dir: "fooclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+			TestOnlyChanges: []Package{
+				{ImportPath: "fooclient", Dir: "fooclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIncludeTestOnlyChanges(true))
+	})
+	t.Run("change package and tests with test only changes", func(t *testing.T) {
+		// foo is imported by fooclient's non-test files, and fooclient's tests
+		// were changed.
+		diff := map[string]Directory{
+			"foo":       {Exists: true, Files: []string{"foo.go"}},
+			"fooclient": {Exists: true, Files: []string{"fooclient_test.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"foo": {
+					{ImportPath: "fooclient", Dir: "fooclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+				{ImportPath: "fooclient", Dir: "fooclient"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "foo", Dir: "foo"},
+				{ImportPath: "fooclient", Dir: "fooclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIncludeTestOnlyChanges(true))
+	})
+	t.Run("change badly named package with unified tests", func(t *testing.T) {
+		// bar_test is not an external test package, so it must not be unified
+		// with bar.
+		diff := map[string]Directory{
+			"bar_test": {Exists: true, Files: []string{"util.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"bar_test": {
+					{ImportPath: "fooclient", Dir: "fooclient"},
+					{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
 				},
 			},
 			Changes: []Package{
-				{ImportPath: "bar_test", Dir: "bar_test"},
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
 			},
 			AllChanges: []Package{
-				{ImportPath: "bar_test", Dir: "bar_test"},
+				{ImportPath: "bar_test", Dir: "bar_test", TestHelper: true},
 				{ImportPath: "fooclient", Dir: "fooclient"},
-				{ImportPath: "fooclientclient", Dir: "fooclientclient"},
+				{ImportPath: "fooclientclient", Dir: "fooclientclient", IsCommand: true},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetUnifyTestAndProd(true))
+	})
+	t.Run("change test helper package", func(t *testing.T) {
+		diff := map[string]Directory{
+			"testhelper": {Exists: true, Files: []string{"testhelper.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"testhelper": {
+					{ImportPath: "testhelperclient", Dir: "testhelperclient"},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "testhelper", Dir: "testhelper", TestHelper: true},
+			},
+			AllChanges: []Package{
+				{ImportPath: "testhelper", Dir: "testhelper", TestHelper: true},
+				{ImportPath: "testhelperclient", Dir: "testhelperclient"},
 			},
 		}
 
@@ -634,109 +901,3142 @@ func TestGTA_ChangedPackages(t *testing.T) {
 		testChangedPackages(t, diff, nil, want)
 	})
 
-	t.Run("change constrained package", func(t *testing.T) {
+	t.Run("change embedded file of package with load errors", func(t *testing.T) {
 		diff := map[string]Directory{
-			"constrained": {Exists: true, Files: []string{"constrained.go"}},
+			"embedbroken/files": {Exists: true, Files: []string{"asset"}},
 		}
 
 		want := &Packages{
 			Dependencies: map[string][]Package{},
 			Changes: []Package{
-				{ImportPath: "constrained"},
+				{ImportPath: "embedbroken", Dir: "embedbroken"},
 			},
 			AllChanges: []Package{
-				{ImportPath: "constrained"},
+				{ImportPath: "embedbroken", Dir: "embedbroken"},
 			},
 		}
 
 		testChangedPackages(t, diff, nil, want)
 	})
 
-	t.Run("change non-go file", func(t *testing.T) {
+	t.Run("add file matching embed pattern", func(t *testing.T) {
+		// new.txt does not exist when the packages are loaded, as if it had been
+		// added after the dependency graph was cached, so only the embed
+		// pattern of embedglob matches it.
 		diff := map[string]Directory{
-			"embed":      {Exists: true, Files: []string{"README.md"}},
-			"unimported": {Exists: true, Files: []string{"unimported.go"}},
+			"embedglob/assets": {Exists: true, Files: []string{"new.txt"}},
 		}
 
 		want := &Packages{
 			Dependencies: map[string][]Package{},
 			Changes: []Package{
-				{ImportPath: "unimported", Dir: "unimported"},
+				{ImportPath: "embedglob", Dir: "embedglob"},
 			},
 			AllChanges: []Package{
-				{ImportPath: "unimported", Dir: "unimported"},
+				{ImportPath: "embedglob", Dir: "embedglob"},
 			},
 		}
 
 		testChangedPackages(t, diff, nil, want)
 	})
-}
-
-func TestGTA_Prefix(t *testing.T) {
-	// A depends on B and foo
-	// B depends on C and bar
-	// C depends on qux
-	difr := &testDiffer{
-		diff: map[string]Directory{
-			"dirB":   Directory{Exists: true},
-			"dirC":   Directory{Exists: true},
-			"dirFoo": Directory{Exists: true},
-		},
-	}
 
-	graph := &Graph{
-		graph: map[string]map[string]bool{
-			"C": map[string]bool{
-				"B": true,
-			},
-			"B": map[string]bool{
-				"A": true,
-			},
-			"foo": map[string]bool{
-				"A": true,
-			},
-			"bar": map[string]bool{
-				"B": true,
-			},
-			"qux": map[string]bool{
-				"C": true,
-			},
-		},
-	}
+	t.Run("reasons", func(t *testing.T) {
+		t.Run("go files", func(t *testing.T) {
+			diff := map[string]Directory{
+				"unimported": {Exists: true, Files: []string{"unimported.go"}},
+			}
 
-	pkgr := &testPackager{
-		dirs2Imports: map[string]string{
-			"dirA":   "A",
-			"dirB":   "B",
-			"dirC":   "C",
-			"dirFoo": "foo",
-			"dirBar": "bar",
-			"dirQux": "qux",
-		},
-		graph: graph,
-		errs:  make(map[string]error),
-	}
-	want := []Package{
-		Package{ImportPath: "C"},
-		Package{ImportPath: "foo"},
-	}
+			want := &Packages{
+				Dependencies: map[string][]Package{},
+				Changes: []Package{
+					{ImportPath: "unimported", Dir: "unimported"},
+				},
+				AllChanges: []Package{
+					{ImportPath: "unimported", Dir: "unimported"},
+				},
+				Reasons: map[string]string{
+					"unimported": "changed go files",
+				},
+			}
 
-	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes("foo", "C"))
-	if err != nil {
-		t.Fatal(err)
-	}
+			testChangedPackages(t, diff, nil, want, SetIncludeReasons(true))
+		})
 
-	pkgs, err := gta.ChangedPackages()
-	if err != nil {
-		t.Fatal(err)
-	}
+		t.Run("test files", func(t *testing.T) {
+			diff := map[string]Directory{
+				"foo": {Exists: true, Files: []string{"foo_test.go"}},
+			}
 
-	got := pkgs.AllChanges
+			want := &Packages{
+				Dependencies: map[string][]Package{},
+				Changes: []Package{
+					{ImportPath: "foo", Dir: "foo"},
+				},
+				AllChanges: []Package{
+					{ImportPath: "foo", Dir: "foo"},
+				},
+				Reasons: map[string]string{
+					"foo": "changed test files",
+				},
+			}
 
-	if diff := cmp.Diff(want, got); diff != "" {
-		t.Errorf("(-want, +got)\n%s", diff)
-	}
-}
+			testChangedPackages(t, diff, nil, want, SetIncludeReasons(true))
+		})
+
+		t.Run("deleted", func(t *testing.T) {
+			diff := map[string]Directory{
+				"deleted":       {Exists: false, Files: []string{"deleted.go"}},
+				"deletedclient": {Exists: false, Files: []string{"deletedclient.go"}},
+			}
+
+			want := &Packages{
+				Dependencies: map[string][]Package{},
+				Changes: []Package{
+					{ImportPath: "deleted"},
+					{ImportPath: "deletedclient"},
+				},
+				AllChanges: []Package{
+					{ImportPath: "deleted"},
+					{ImportPath: "deletedclient"},
+				},
+				Reasons: map[string]string{
+					"deleted":       "deleted",
+					"deletedclient": "deleted",
+				},
+			}
+
+			testChangedPackages(t, diff, nil, want, SetIncludeReasons(true))
+		})
+
+		t.Run("embedded files", func(t *testing.T) {
+			diff := map[string]Directory{
+				"embedglob/assets": {Exists: true, Files: []string{"a.txt"}},
+			}
+
+			want := &Packages{
+				Dependencies: map[string][]Package{},
+				Changes: []Package{
+					{ImportPath: "embedglob", Dir: "embedglob"},
+				},
+				AllChanges: []Package{
+					{ImportPath: "embedglob", Dir: "embedglob"},
+				},
+				Reasons: map[string]string{
+					"embedglob": "changed embedded files",
+				},
+			}
+
+			testChangedPackages(t, diff, nil, want, SetIncludeReasons(true))
+		})
+	})
+
+	t.Run("change constrained package", func(t *testing.T) {
+		diff := map[string]Directory{
+			"constrained": {Exists: true, Files: []string{"constrained.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "constrained"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "constrained"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+
+	t.Run("constrain package", func(t *testing.T) {
+		// constrainedlib.go was changed to add a build constraint that excludes
+		// it from the default build, so constrainedlib no longer has any Go
+		// files, but its dependents must still be marked.
+		diff := map[string]Directory{
+			"constrainedlib": {Exists: true, Files: []string{"constrainedlib.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"constrainedlib": []Package{
+					{ImportPath: "constrainedlibclient", Dir: "constrainedlibclient"},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "constrainedlib"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "constrainedlib"},
+				{ImportPath: "constrainedlibclient", Dir: "constrainedlibclient"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+
+	t.Run("change package constrained to another GOOS", func(t *testing.T) {
+		if runtime.GOOS == "plan9" {
+			t.Skip("otheros is only excluded from the build on platforms other than plan9")
+		}
+
+		// otheros_plan9.go is excluded from the build by its name, so otheros
+		// has no Go files on this platform, but it is still marked because it is
+		// built on plan9.
+		diff := map[string]Directory{
+			"otheros": {Exists: true, Files: []string{"otheros_plan9.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "otheros"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "otheros"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+
+	t.Run("change generated file", func(t *testing.T) {
+		diff := map[string]Directory{
+			"generated": {Exists: true, Files: []string{"generated_gen.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetUseGitattributes(true))
+	})
+
+	t.Run("change generated and non-generated files", func(t *testing.T) {
+		diff := map[string]Directory{
+			"generated": {Exists: true, Files: []string{"generated.go", "generated_gen.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"generated": {
+					{ImportPath: "generatedclient", Dir: "generatedclient"},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+				{ImportPath: "generatedclient", Dir: "generatedclient"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetUseGitattributes(true))
+	})
+
+	t.Run("change ignored file", func(t *testing.T) {
+		diff := map[string]Directory{
+			"generated":  {Exists: true, Files: []string{"generated.pb.go"}},
+			"unimported": {Exists: true, Files: []string{"unimported.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "unimported", Dir: "unimported"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "unimported", Dir: "unimported"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIgnoreFilePatterns("*.pb.go", "*_gen.go"))
+	})
+
+	t.Run("change ignored and non-ignored files", func(t *testing.T) {
+		diff := map[string]Directory{
+			"generated": {Exists: true, Files: []string{"generated.go", "generated.pb.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"generated": {
+					{ImportPath: "generatedclient", Dir: "generatedclient"},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "generated", Dir: "generated"},
+				{ImportPath: "generatedclient", Dir: "generatedclient"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIgnoreFilePatterns("*.pb.go"))
+	})
+
+	t.Run("change ignored and embedded files", func(t *testing.T) {
+		diff := map[string]Directory{
+			"embed/files": {Exists: true, Files: []string{"prodfile", "files.pb.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				"embed": {
+					{ImportPath: "embedclient", Dir: "embedclient"},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: "embed", Dir: "embed"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "embed", Dir: "embed"},
+				{ImportPath: "embedclient", Dir: "embedclient"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want, SetIgnoreFilePatterns("*.pb.go"))
+	})
+
+	t.Run("change non-go file", func(t *testing.T) {
+		diff := map[string]Directory{
+			"embed":      {Exists: true, Files: []string{"README.md"}},
+			"unimported": {Exists: true, Files: []string{"unimported.go"}},
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{},
+			Changes: []Package{
+				{ImportPath: "unimported", Dir: "unimported"},
+			},
+			AllChanges: []Package{
+				{ImportPath: "unimported", Dir: "unimported"},
+			},
+		}
+
+		testChangedPackages(t, diff, nil, want)
+	})
+}
+
+func TestGTA_Prefix(t *testing.T) {
+	// A depends on B and foo
+	// B depends on C and bar
+	// C depends on qux
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirB":   Directory{Exists: true},
+			"dirC":   Directory{Exists: true},
+			"dirFoo": Directory{Exists: true},
+		},
+	}
+
+	graph := &Graph{
+		graph: map[string]map[string]bool{
+			"C": map[string]bool{
+				"B": true,
+			},
+			"B": map[string]bool{
+				"A": true,
+			},
+			"foo": map[string]bool{
+				"A": true,
+			},
+			"bar": map[string]bool{
+				"B": true,
+			},
+			"qux": map[string]bool{
+				"C": true,
+			},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA":   "A",
+			"dirB":   "B",
+			"dirC":   "C",
+			"dirFoo": "foo",
+			"dirBar": "bar",
+			"dirQux": "qux",
+		},
+		graph: graph,
+		errs:  make(map[string]error),
+	}
+	want := []Package{
+		Package{ImportPath: "C"},
+		Package{ImportPath: "foo"},
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes("foo", "C"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := gta.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := pkgs.AllChanges
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_Excludes(t *testing.T) {
+	// A depends on B and foo
+	// B depends on C
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirB":   Directory{Exists: true, Files: []string{"b.go"}},
+			"dirC":   Directory{Exists: true, Files: []string{"c.go"}},
+			"dirFoo": Directory{Exists: true, Files: []string{"foo.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA":   "A",
+			"dirB":   "B",
+			"dirC":   "C",
+			"dirFoo": "foo",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+				},
+				"foo": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	tests := []struct {
+		desc     string
+		prefixes []string
+		excludes []string
+		want     []Package
+	}{
+		{
+			desc:     "exclude",
+			excludes: []string{"B", "fo"},
+			want: []Package{
+				Package{ImportPath: "A"},
+				Package{ImportPath: "C"},
+			},
+		},
+		{
+			desc:     "exclude included prefix",
+			prefixes: []string{"A", "B"},
+			excludes: []string{"B"},
+			want: []Package{
+				Package{ImportPath: "A"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes(tt.prefixes...), SetExcludes(tt.excludes...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_ValidateInternal(t *testing.T) {
+	// B imports A/internal/C, which it is not allowed to import.
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "A/internal/C",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"A/internal/C": map[string]bool{
+					"A": true,
+					"B": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gta.ChangedPackages(); err != nil {
+		t.Fatalf("err = %q; want nil when the graph is not validated", err)
+	}
+
+	gta, err = New(SetDiffer(difr), SetPackager(pkgr), SetValidateInternal(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gta.ChangedPackages()
+	if !errors.Is(err, ErrInternalImport) {
+		t.Fatalf("err = %v; want %v", err, ErrInternalImport)
+	}
+	if want := "B imports A/internal/C"; !strings.Contains(err.Error(), want) {
+		t.Errorf("err = %q; want it to contain %q", err, want)
+	}
+}
+
+func TestGTA_MaxChangedPackages(t *testing.T) {
+	// A depends on B depends on C, and C is changed.
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	tests := []struct {
+		desc    string
+		max     int
+		wantErr bool
+	}{
+		{desc: "unlimited", max: 0},
+		{desc: "at the maximum", max: 3},
+		{desc: "above the maximum", max: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetMaxChangedPackages(tt.max))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := gta.ChangedPackages()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("err = %q; want nil", err)
+				}
+				if len(got.AllChanges) != 3 {
+					t.Errorf("len(AllChanges) = %d; want 3", len(got.AllChanges))
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrTooManyChanges) {
+				t.Fatalf("err = %v; want %v", err, ErrTooManyChanges)
+			}
+			var tooMany *TooManyChangesError
+			if !errors.As(err, &tooMany) {
+				t.Fatalf("err = %v; want a *TooManyChangesError", err)
+			}
+			if want := (TooManyChangesError{Count: 3, Max: 2}); *tooMany != want {
+				t.Errorf("err = %+v; want %+v", *tooMany, want)
+			}
+		})
+	}
+}
+
+func TestGTA_IncludeRegexp(t *testing.T) {
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirCmd":            Directory{Exists: true, Files: []string{"main.go"}},
+			"dirInternal":       Directory{Exists: true, Files: []string{"internal.go"}},
+			"dirInternalAPI":    Directory{Exists: true, Files: []string{"api.go"}},
+			"dirInternalLegacy": Directory{Exists: true, Files: []string{"legacy.go"}},
+			"dirInternalLib":    Directory{Exists: true, Files: []string{"lib.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirCmd":            "example.com/cmd",
+			"dirInternal":       "example.com/internal",
+			"dirInternalAPI":    "example.com/internal/api",
+			"dirInternalLegacy": "example.com/internal/legacy",
+			"dirInternalLib":    "example.com/internal/lib",
+		},
+		graph: &Graph{graph: map[string]map[string]bool{}},
+		errs:  make(map[string]error),
+	}
+
+	// internalNotLegacy matches the packages in example.com/internal except
+	// example.com/internal/legacy, because Go regular expressions do not
+	// support negative lookahead.
+	internalNotLegacy := []*regexp.Regexp{
+		regexp.MustCompile(`^example\.com/internal$`),
+		regexp.MustCompile(`^example\.com/internal/(?:[^l]|l[^e])`),
+	}
+
+	tests := []struct {
+		desc     string
+		prefixes []string
+		res      []*regexp.Regexp
+		want     []Package
+	}{
+		{
+			desc: "not legacy",
+			res:  internalNotLegacy,
+			want: []Package{
+				Package{ImportPath: "example.com/internal"},
+				Package{ImportPath: "example.com/internal/api"},
+				Package{ImportPath: "example.com/internal/lib"},
+			},
+		},
+		{
+			desc:     "prefixes and regexps",
+			prefixes: []string{"example.com/cmd"},
+			res:      internalNotLegacy,
+			want: []Package{
+				Package{ImportPath: "example.com/cmd"},
+				Package{ImportPath: "example.com/internal"},
+				Package{ImportPath: "example.com/internal/api"},
+				Package{ImportPath: "example.com/internal/lib"},
+			},
+		},
+		{
+			desc: "unanchored",
+			res:  []*regexp.Regexp{regexp.MustCompile(`legacy`)},
+			want: []Package{
+				Package{ImportPath: "example.com/internal/legacy"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes(tt.prefixes...), SetIncludeRegexp(tt.res...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseGtaignore(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		want []string
+	}{
+		{
+			desc: "empty",
+			in:   "",
+		},
+		{
+			desc: "prefixes",
+			in:   "example.com/a\nexample.com/b/\n",
+			want: []string{"example.com/a", "example.com/b/"},
+		},
+		{
+			desc: "comments",
+			in:   "# generated code\nexample.com/a # the API client\n#example.com/b\n",
+			want: []string{"example.com/a"},
+		},
+		{
+			desc: "blank lines",
+			in:   "\nexample.com/a\n\n  \n\texample.com/b \r\n",
+			want: []string{"example.com/a", "example.com/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := parseGtaignore([]byte(tt.in))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_gtaignoreFileDiffer(t *testing.T) {
+	// the current directory is in a git repository, but a file differ does not
+	// know its root, so the .gtaignore file is read from the roots.
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, gtaignoreFile), []byte("example.com/gen\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &GTA{
+		differ: NewFileDiffer(nil),
+		roots:  []string{root},
+	}
+
+	got, err := g.gtaignore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"example.com/gen"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_MaxPackageDepth(t *testing.T) {
+	// example.com/a depends on example.com/a/gen/v1/b
+	// example.com/a/gen/v1/b depends on example.com/a/gen/v1/b/c
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "example.com/a",
+			"dirB": "example.com/a/gen/v1/b",
+			"dirC": "example.com/a/gen/v1/b/c",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"example.com/a/gen/v1/b/c": map[string]bool{
+					"example.com/a/gen/v1/b": true,
+				},
+				"example.com/a/gen/v1/b": map[string]bool{
+					"example.com/a": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	tests := []struct {
+		desc  string
+		depth int
+		want  []Package
+	}{
+		{
+			desc: "unlimited",
+			want: []Package{
+				{ImportPath: "example.com/a"},
+				{ImportPath: "example.com/a/gen/v1/b"},
+				{ImportPath: "example.com/a/gen/v1/b/c"},
+			},
+		},
+		{
+			desc:  "changed package excluded",
+			depth: 5,
+			want: []Package{
+				{ImportPath: "example.com/a"},
+				{ImportPath: "example.com/a/gen/v1/b"},
+			},
+		},
+		{
+			desc:  "dependents excluded",
+			depth: 2,
+			want: []Package{
+				{ImportPath: "example.com/a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetMaxPackageDepth(tt.depth))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := New(SetMaxPackageDepth(-1)); err == nil {
+		t.Error("expected an error for a negative depth")
+	}
+}
+
+func TestGTA_MaxDepth(t *testing.T) {
+	// A depends on B depends on C
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"C": []Package{
+				{ImportPath: "B"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "C"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "B"},
+			{ImportPath: "C"},
+		},
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetMaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gta.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestSetTags(t *testing.T) {
+	tests := []struct {
+		desc string
+		tags []string
+		want []string
+	}{
+		{
+			desc: "none",
+			tags: nil,
+			want: nil,
+		},
+		{
+			desc: "empty",
+			tags: []string{""},
+			want: nil,
+		},
+		{
+			desc: "single",
+			tags: []string{"integration"},
+			want: []string{"integration"},
+		},
+		{
+			desc: "comma separated",
+			tags: []string{"integration,e2e"},
+			want: []string{"integration", "e2e"},
+		},
+		{
+			desc: "space separated",
+			tags: []string{"integration e2e"},
+			want: []string{"integration", "e2e"},
+		},
+		{
+			desc: "mixed",
+			tags: []string{" integration, e2e\tslow,,", "linux"},
+			want: []string{"integration", "e2e", "slow", "linux"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(&testDiffer{}), SetPackager(&testPackager{}), SetTags(tt.tags...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, gta.tags); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_SetLogger(t *testing.T) {
+	// A depends on B depends on C
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes("B", "C"), SetLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gta.ChangedPackages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records are identified by their messages and the directory or
+	// package that they are about.
+	var got []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record struct {
+			Msg     string `json:"msg"`
+			Dir     string `json:"dir"`
+			Package string `json:"package"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record.Msg+" "+record.Dir+record.Package)
+	}
+
+	want := []string{
+		"changed directory dirC",
+		"changed package C",
+		"excluded package A",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+// loadErrPackager is a testPackager whose dependency graph is returned with an
+// error about packages that could not be loaded.
+type loadErrPackager struct {
+	*testPackager
+	err error
+}
+
+func (p *loadErrPackager) DependentGraph() (*Graph, error) {
+	return p.graph, p.err
+}
+
+func TestGTA_NoDefaultLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &loadErrPackager{
+		testPackager: &testPackager{
+			dirs2Imports: map[string]string{
+				"dirC": "C",
+			},
+			graph: &Graph{graph: map[string]map[string]bool{}},
+		},
+		err: &graphError{Errors: map[string]error{"B": errors.New("B/b.go:1:1: expected 'package'")}},
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gta.ChangedPackages(); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() > 0 {
+		t.Errorf("logged %q without a logger; want nothing", buf.String())
+	}
+}
+
+func TestGTA_MarkedPackages(t *testing.T) {
+	// A depends on B depends on C
+	// D depends on B
+	// E depends on F depends on G
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+			"dirH": Directory{Exists: true, Files: []string{"h.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+			"dirD": "D",
+			"dirF": "E",
+			"dirG": "F",
+			"dirH": "G",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+					"D": true,
+				},
+				"G": map[string]bool{
+					"F": true,
+				},
+				"F": map[string]bool{
+					"E": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	want := map[string]map[string]bool{
+		"C": map[string]bool{
+			"A": true,
+			"B": true,
+			"C": true,
+			"D": true,
+		},
+		"G": map[string]bool{
+			"E": true,
+			"F": true,
+			"G": true,
+		},
+	}
+
+	// the marked packages are not filtered by the prefixes.
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gta.MarkedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_ImportAliases(t *testing.T) {
+	// A depends on M, an alias of C
+	// B depends on C
+	graph := &Graph{
+		graph: map[string]map[string]bool{
+			"C": map[string]bool{
+				"B": true,
+			},
+			"M": map[string]bool{
+				"A": true,
+			},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+			"dirM": "M",
+		},
+		graph: graph,
+		errs:  make(map[string]error),
+	}
+
+	tests := []struct {
+		desc string
+		dir  string
+		want []Package
+	}{
+		{
+			desc: "canonical changed",
+			dir:  "dirC",
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+				{ImportPath: "C"},
+			},
+		},
+		{
+			desc: "alias changed",
+			dir:  "dirM",
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+				{ImportPath: "M"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					tt.dir: Directory{Exists: true, Files: []string{"foo.go"}},
+				},
+			}
+
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetImportAliases(map[string]string{"M": "C"}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_Roots(t *testing.T) {
+	// _root would be ignored by the go tool unless it is a root.
+	const dir = "_root/dirC"
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			dir: Directory{Exists: true, Files: []string{"c.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			dir: "C",
+		},
+		graph: &Graph{graph: map[string]map[string]bool{}},
+		errs:  make(map[string]error),
+	}
+
+	tests := []struct {
+		desc  string
+		roots []string
+		want  []Package
+	}{
+		{
+			desc:  "ignored",
+			roots: []string{"/"},
+		},
+		{
+			desc:  "root",
+			roots: []string{"_root"},
+			want: []Package{
+				{ImportPath: "C"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots(tt.roots...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.roots, gta.roots); diff != "" {
+				t.Errorf("roots (-want, +got)\n%s", diff)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_IncludeDirectories(t *testing.T) {
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC":         Directory{Exists: true, Files: []string{"c.go", "README.md"}},
+			"dirC/testdir": Directory{Exists: false, Files: []string{"data.json"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirC": "C",
+		},
+		graph: &Graph{graph: map[string]map[string]bool{}},
+		errs:  make(map[string]error),
+	}
+
+	tests := []struct {
+		desc               string
+		includeDirectories bool
+		want               map[string][]string
+	}{
+		{
+			desc: "excluded",
+		},
+		{
+			desc:               "included",
+			includeDirectories: true,
+			want: map[string][]string{
+				"dirC":         {"README.md", "c.go"},
+				"dirC/testdir": {"data.json"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetIncludeDirectories(tt.includeDirectories))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.Directories); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_Tags(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// the changed package and its dependent only have files for the tag
+	// custom. Without the tag, the changed package is still reported, like a
+	// package whose files were all excluded by a changed build constraint,
+	// but its dependent is not loaded.
+	files := map[string]string{
+		"go.mod":         "module gta.test\n\ngo 1.18\n",
+		"other/other.go": "package other\n",
+		"gated/gated.go": "//go:build custom\n\npackage gated\n",
+		"user/user.go":   "//go:build custom\n\npackage user\n\nimport _ \"gta.test/gated\"\n",
+	}
+	for fn, contents := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	tests := []struct {
+		desc    string
+		options []Option
+		want    []string
+	}{
+		{
+			desc: "no tags",
+			want: []string{"gta.test/gated"},
+		},
+		{
+			desc:    "tags",
+			options: []Option{SetTags("custom")},
+			want:    []string{"gta.test/gated", "gta.test/user"},
+		},
+		{
+			desc:    "tags build flag",
+			options: []Option{SetBuildFlags("-tags=custom")},
+			want:    []string{"gta.test/gated", "gta.test/user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := append([]Option{
+				SetDiffer(NewFileDiffer([]string{filepath.Join(dir, "gated", "gated.go")})),
+				SetRoots(dir),
+			}, tt.options...)
+
+			gta, err := New(options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			var got []string
+			for _, pkg := range pkgs.AllChanges {
+				got = append(got, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestToplevel_Workspace(t *testing.T) {
+	dir := workspace(t, "gta.test/a", "gta.test/b")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	got, err := toplevel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_Workspace(t *testing.T) {
+	dir := workspace(t, "gta.test/a", "gta.test/b")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a/a.go":               "package a\n",
+		"b/b.go":               "package b\n\nimport _ \"gta.test/a\"\n",
+		"b/bclient/bclient.go": "package bclient\n\nimport _ \"gta.test/b\"\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "a"): {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	sut, err := New(SetDiffer(difr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sut.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"gta.test/b", "gta.test/b/bclient"}
+	if diff := cmp.Diff(want, stringify(got.Dependencies["gta.test/a"])); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_SetModules(t *testing.T) {
+	dir := workspace(t, "gta.test/a", "gta.test/b")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a/a.go":               "package a\n",
+		"a/aclient/aclient.go": "package aclient\n\nimport _ \"gta.test/a\"\n",
+		"b/b.go":               "package b\n\nimport _ \"gta.test/a\"\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "a"): {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	// the module is relative to the current directory.
+	sut, err := New(SetDiffer(difr), SetModules("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{filepath.Join(dir, "a")}, sut.roots); diff != "" {
+		t.Errorf("roots (-want, +got)\n%s", diff)
+	}
+
+	got, err := sut.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gta.test/b imports gta.test/a, but its module is not analyzed.
+	want := []string{"gta.test/a", "gta.test/a/aclient"}
+	if diff := cmp.Diff(want, stringify(got.AllChanges)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_WorkspaceModules(t *testing.T) {
+	dir := workspace(t, "gta.test/a", "gta.test/b")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a/a.go":               "package a\n",
+		"b/b.go":               "package b\n\nimport _ \"gta.test/a\"\n",
+		"b/bclient/bclient.go": "package bclient\n\nimport _ \"gta.test/b\"\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "a"): {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	sut, err := New(SetDiffer(difr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sut.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"gta.test/a":         "gta.test/a",
+		"gta.test/b":         "gta.test/b",
+		"gta.test/b/bclient": "gta.test/b",
+	}
+	modules := make(map[string]string)
+	for _, pkg := range got.AllChanges {
+		modules[pkg.ImportPath] = pkg.Module
+	}
+	if diff := cmp.Diff(want, modules); diff != "" {
+		t.Errorf("modules (-want, +got)\n%s", diff)
+	}
+
+	b, err := got.MarshalJSONFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var full Packages
+	if err := json.Unmarshal(b, &full); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got.AllChanges, full.AllChanges); diff != "" {
+		t.Errorf("MarshalJSONFull round trip (-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_LocalReplace(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gta.test/lib is a module in a directory of the main module whose path
+	// does not match its import path.
+	files := map[string]string{
+		"go.mod":                       "module gta.test/app\n\ngo 1.18\n\nrequire gta.test/lib v0.0.0\n\nreplace gta.test/lib => ./third_party/lib\n",
+		"app.go":                       "package app\n\nimport _ \"gta.test/lib/util\"\n",
+		"third_party/lib/go.mod":       "module gta.test/lib\n\ngo 1.18\n",
+		"third_party/lib/util/util.go": "package util\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "third_party", "lib", "util"): {Exists: true, Files: []string{"util.go"}},
+		},
+	}
+
+	sut, err := New(SetDiffer(difr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sut.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"gta.test/app":      "gta.test/app",
+		"gta.test/lib/util": "gta.test/lib",
+	}
+	modules := make(map[string]string)
+	for _, pkg := range got.AllChanges {
+		modules[pkg.ImportPath] = pkg.Module
+	}
+	if diff := cmp.Diff(want, modules); diff != "" {
+		t.Errorf("modules (-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_SetPropagateThroughTestImports(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// external imports lib from an external _test package, internal imports
+	// lib from a _test.go file of its own package, and each of them has a
+	// dependent.
+	files := map[string]string{
+		"go.mod":                           "module gta.test\n\ngo 1.18\n",
+		"lib/lib.go":                       "package lib\n\nfunc V() {}\n",
+		"external/external.go":             "package external\n",
+		"external/external_test.go":        "package external_test\n\nimport (\n\t\"testing\"\n\n\t\"gta.test/lib\"\n)\n\nfunc TestV(t *testing.T) { lib.V() }\n",
+		"externalclient/externalclient.go": "package externalclient\n\nimport _ \"gta.test/external\"\n",
+		"internal/internal.go":             "package internal\n",
+		"internal/internal_test.go":        "package internal\n\nimport (\n\t\"testing\"\n\n\t\"gta.test/lib\"\n)\n\nfunc TestV(t *testing.T) { lib.V() }\n",
+		"internalclient/internalclient.go": "package internalclient\n\nimport _ \"gta.test/internal\"\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	tests := []struct {
+		desc                        string
+		propagateThroughTestImports bool
+		want                        []string
+	}{
+		{
+			desc:                        "propagate",
+			propagateThroughTestImports: true,
+			want:                        []string{"gta.test/external", "gta.test/externalclient", "gta.test/internal", "gta.test/internalclient", "gta.test/lib"},
+		},
+		{
+			desc: "do not propagate",
+			want: []string{"gta.test/external", "gta.test/internal", "gta.test/lib"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					filepath.Join(dir, "lib"): {Exists: true, Files: []string{"lib.go"}},
+				},
+			}
+
+			sut, err := New(SetDiffer(difr), SetPropagateThroughTestImports(tt.propagateThroughTestImports))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sut.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var importPaths []string
+			for _, pkg := range got.AllChanges {
+				importPaths = append(importPaths, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_SetBuildFlags(t *testing.T) {
+	dir := workspace(t, "gta.test/a")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	if err := os.WriteFile(filepath.Join(dir, "a", "a.go"), []byte("package a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "a"): {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	// the go command rejects the unknown flag, which shows that the flags are
+	// passed to it.
+	sut, err := New(SetDiffer(difr), SetBuildFlags("-mod=mod", "-gta-unknown-flag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sut.ChangedPackages()
+	if err == nil || !strings.Contains(err.Error(), "gta-unknown-flag") {
+		t.Errorf("err = %v; want an error about -gta-unknown-flag", err)
+	}
+}
+
+func TestGTA_SetCGO(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// the Go files of cgoonly all import C, so the package only exists when
+	// cgo is enabled.
+	files := map[string]string{
+		"go.mod":           "module gta.test\n\ngo 1.18\n",
+		"cgoonly/cgo.go":   "package cgoonly\n\n// int answer() { return 42; }\nimport \"C\"\n\nfunc Answer() int { return int(C.answer()) }\n",
+		"client/client.go": "package client\n\nimport _ \"gta.test/cgoonly\"\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	tests := []struct {
+		desc    string
+		cgo     bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc:    "disabled",
+			cgo:     false,
+			wantErr: true,
+		},
+		{
+			desc: "enabled",
+			cgo:  true,
+			want: []string{"gta.test/client"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			sut, err := New(SetDiffer(&testDiffer{}), SetCGO(tt.cgo))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := sut.Dependents("gta.test/cgoonly")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("err = nil; want an error, because gta.test/cgoonly is not in the graph")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var importPaths []string
+			for _, pkg := range got {
+				importPaths = append(importPaths, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_Strict(t *testing.T) {
+	dir := workspace(t, "gta.test/a")
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// resolve symlinks in the temporary directory, because the go tool reports
+	// the real path.
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a/a.go":        "package a\n",
+		"a/b/b.go":      "package b\n\nimport _ \"gta.test/a\"\n",
+		"a/b/broken.go": "package b\n\nimport (\n",
+	}
+	for fn, src := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			filepath.Join(dir, "a"): {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		sut, err := New(SetDiffer(difr), SetStrict(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = sut.ChangedPackages()
+
+		var ge *graphError
+		if !errors.As(err, &ge) {
+			t.Fatalf("err = %v; want a *graphError", err)
+		}
+
+		if _, ok := ge.Errors["gta.test/a/b"]; !ok {
+			t.Errorf("missing error for gta.test/a/b in %v", ge.Errors)
+		}
+	})
+
+	t.Run("not strict", func(t *testing.T) {
+		sut, err := New(SetDiffer(difr), SetStrict(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"gta.test/a/b"}
+		if diff := cmp.Diff(want, stringify(got.Dependencies["gta.test/a"])); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+}
+
+func TestGTA_Overlay(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		difr := &testDiffer{
+			diff: map[string]Directory{
+				exporter.Filename(e, testModule, "foo"): {Exists: true, Files: []string{"foo.go"}},
+			},
+		}
+
+		// make unimported import foo without changing it on disk.
+		overlay := map[string][]byte{
+			exporter.Filename(e, testModule, "unimported/unimported.go"): []byte(`package unimported
+
+import "gta.test/foo"
+
+type V foo.V
+`),
+		}
+
+		sut, err := New(SetDiffer(difr), SetOverlay(overlay), SetPrefixes(testModule+"/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			testModule + "/fooclient",
+			testModule + "/fooclientclient",
+			testModule + "/unimported",
+		}
+		if diff := cmp.Diff(want, stringify(got.Dependencies[testModule+"/foo"])); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+}
+
+func TestGTA_GoSum(t *testing.T) {
+	// A depends on example.com/dep/b depends on example.com/dep
+	// C depends on example.com/other
+	// D depends on vendored example.com/dep/c
+	difr := &testDiffer{
+		diff: map[string]Directory{},
+		goSum: map[string]struct{}{
+			"example.com/dep": struct{}{},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA":     "A",
+			"dirC":     "C",
+			"depDir":   "example.com/dep",
+			"depBDir":  "example.com/dep/b",
+			"otherDir": "example.com/other",
+			"dirD":     "D",
+			"depCDir":  "gta.test/vendor/example.com/dep/c",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"example.com/dep": map[string]bool{
+					"example.com/dep/b": true,
+				},
+				"example.com/dep/b": map[string]bool{
+					"A": true,
+				},
+				"example.com/other": map[string]bool{
+					"C": true,
+				},
+				"gta.test/vendor/example.com/dep/c": map[string]bool{
+					"D": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	tests := []struct {
+		desc     string
+		useGoSum bool
+		want     []Package
+	}{
+		{
+			desc: "disabled",
+		},
+		{
+			desc:     "enabled",
+			useGoSum: true,
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "D"},
+				{ImportPath: "example.com/dep"},
+				{ImportPath: "example.com/dep/b"},
+				{ImportPath: "gta.test/vendor/example.com/dep/c"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetUseGoSum(tt.useGoSum), SetIncludeReasons(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+
+			for _, pkg := range pkgs.Changes {
+				if got := pkgs.Reasons[pkg.ImportPath]; got != "changed go.sum" {
+					t.Errorf("Reasons[%q] = %q; want %q", pkg.ImportPath, got, "changed go.sum")
+				}
+			}
+
+			changes, err := gta.Changes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(pkgs.Changes, changes); diff != "" {
+				t.Errorf("Changes() (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_APIChangeDetection(t *testing.T) {
+	// B depends on A
+	const base = `package a
+
+func F() int { return f() }
+
+func f() int { return 1 }
+`
+
+	tests := []struct {
+		desc string
+		// base is the contents of a.go before the change. The base above is
+		// used when it is empty.
+		base               string
+		head               string
+		apiChangeDetection bool
+		want               []Package
+	}{
+		{
+			desc: "unexported change",
+			head: `package a
+
+func F() int { return f() }
+
+func f() int { return 2 }
+`,
+			apiChangeDetection: true,
+			want: []Package{
+				{ImportPath: "A"},
+			},
+		},
+		{
+			desc: "exported change",
+			head: `package a
+
+func F() string { return "" }
+`,
+			apiChangeDetection: true,
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+			},
+		},
+		{
+			desc: "unexported change without detection",
+			head: `package a
+
+func F() int { return f() }
+
+func f() int { return 2 }
+`,
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+			},
+		},
+		{
+			desc: "init change",
+			base: `package a
+
+var v int
+
+func init() { v = 1 }
+`,
+			head: `package a
+
+var v int
+
+func init() { v = 2 }
+`,
+			apiChangeDetection: true,
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			baseSrc := base
+			if tt.base != "" {
+				baseSrc = tt.base
+			}
+
+			dir := t.TempDir()
+			fn := filepath.Join(dir, "a.go")
+			if err := os.WriteFile(fn, []byte(tt.head), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					dir: Directory{Exists: true, Files: []string{"a.go"}},
+				},
+				base: map[string][]byte{
+					fn: []byte(baseSrc),
+				},
+			}
+
+			pkgr := &testPackager{
+				dirs2Imports: map[string]string{
+					dir:    "A",
+					"dirB": "B",
+				},
+				graph: &Graph{
+					graph: map[string]map[string]bool{
+						"A": map[string]bool{
+							"B": true,
+						},
+					},
+				},
+				errs: make(map[string]error),
+			}
+
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots("/"), SetAPIChangeDetection(tt.apiChangeDetection))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_GoModChangesWholeModule(t *testing.T) {
+	root := t.TempDir()
+	for _, fn := range []string{
+		"go.mod",
+		"a/a.go",
+		"b/b.go",
+		"b/testdata/data.go",
+		"_ignored/ignored.go",
+		"nested/go.mod",
+		"nested/nested.go",
+	} {
+		fn = filepath.Join(root, fn)
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			root: Directory{Exists: true, Files: []string{"go.mod"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			filepath.Join(root, "a"): "A",
+			filepath.Join(root, "b"): "B",
+		},
+		graph: &Graph{graph: map[string]map[string]bool{}},
+		errs: map[string]error{
+			root: &build.NoGoError{Dir: root},
+		},
+	}
+
+	tests := []struct {
+		desc                    string
+		goModChangesWholeModule bool
+		prefixes                []string
+		want                    []Package
+	}{
+		{
+			desc: "disabled",
+		},
+		{
+			desc:                    "enabled",
+			goModChangesWholeModule: true,
+			want: []Package{
+				{ImportPath: "A"},
+				{ImportPath: "B"},
+			},
+		},
+		{
+			desc:                    "enabled with prefixes",
+			goModChangesWholeModule: true,
+			prefixes:                []string{"B"},
+			want: []Package{
+				{ImportPath: "B"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots(root), SetPrefixes(tt.prefixes...), SetGoModChangesWholeModule(tt.goModChangesWholeModule), SetIncludeReasons(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+
+			for _, pkg := range pkgs.Changes {
+				if got := pkgs.Reasons[pkg.ImportPath]; got != "changed go.mod" {
+					t.Errorf("Reasons[%q] = %q; want %q", pkg.ImportPath, got, "changed go.mod")
+				}
+			}
+		})
+	}
+}
+
+func TestGTA_Godebug(t *testing.T) {
+	const gomod = `module gta.test
+
+go 1.23
+`
+
+	tests := []struct {
+		desc string
+		head string
+		// newGoMod is true when go.mod did not exist before the change.
+		newGoMod bool
+		// noBase is true when the contents of go.mod before the change are not
+		// known.
+		noBase   bool
+		disabled bool
+		want     []Package
+	}{
+		{
+			desc: "unchanged",
+			head: gomod + `
+require example.com/dep v1.0.0
+`,
+		},
+		{
+			desc: "disabled",
+			head: gomod + `
+godebug default=go1.21
+`,
+			disabled: true,
+		},
+		{
+			desc:     "new go.mod",
+			head:     gomod,
+			newGoMod: true,
+			want: []Package{
+				{ImportPath: "gta.test/cmd/app"},
+			},
+		},
+		{
+			desc:   "unknown base",
+			head:   gomod,
+			noBase: true,
+			want: []Package{
+				{ImportPath: "gta.test/cmd/app"},
+			},
+		},
+		{
+			desc: "added",
+			head: gomod + `
+godebug default=go1.21
+`,
+			want: []Package{
+				{ImportPath: "gta.test/cmd/app"},
+			},
+		},
+		{
+			desc: "added to block",
+			head: gomod + `
+godebug (
+	default=go1.21
+	panicnil=1 // comment
+)
+`,
+			want: []Package{
+				{ImportPath: "gta.test/cmd/app"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			root := t.TempDir()
+			for fn, src := range map[string]string{
+				"go.mod":          tt.head,
+				"cmd/app/main.go": "//go:debug panicnil=1\n\npackage main\n\nfunc main() {}\n",
+				"lib/lib.go":      "package lib\n",
+			} {
+				fn = filepath.Join(root, fn)
+				if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					root: Directory{Exists: true, Files: []string{"go.mod"}},
+				},
+			}
+			switch {
+			case tt.newGoMod:
+				difr.base = map[string][]byte{}
+			case !tt.noBase:
+				difr.base = map[string][]byte{
+					filepath.Join(root, "go.mod"): []byte(gomod),
+				}
+			}
+
+			pkgr := &testPackager{
+				dirs2Imports: map[string]string{
+					filepath.Join(root, "cmd", "app"): "gta.test/cmd/app",
+					filepath.Join(root, "lib"):        "gta.test/lib",
+				},
+				graph: &Graph{graph: map[string]map[string]bool{}},
+				errs: map[string]error{
+					root: &build.NoGoError{Dir: root},
+				},
+			}
+
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots(root), SetGodebugChangesMains(!tt.disabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, pkgs.AllChanges); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+// vendorPackager is a testPackager whose packages in vendor directories have
+// the import paths of the packages they are copies of, like those of the
+// default Packager.
+type vendorPackager struct {
+	*testPackager
+	dirs map[string]string
+}
+
+func (p *vendorPackager) PackageFromImport(importPath string) (*Package, error) {
+	return &Package{ImportPath: stripVendor(importPath), Dir: p.dirs[importPath]}, nil
+}
+
+func TestGTA_VendorDuplicates(t *testing.T) {
+	defer Setenv(t, "GO111MODULE", "on")()
+
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirA": {Exists: true, Files: []string{"a.go"}},
+		},
+	}
+
+	// app imports the vendored copy of lib, which imports A, and cmd imports
+	// lib itself, which is not in a directory of the repository.
+	pkgr := &vendorPackager{
+		testPackager: &testPackager{
+			dirs2Imports: map[string]string{
+				"dirA": "A",
+			},
+			graph: &Graph{
+				graph: map[string]map[string]bool{
+					"A": {
+						"app/vendor/lib": true,
+						"lib":            true,
+					},
+				},
+			},
+		},
+		dirs: map[string]string{
+			"A":              "dirA",
+			"app/vendor/lib": "app/vendor/lib",
+		},
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gta.ChangedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"A": {
+				{ImportPath: "lib", Dir: "app/vendor/lib"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "A", Dir: "dirA"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "A", Dir: "dirA"},
+			{ImportPath: "lib", Dir: "app/vendor/lib"},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestDedupePackages(t *testing.T) {
+	pkgs := []Package{
+		{ImportPath: "a", Dir: "a"},
+		{ImportPath: "b"},
+		{ImportPath: "b", Dir: "vendor/b"},
+		{ImportPath: "b"},
+		{ImportPath: "c"},
+	}
+
+	want := []Package{
+		{ImportPath: "a", Dir: "a"},
+		{ImportPath: "b", Dir: "vendor/b"},
+		{ImportPath: "c"},
+	}
+
+	if diff := cmp.Diff(want, dedupePackages(pkgs)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_GoVersionChanged(t *testing.T) {
+	const gomod = `module gta.test
+
+go 1.22
+`
+
+	tests := []struct {
+		desc string
+		head string
+		want bool
+	}{
+		{
+			desc: "unchanged",
+			head: gomod + `
+require example.com/dep v1.0.0 // indirect
+`,
+		},
+		{
+			desc: "comment",
+			head: "module gta.test\n\ngo 1.22 // the minimum version\n",
+		},
+		{
+			desc: "go bumped",
+			head: "module gta.test\n\ngo 1.23\n",
+			want: true,
+		},
+		{
+			desc: "toolchain added",
+			head: gomod + `
+toolchain go1.22.3
+`,
+			want: true,
+		},
+		{
+			desc: "unknown directive",
+			head: gomod + `
+unknown example.com/dep
+`,
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte(tt.head), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					root: Directory{Exists: true, Files: []string{"go.mod"}},
+				},
+				base: map[string][]byte{
+					filepath.Join(root, "go.mod"): []byte(gomod),
+				},
+			}
+
+			pkgr := &testPackager{
+				graph: &Graph{graph: map[string]map[string]bool{}},
+				errs: map[string]error{
+					root: &build.NoGoError{Dir: root},
+				},
+			}
+
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgs, err := gta.ChangedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pkgs.GoVersionChanged != tt.want {
+				t.Errorf("GoVersionChanged = %v; want %v", pkgs.GoVersionChanged, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindImportPath(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "nogo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			root: "gta.test",
+		},
+		graph: &Graph{graph: map[string]map[string]bool{}},
+		errs: map[string]error{
+			filepath.Join(root, "nogo"): &build.NoGoError{Dir: filepath.Join(root, "nogo")},
+		},
+	}
+
+	tests := []struct {
+		desc    string
+		abs     string
+		want    string
+		wantErr error
+	}{
+		{
+			desc: "deleted directory at the root",
+			abs:  filepath.Join(root, "deleted"),
+			want: "gta.test/deleted",
+		},
+		{
+			desc: "deleted nested directory",
+			abs:  filepath.Join(root, "deleted", "nested"),
+			want: "gta.test/deleted/nested",
+		},
+		{
+			desc: "deleted directory below a directory without go files",
+			abs:  filepath.Join(root, "nogo", "deleted"),
+			want: "gta.test/nogo/deleted",
+		},
+		{
+			desc:    "deleted directory above the root",
+			abs:     filepath.Join(filepath.Dir(root), "deleted"),
+			wantErr: errImportPathNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gta, err := New(SetDiffer(&testDiffer{}), SetPackager(pkgr), SetRoots(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := gta.findImportPath(tt.abs)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v; want %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGTA_Watchers(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		cfg := newLoadConfig(nil)
+		e.Config.Mode = cfg.Mode
+		e.Config.BuildFlags = cfg.BuildFlags
+		e.Config.Tests = cfg.Tests
+
+		sut, err := New(SetDiffer(&testDiffer{}), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sut.Watchers(testModule + "/fooclientclient")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// bar_test is only imported by the tests of fooclient, but a change to it
+		// still marks fooclient and therefore fooclientclient.
+		var want []string
+		for _, dir := range []string{"bar_test", "foo", "fooclient", "fooclientclient"} {
+			want = append(want, exporter.Filename(e, testModule, dir))
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+}
+
+func TestGTA_MarkedPackages_TopLevelTestdata(t *testing.T) {
+	root := filepath.FromSlash("/src/mod")
+
+	tests := []struct {
+		desc string
+		dir  string
+		want map[string]map[string]bool
+	}{
+		{
+			// the tests of the root package are affected by the changes.
+			desc: "below root",
+			dir:  filepath.Join(root, "testdata"),
+			want: map[string]map[string]bool{
+				"example.com/mod": map[string]bool{
+					"example.com/mod": true,
+				},
+			},
+		},
+		{
+			desc: "relative",
+			dir:  "testdata",
+			want: map[string]map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			difr := &testDiffer{
+				diff: map[string]Directory{
+					tt.dir: Directory{Exists: true, Files: []string{"golden.txt"}},
+				},
+			}
+
+			pkgr := &testPackager{
+				dirs2Imports: map[string]string{
+					root: "example.com/mod",
+				},
+				graph: &Graph{
+					graph: map[string]map[string]bool{
+						"example.com/mod": map[string]bool{
+							"example.com/mod/client": true,
+						},
+					},
+				},
+				errs: map[string]error{
+					".": errors.New("the package in the current directory must not be loaded"),
+				},
+			}
+
+			gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetRoots(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := gta.MarkedPackages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGTA_MarkedRoots(t *testing.T) {
+	// A depends on C and example.com/dep
+	// dirC is dirty and the checksum of example.com/dep changed
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+		},
+		goSum: map[string]struct{}{
+			"example.com/dep": struct{}{},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA":   "A",
+			"dirC":   "C",
+			"depDir": "example.com/dep",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"A": true,
+				},
+				"example.com/dep": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	// the roots are not filtered by the prefixes.
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetPrefixes("A"), SetUseGoSum(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gta.MarkedRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"C":               false,
+		"example.com/dep": false,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestGTA_Dependents(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		cfg := newLoadConfig(nil)
+		e.Config.Mode = cfg.Mode
+		e.Config.BuildFlags = cfg.BuildFlags
+		e.Config.Tests = cfg.Tests
+
+		tests := []struct {
+			desc       string
+			importPath string
+			options    []Option
+			want       []string
+			wantErr    bool
+		}{
+			{
+				desc:       "transitive dependents",
+				importPath: testModule + "/foo",
+				want:       []string{testModule + "/fooclient", testModule + "/fooclientclient"},
+			},
+			{
+				desc:       "prefixes",
+				importPath: testModule + "/foo",
+				options:    []Option{SetPrefixes(testModule + "/fooclientclient")},
+				want:       []string{testModule + "/fooclientclient"},
+			},
+			{
+				desc:       "no dependents",
+				importPath: testModule + "/fooclientclient",
+			},
+			{
+				desc:       "not in the graph",
+				importPath: testModule + "/nonexistent",
+				wantErr:    true,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func(t *testing.T) {
+				options := append([]Option{SetDiffer(&testDiffer{}), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"}))}, tt.options...)
+				sut, err := New(options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := sut.Dependents(tt.importPath)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatal("err = nil; want an error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var importPaths []string
+				for _, pkg := range got {
+					importPaths = append(importPaths, pkg.ImportPath)
+				}
+				if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+					t.Errorf("(-want, +got)\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func TestGTA_IncludeUnbuildable(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportTestdata(t, exporter, testModule, "unbuildable")
+
+		cfg := newLoadConfig(nil)
+		e.Config.Mode = cfg.Mode
+		e.Config.BuildFlags = cfg.BuildFlags
+		e.Config.Tests = cfg.Tests
+
+		difr := &testDiffer{
+			diff: map[string]Directory{
+				exporter.Filename(e, testModule, "unbuildable"): {Exists: true, Files: []string{"unbuildable.go"}},
+			},
+		}
+
+		sut, err := New(
+			SetDiffer(difr),
+			SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"})),
+			SetIncludeUnbuildable(true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Packages{
+			Dependencies: map[string][]Package{
+				testModule + "/unbuildable": {
+					{ImportPath: testModule + "/unbuildableclient", Dir: exporter.Filename(e, testModule, "unbuildableclient")},
+				},
+			},
+			Changes: []Package{
+				{ImportPath: testModule + "/unbuildable", Dir: exporter.Filename(e, testModule, "unbuildable")},
+			},
+			AllChanges: []Package{
+				{ImportPath: testModule + "/unbuildable", Dir: exporter.Filename(e, testModule, "unbuildable")},
+				{ImportPath: testModule + "/unbuildableclient", Dir: exporter.Filename(e, testModule, "unbuildableclient")},
+			},
+		}
+		// the module of the packages is not known in GOPATH mode.
+		packagesEqual := func(pkg1, pkg2 Package) bool {
+			return pkg1.ImportPath == pkg2.ImportPath && pkg1.Dir == pkg2.Dir && pkg1.IsCommand == pkg2.IsCommand
+		}
+		if diff := cmp.Diff(want, got, cmp.Comparer(packagesEqual)); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+}
+
+func TestGTA_AllPackages(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		cfg := newLoadConfig(nil)
+		e.Config.Mode = cfg.Mode
+		e.Config.BuildFlags = cfg.BuildFlags
+		e.Config.Tests = cfg.Tests
+
+		difr := &testDiffer{
+			diff: map[string]Directory{
+				exporter.Filename(e, testModule, "foo"): {Exists: true, Files: []string{"foo.go"}},
+			},
+		}
+
+		tests := []struct {
+			desc    string
+			options []Option
+			want    []string
+		}{
+			{
+				desc: "all",
+				want: []string{
+					testModule + "/bar_test",
+					testModule + "/constrainedlibclient",
+					testModule + "/deleted",
+					testModule + "/deletedclient",
+					testModule + "/embed",
+					testModule + "/embedbroken",
+					testModule + "/embedclient",
+					testModule + "/embedglob",
+					testModule + "/foo",
+					testModule + "/fooclient",
+					testModule + "/fooclientclient",
+					testModule + "/generated",
+					testModule + "/generatedclient",
+					testModule + "/gofilesdeleted",
+					testModule + "/gofilesdeletedclient",
+					testModule + "/testhelper",
+					testModule + "/testhelperclient",
+					testModule + "/unimported",
+				},
+			},
+			{
+				desc:    "prefixes",
+				options: []Option{SetPrefixes(testModule + "/foo")},
+				want: []string{
+					testModule + "/foo",
+					testModule + "/fooclient",
+					testModule + "/fooclientclient",
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.desc, func(t *testing.T) {
+				options := append([]Option{SetDiffer(difr), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"}))}, tt.options...)
+				sut, err := New(options...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := sut.AllPackages()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				all := make(map[string]struct{}, len(got))
+				var importPaths []string
+				for _, pkg := range got {
+					all[pkg.ImportPath] = struct{}{}
+					importPaths = append(importPaths, pkg.ImportPath)
+				}
+				if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+					t.Errorf("(-want, +got)\n%s", diff)
+				}
+
+				// the changed packages are a subset of all of the packages.
+				changed, err := sut.ChangedPackages()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, pkg := range changed.AllChanges {
+					if _, ok := all[pkg.ImportPath]; !ok {
+						t.Errorf("changed package %s is not in AllPackages", pkg.ImportPath)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestGTA_ChangedPackages_Repeated(t *testing.T) {
+	const testModule string = "gta.test"
+
+	packagestest.TestAll(t, func(t *testing.T, exporter packagestest.Exporter) {
+		e := exportGTATest(t, exporter, testModule)
+
+		cfg := newLoadConfig(nil)
+		e.Config.Mode = cfg.Mode
+		e.Config.BuildFlags = cfg.BuildFlags
+		e.Config.Tests = cfg.Tests
+
+		difr := &testDiffer{
+			diff: map[string]Directory{
+				exporter.Filename(e, testModule, "foo"):   {Exists: true, Files: []string{"foo.go"}},
+				exporter.Filename(e, testModule, "embed"): {Exists: true, Files: []string{"embed.go"}},
+			},
+		}
+
+		sut, err := New(SetDiffer(difr), SetPackager(newPackager(e.Config, build.Default, []string{testModule + "/"})), SetIncludeDirectories(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// modifying a result must not affect the results of later calls.
+		first, err := sut.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		first.Changes[0].ImportPath = "modified"
+		first.AllChanges = first.AllChanges[:1]
+		for k := range first.Dependencies {
+			delete(first.Dependencies, k)
+		}
+		for k := range first.Directories {
+			first.Directories[k][0] = "modified.go"
+		}
+
+		var wg sync.WaitGroup
+		results := make([]*Packages, 4)
+		errs := make([]error, len(results))
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = sut.ChangedPackages()
+			}(i)
+		}
+		wg.Wait()
+
+		for i, got := range results {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("call %d (-want, +got)\n%s", i, diff)
+			}
+		}
+	})
+}
+
+func TestGTA_WalkChanged(t *testing.T) {
+	// A depends on B depends on C
+	// D depends on C
+	// dirC and dirE are dirty
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirC": Directory{Exists: true, Files: []string{"c.go"}},
+			"dirE": Directory{Exists: true, Files: []string{"e.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+			"dirD": "D",
+			"dirE": "E",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"C": map[string]bool{
+					"B": true,
+					"D": true,
+				},
+				"B": map[string]bool{
+					"A": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetExcludes("D"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("all", func(t *testing.T) {
+		var got []Package
+		err := gta.WalkChanged(func(pkg Package) error {
+			got = append(got, pkg)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Sort(byPackageImportPath(got))
+
+		pkgs, err := gta.ChangedPackages()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(pkgs.AllChanges, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		errStop := errors.New("stop")
+
+		var calls int
+		err := gta.WalkChanged(func(pkg Package) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) {
+			t.Errorf("err = %v; want %v", err, errStop)
+		}
+		if calls != 1 {
+			t.Errorf("fn was called %d times; want 1", calls)
+		}
+	})
+}
 
 func TestNoBuildableGoFiles(t *testing.T) {
 	// we have changes but they don't belong to any dirty golang files, so no dirty packages
@@ -845,6 +4145,41 @@ func TestSpecialCaseDirectory(t *testing.T) {
 	}
 }
 
+func TestPackages_BlastRadius(t *testing.T) {
+	pkgs := &Packages{
+		Dependencies: map[string][]Package{
+			"foo": []Package{
+				{ImportPath: "bar"},
+				{ImportPath: "baz"},
+			},
+			"qux": []Package{
+				{ImportPath: "baz"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "foo"},
+			{ImportPath: "quux"},
+			{ImportPath: "qux"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "bar"},
+			{ImportPath: "baz"},
+			{ImportPath: "foo"},
+			{ImportPath: "quux"},
+			{ImportPath: "qux"},
+		},
+	}
+
+	want := map[string]int{
+		"foo":  3,
+		"quux": 1,
+		"qux":  2,
+	}
+	if diff := cmp.Diff(want, pkgs.BlastRadius()); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
 func TestUnmarshalJSON(t *testing.T) {
 	want := &Packages{
 		Dependencies: map[string][]Package{
@@ -882,6 +4217,77 @@ func TestUnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestJSONVersion(t *testing.T) {
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"example.com/foo": []Package{
+				{ImportPath: "example.com/bar"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "example.com/foo"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "example.com/bar"},
+			{ImportPath: "example.com/foo"},
+		},
+	}
+
+	tests := []struct {
+		desc string
+		in   string
+	}{
+		{
+			desc: "legacy",
+			in:   `{"dependencies":{"example.com/foo":["example.com/bar"]},"changes":["example.com/foo"],"all_changes":["example.com/bar","example.com/foo"]}`,
+		},
+		{
+			desc: "versioned",
+			in:   `{"version":1,"dependencies":{"example.com/foo":["example.com/bar"]},"changes":["example.com/foo"],"all_changes":["example.com/bar","example.com/foo"]}`,
+		},
+		{
+			desc: "versioned full",
+			in:   `{"version":1,"dependencies":{"example.com/foo":[{"import_path":"example.com/bar"}]},"changes":[{"import_path":"example.com/foo"}],"all_changes":[{"import_path":"example.com/bar"},{"import_path":"example.com/foo"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := new(Packages)
+			if err := json.Unmarshal([]byte(tt.in), got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+
+			// the encoding is always versioned.
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantJSON := `{"version":1,"dependencies":{"example.com/foo":["example.com/bar"]},"changes":["example.com/foo"],"all_changes":["example.com/bar","example.com/foo"]}`; string(b) != wantJSON {
+				t.Errorf("json.Marshal() = %s; want %s", b, wantJSON)
+			}
+
+			roundTripped := new(Packages)
+			if err := json.Unmarshal(b, roundTripped); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, roundTripped); diff != "" {
+				t.Errorf("round trip (-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("newer", func(t *testing.T) {
+		err := json.Unmarshal([]byte(`{"version":2,"changes":["example.com/foo"]}`), new(Packages))
+		if !errors.Is(err, ErrUnsupportedJSONVersion) {
+			t.Errorf("err = %v; want %v", err, ErrUnsupportedJSONVersion)
+		}
+	})
+}
+
 func TestJSONRoundtrip(t *testing.T) {
 	want := &Packages{
 		Dependencies: map[string][]Package{
@@ -922,6 +4328,217 @@ func TestJSONRoundtrip(t *testing.T) {
 	}
 }
 
+func TestJSONDeterministic(t *testing.T) {
+	// A depends on B and C
+	// B depends on C
+	// D depends on C
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirB": Directory{Exists: true, Files: []string{"b.go"}},
+			"dirC": Directory{Exists: true, Files: []string{"c.go", "c2.go"}},
+			"dirE": Directory{Exists: true, Files: []string{"e.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA": "A",
+			"dirB": "B",
+			"dirC": "C",
+			"dirD": "D",
+			"dirE": "E",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"B": map[string]bool{
+					"A": true,
+				},
+				"C": map[string]bool{
+					"A": true,
+					"B": true,
+					"D": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	marshalers := map[string]func(*Packages) ([]byte, error){
+		"json":      (*Packages).MarshalJSON,
+		"json full": (*Packages).MarshalJSONFull,
+	}
+
+	for desc, marshal := range marshalers {
+		t.Run(desc, func(t *testing.T) {
+			var want []byte
+			for i := 0; i < 10; i++ {
+				gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetIncludeDirectories(true))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pkgs, err := gta.ChangedPackages()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := marshal(pkgs)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want == nil {
+					want = got
+					continue
+				}
+
+				if !bytes.Equal(want, got) {
+					t.Fatalf("got %s; want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONRoundtripDirectories(t *testing.T) {
+	want := &Packages{
+		Dependencies: map[string][]Package{},
+		Changes: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+			},
+		},
+		AllChanges: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+			},
+		},
+		Directories: map[string][]string{
+			"/src/do/tools/build/gta":          {"gta.go", "gta_test.go"},
+			"/src/do/tools/build/gta/testdata": {"README.md"},
+		},
+	}
+
+	for _, marshal := range []func() ([]byte, error){want.MarshalJSON, want.MarshalJSONFull} {
+		b, err := marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := new(Packages)
+		err = json.Unmarshal(b, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func TestJSONRoundtripTestOnlyChanges(t *testing.T) {
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"do/tools/build/gta/testutil": []Package{
+				{
+					ImportPath: "do/tools/build/gta",
+				},
+			},
+		},
+		Changes: []Package{
+			{
+				ImportPath: "do/tools/build/gta/testutil",
+			},
+		},
+		AllChanges: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+			},
+			{
+				ImportPath: "do/tools/build/gta/testutil",
+			},
+		},
+		TestOnlyChanges: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+			},
+		},
+	}
+
+	for _, marshal := range []func() ([]byte, error){want.MarshalJSON, want.MarshalJSONFull} {
+		b, err := marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := new(Packages)
+		err = json.Unmarshal(b, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func TestJSONFullRoundtrip(t *testing.T) {
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"do/tools/build/gta": []Package{
+				{
+					ImportPath: "do/tools/build/gta/cmd/gta",
+					Dir:        "/src/do/tools/build/gta/cmd/gta",
+				},
+				{
+					ImportPath: "do/tools/build/gtartifacts",
+				},
+			},
+		},
+		Changes: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+				Dir:        "/src/do/tools/build/gta",
+			},
+		},
+		AllChanges: []Package{
+			{
+				ImportPath: "do/tools/build/gta",
+				Dir:        "/src/do/tools/build/gta",
+			},
+			{
+				ImportPath: "do/tools/build/gta/cmd/gta",
+				Dir:        "/src/do/tools/build/gta/cmd/gta",
+			},
+			{
+				ImportPath: "do/tools/build/gtartifacts",
+			},
+		},
+	}
+
+	b, err := want.MarshalJSONFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const wantJSON = `{"version":1,"dependencies":{"do/tools/build/gta":[{"import_path":"do/tools/build/gta/cmd/gta","dir":"/src/do/tools/build/gta/cmd/gta"},{"import_path":"do/tools/build/gtartifacts"}]},"changes":[{"import_path":"do/tools/build/gta","dir":"/src/do/tools/build/gta"}],"all_changes":[{"import_path":"do/tools/build/gta","dir":"/src/do/tools/build/gta"},{"import_path":"do/tools/build/gta/cmd/gta","dir":"/src/do/tools/build/gta/cmd/gta"},{"import_path":"do/tools/build/gtartifacts"}]}`
+	if diff := cmp.Diff(wantJSON, string(b)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+
+	got := new(Packages)
+	err = json.Unmarshal(b, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
 func TestIsIgnoredByGo(t *testing.T) {
 	tests := []struct {
 		in       string
@@ -975,6 +4592,52 @@ func TestIsIgnoredByGo(t *testing.T) {
 		}
 	}
 
+	// absolute paths are not ignored because of the root of the file system
+	// even when it is not one of the roots.
+	absolute := []struct {
+		in       string
+		expected bool
+	}{
+		{
+			in:       "/",
+			expected: false,
+		}, {
+			in:       "/foo/bar",
+			expected: false,
+		}, {
+			in:       "/_foo/bar",
+			expected: true,
+		}, {
+			in:       "/foo/testdata/bar",
+			expected: true,
+		},
+	}
+	if runtime.GOOS == "windows" {
+		absolute = append(absolute, []struct {
+			in       string
+			expected bool
+		}{
+			{
+				in:       `C:\`,
+				expected: false,
+			}, {
+				in:       `C:\foo\bar`,
+				expected: false,
+			}, {
+				in:       `C:\foo\_bar`,
+				expected: true,
+			}, {
+				in:       `C:\testdata\foo`,
+				expected: true,
+			},
+		}...)
+	}
+	for _, tt := range absolute {
+		got := isIgnoredByGo(tt.in, nil)
+		if want := tt.expected; got != want {
+			t.Errorf("isIgnoredByGoBuild(%q) = %v; want %v", tt.in, got, want)
+		}
+	}
 }
 
 func TestDeepestUnignoredDir(t *testing.T) {
@@ -1023,6 +4686,23 @@ func TestDeepestUnignoredDir(t *testing.T) {
 			expected: "/foo/bar",
 		},
 	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, []struct {
+			in       string
+			expected string
+		}{
+			{
+				in:       `C:\`,
+				expected: `C:\`,
+			}, {
+				in:       `C:\foo\testdata`,
+				expected: `C:\foo`,
+			}, {
+				in:       `C:\_foo\bar`,
+				expected: `C:\`,
+			},
+		}...)
+	}
 	for _, tt := range tests {
 		got := deepestUnignoredDir(tt.in, []string{"/"})
 		if want := tt.expected; got != want {
@@ -1030,3 +4710,69 @@ func TestDeepestUnignoredDir(t *testing.T) {
 		}
 	}
 }
+
+func TestGTA_LoadPatterns(t *testing.T) {
+	tests := []struct {
+		desc       string
+		prefixes   []string
+		modules    []string
+		scopedLoad bool
+		want       []string
+	}{
+		{
+			desc:     "not scoped",
+			prefixes: []string{"example.com/foo"},
+		},
+		{
+			desc:       "scoped",
+			prefixes:   []string{"example.com/foo", "example.com/bar/"},
+			scopedLoad: true,
+			want:       []string{"example.com/foo/...", "example.com/bar/..."},
+		},
+		{
+			desc:       "scoped without prefixes",
+			modules:    []string{"/src/foo"},
+			scopedLoad: true,
+			want:       []string{filepath.Join("/src/foo", "...")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			g := &GTA{prefixes: tt.prefixes, modules: tt.modules, scopedLoad: tt.scopedLoad}
+			if diff := cmp.Diff(tt.want, g.loadPatterns()); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+// BenchmarkNew_ScopedLoad compares loading all of the packages of this module
+// with loading only the packages of a prefix.
+func BenchmarkNew_ScopedLoad(b *testing.B) {
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, scoped := range []bool{false, true} {
+		name := "full"
+		if scoped {
+			name = "scoped"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := New(
+					SetDiffer(NewFileDiffer(nil)),
+					SetRoots(wd),
+					SetPrefixes("github.com/digitalocean/gta/cmd"),
+					SetScopedLoad(scoped),
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -6,15 +6,18 @@ package gtaintegration
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"flag"
 	"fmt"
 	"go/build"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/digitalocean/gta"
@@ -426,6 +429,83 @@ func TestPackageRemoval_MovePackage(t *testing.T) {
 	}
 }
 
+func TestPackageRemoval_MovePackage_DetectMoves(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// move some files to a different directory
+	if _, err := runGit(ctx, ".", "mv", "src/gtaintegration/movedfrom", "src/gtaintegration/movedto"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "move some stuff"); err != nil {
+		t.Fatal(err)
+	}
+
+	options := []gta.Option{
+		gta.SetDiffer(gta.NewGitDiffer()),
+		gta.SetPrefixes("gtaintegration"),
+		gta.SetDetectMoves(true),
+	}
+
+	t.Cleanup(chdir(t, filepath.Join("src", "gtaintegration")))
+
+	gt, err := gta.New(options...)
+	if err != nil {
+		t.Fatalf("can't prepare gta: %v", err)
+	}
+
+	want := &gta.Packages{
+		Dependencies: map[string][]gta.Package{
+			"gtaintegration/movedfrom": []gta.Package{
+				gta.Package{
+					ImportPath: "gtaintegration/movedfromclient",
+				},
+			},
+		},
+		Changes: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/movedfrom",
+			},
+			gta.Package{
+				ImportPath: "gtaintegration/movedto",
+			},
+		},
+		AllChanges: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/movedfrom",
+			},
+			gta.Package{
+				ImportPath: "gtaintegration/movedfromclient",
+			},
+			gta.Package{
+				ImportPath: "gtaintegration/movedto",
+			},
+		},
+		Moves: [][2]gta.Package{
+			{
+				gta.Package{
+					ImportPath: "gtaintegration/movedfrom",
+				},
+				gta.Package{
+					ImportPath: "gtaintegration/movedto",
+				},
+			},
+		},
+	}
+
+	got, err := gt.ChangedPackages()
+	if err != nil {
+		t.Fatalf("err = %q; want nil", err)
+	}
+
+	if diff := cmp.Diff(mapFromPackages(t, want), mapFromPackages(t, got)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
 func TestChangesAfterMergeBaseBranch(t *testing.T) {
 	ctx := context.Background()
 
@@ -552,6 +632,215 @@ func TestChangesAfterMergeBaseBranch(t *testing.T) {
 	}
 }
 
+func TestOctopusMerge(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	change := func(fn, msg string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join("src", "gtaintegration", filepath.FromSlash(fn)), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("\n// changed\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runGit(ctx, ".", "commit", "-a", "-m", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// create two branches that change different packages and merge both of
+	// them at once. After this, the branch topology should look like this:
+	// *-.   merge
+	// |\ \
+	// | | * change unimported
+	// | * | change movedfrom
+	// | |/
+	// * / change deleted
+	// |/
+	// * (master) initial commit
+	for _, branch := range []struct {
+		name, fn string
+	}{
+		{name: t.Name() + "-movedfrom", fn: "movedfrom/movedfrom.go"},
+		{name: t.Name() + "-unimported", fn: "unimported/unimported.go"},
+	} {
+		if _, err := runGit(ctx, ".", "checkout", "-b", branch.name, "master"); err != nil {
+			t.Fatal(err)
+		}
+		change(branch.fn, "change "+branch.fn)
+	}
+
+	if _, err := runGit(ctx, ".", "checkout", t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	change("deleted/deleted.go", "change deleted")
+
+	if _, err := runGit(ctx, ".", "merge", "--no-ff", "-m", "merge", t.Name()+"-movedfrom", t.Name()+"-unimported"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		parentIndex int
+		want        []string
+		wantErr     bool
+	}{
+		{
+			// the changes between the first parent and each of the other
+			// parents are included, but not the changes of the first parent.
+			desc: "all parents",
+			want: []string{
+				"gtaintegration/movedfrom",
+				"gtaintegration/movedfromclient",
+				"gtaintegration/unimported",
+			},
+		},
+		{
+			desc:        "second parent",
+			parentIndex: 2,
+			want: []string{
+				"gtaintegration/movedfrom",
+				"gtaintegration/movedfromclient",
+			},
+		},
+		{
+			desc:        "third parent",
+			parentIndex: 3,
+			want: []string{
+				"gtaintegration/unimported",
+			},
+		},
+		{
+			desc:        "nonexistent parent",
+			parentIndex: 4,
+			wantErr:     true,
+		},
+		{
+			desc:        "first parent",
+			parentIndex: 1,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			differ := gta.NewGitDiffer(gta.SetUseMergeCommit(true), gta.SetMergeParentIndex(tt.parentIndex))
+			gt, err := gta.New(gta.SetDiffer(differ), gta.SetPrefixes("gtaintegration"))
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("err = nil; want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			var importPaths []string
+			for _, pkg := range got.AllChanges {
+				importPaths = append(importPaths, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChangesAfterMergeBaseBranch_DetachedHead(t *testing.T) {
+	ctx := context.Background()
+
+	baseBranch := t.Name() + "-base"
+	// create a base branch
+	if _, err := runGit(ctx, ".", "checkout", "-b", baseBranch, "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// move some files to a different directory
+	if _, err := runGit(ctx, ".", "mv", "src/gtaintegration/movedfrom", "src/gtaintegration/movedto"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "moved some stuff"); err != nil {
+		t.Fatal(err)
+	}
+
+	// create the topic branch from the original branch point of the base branch
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), baseBranch+"^"); err != nil {
+		t.Fatal(err)
+	}
+
+	// fully delete deleted
+	if err := os.RemoveAll(filepath.Clean("src/gtaintegration/deleted")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "delete some stuff"); err != nil {
+		t.Fatal(err)
+	}
+
+	// merge the base branch so that the branch point differs from the merge
+	// base.
+	if _, err := runGit(ctx, ".", "merge", "--no-ff", baseBranch); err != nil {
+		t.Fatal(err)
+	}
+
+	changedPackages := func(t *testing.T) *gta.Packages {
+		t.Helper()
+
+		options := []gta.Option{
+			gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseBranch(baseBranch))),
+			gta.SetPrefixes("gtaintegration"),
+		}
+
+		popd := chdir(t, filepath.Join("src", "gtaintegration"))
+		defer popd()
+
+		gt, err := gta.New(options...)
+		if err != nil {
+			t.Fatalf("can't prepare gta: %v", err)
+		}
+
+		got, err := gt.ChangedPackages()
+		if err != nil {
+			t.Fatalf("err = %q; want nil", err)
+		}
+		return got
+	}
+
+	want := changedPackages(t)
+	if len(want.AllChanges) == 0 {
+		t.Fatal("no changes on the branch")
+	}
+
+	// CI systems usually check out the commit to test instead of the branch.
+	if _, err := runGit(ctx, ".", "checkout", "--detach", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := changedPackages(t)
+
+	if diff := cmp.Diff(mapFromPackages(t, want), mapFromPackages(t, got)); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
 func TestPackageRemoval_MovePackage_NonMasterBranch(t *testing.T) {
 	ctx := context.Background()
 	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
@@ -692,6 +981,1158 @@ func TestNonPackageRemoval(t *testing.T) {
 		t.Errorf("(-want, +got)\n%s", diff)
 	}
 }
+func TestWorkingTree(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// add an untracked file to a package that has a dependent.
+	fn := filepath.Clean("src/gtaintegration/deleted/untracked.go")
+	if err := os.WriteFile(fn, []byte("package deleted\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(fn)
+	})
+
+	tests := []struct {
+		desc               string
+		includeWorkingTree bool
+		want               *gta.Packages
+	}{
+		{
+			desc: "committed changes only",
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{},
+				Changes:      []gta.Package{},
+				AllChanges:   []gta.Package{},
+			},
+		},
+		{
+			desc:               "working tree included",
+			includeWorkingTree: true,
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{
+					"gtaintegration/deleted": []gta.Package{
+						gta.Package{
+							ImportPath: "gtaintegration/deletedclient",
+						},
+					},
+				},
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/deleted",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/deleted",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/deletedclient",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer(gta.SetIncludeWorkingTree(tt.includeWorkingTree))),
+				gta.SetPrefixes("gtaintegration"),
+			}
+
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNonexistentBaseBranch(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc    string
+		options []gta.GitDifferOption
+	}{
+		{
+			desc:    "branch point",
+			options: []gta.GitDifferOption{gta.SetBaseBranch("origin/does-not-exist")},
+		},
+		{
+			desc:    "head to head",
+			options: []gta.GitDifferOption{gta.SetBaseBranch("origin/does-not-exist"), gta.SetUseHeadToHead(true)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(gta.SetDiffer(gta.NewGitDiffer(tt.options...)), gta.SetPrefixes("gtaintegration"))
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			_, err = gt.ChangedPackages()
+			if !stderrors.Is(err, gta.ErrBaseBranchNotFound) {
+				t.Errorf("err = %v; want an error wrapping ErrBaseBranchNotFound", err)
+			}
+		})
+	}
+}
+
+func TestBaseFromFile(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// movedfrom is changed before the last successful build, and unimported is
+	// changed after it.
+	fn := filepath.Clean("src/gtaintegration/movedfrom/movedfrom.go")
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("\n// changed\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change movedfrom"); err != nil {
+		t.Fatal(err)
+	}
+
+	green, err := runGit(ctx, ".", "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn = filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	if err := os.WriteFile(fn, []byte("package unimported\n\ntype V struct{ N int }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change unimported"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		t.Helper()
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+
+	tests := []struct {
+		desc    string
+		path    string
+		want    *gta.Packages
+		wantErr error
+	}{
+		{
+			desc: "last green",
+			path: write("green", green),
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{},
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+			},
+		},
+		{
+			desc:    "missing file",
+			path:    filepath.Join(dir, "missing"),
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			desc:    "invalid ref",
+			path:    write("invalid", "does-not-exist"),
+			wantErr: gta.ErrBaseBranchNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseFromFile(tt.path))), gta.SetPrefixes("gtaintegration"))
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if tt.wantErr != nil {
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v; want an error wrapping %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPathspec(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"movedfrom/movedfrom.go", "unimported/unimported.go"} {
+		f, err := os.OpenFile(filepath.Join("src", "gtaintegration", filepath.FromSlash(fn)), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("\n// changed\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change packages"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an untracked file outside of the pathspecs is not reported either.
+	if err := os.WriteFile(filepath.Clean("src/gtaintegration/deleted/untracked.go"), []byte("package deleted\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filepath.Clean("src/gtaintegration/deleted/untracked.go"))
+
+	tests := []struct {
+		desc      string
+		pathspecs []string
+		want      []string
+	}{
+		{
+			desc: "all",
+			want: []string{
+				"gtaintegration/deleted",
+				"gtaintegration/deletedclient",
+				"gtaintegration/movedfrom",
+				"gtaintegration/movedfromclient",
+				"gtaintegration/unimported",
+			},
+		},
+		{
+			desc:      "directory",
+			pathspecs: []string{"src/gtaintegration/unimported"},
+			want: []string{
+				"gtaintegration/unimported",
+			},
+		},
+		{
+			desc:      "glob",
+			pathspecs: []string{":(glob)**/movedfrom/*.go"},
+			want: []string{
+				"gtaintegration/movedfrom",
+				"gtaintegration/movedfromclient",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			differ := gta.NewGitDiffer(gta.SetPathspec(tt.pathspecs...), gta.SetIncludeWorkingTree(true))
+			gt, err := gta.New(gta.SetDiffer(differ), gta.SetPrefixes("gtaintegration"))
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			var importPaths []string
+			for _, pkg := range got.AllChanges {
+				importPaths = append(importPaths, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIgnoreWhitespaceChanges(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// reformat unimported without changing anything but whitespace.
+	fn := filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	if err := os.WriteFile(fn, []byte("package  unimported\n\ntype V struct {   }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// fully delete deleted
+	if err := os.RemoveAll(filepath.Clean("src/gtaintegration/deleted")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "reformat and delete"); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := &gta.Packages{
+		Dependencies: map[string][]gta.Package{
+			"gtaintegration/deleted": []gta.Package{
+				gta.Package{
+					ImportPath: "gtaintegration/deletedclient",
+				},
+			},
+		},
+		Changes: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/deleted",
+			},
+		},
+		AllChanges: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/deleted",
+			},
+			gta.Package{
+				ImportPath: "gtaintegration/deletedclient",
+			},
+		},
+	}
+
+	tests := []struct {
+		desc             string
+		ignoreWhitespace bool
+		want             *gta.Packages
+	}{
+		{
+			desc: "whitespace not ignored",
+			want: &gta.Packages{
+				Dependencies: deleted.Dependencies,
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/deleted",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/deleted",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/deletedclient",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+			},
+		},
+		{
+			// the deleted file still counts.
+			desc:             "whitespace ignored",
+			ignoreWhitespace: true,
+			want:             deleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer(gta.SetIgnoreWhitespaceChanges(tt.ignoreWhitespace))),
+				gta.SetPrefixes("gtaintegration"),
+			}
+
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShallowClone(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// branch from master after a commit that is not on the branch, so that the
+	// branch point is not in the history of a clone with a depth of 1.
+	if _, err := runGit(ctx, ".", "commit", "--allow-empty", "-m", "branch point"); err != nil {
+		t.Fatal(err)
+	}
+	base := t.Name()
+	branch := base + "-branch"
+	if _, err := runGit(ctx, ".", "checkout", "-b", branch); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	if err := os.WriteFile(fn, []byte("package unimported\n\ntype V struct{ N int }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change unimported"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "checkout", base); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "--allow-empty", "-m", "after the branch point"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc          string
+		autoUnshallow bool
+		want          *gta.Packages
+		wantErr       error
+	}{
+		{
+			desc:    "shallow",
+			wantErr: gta.ErrShallowClone,
+		},
+		{
+			desc:          "auto unshallow",
+			autoUnshallow: true,
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{},
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			clone := t.TempDir()
+			if _, err := runGit(ctx, ".", "clone", "--quiet", "--depth=1", "--no-single-branch", "--branch", branch, "file://"+abs("."), clone); err != nil {
+				t.Fatal(err)
+			}
+
+			popd := chdir(t, filepath.Join(clone, "src", "gtaintegration"))
+			defer popd()
+
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseBranch("origin/"+base), gta.SetAutoUnshallow(tt.autoUnshallow))),
+				gta.SetPrefixes("gtaintegration"),
+			}
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if tt.wantErr != nil {
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v; want an error wrapping %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFetchBase(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	base := t.Name()
+	branch := base + "-branch"
+	if _, err := runGit(ctx, ".", "checkout", "-b", branch); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	if err := os.WriteFile(fn, []byte("package unimported\n\ntype V struct{ N int }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change unimported"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := &gta.Packages{
+		Dependencies: map[string][]gta.Package{},
+		Changes: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/unimported",
+			},
+		},
+		AllChanges: []gta.Package{
+			gta.Package{
+				ImportPath: "gtaintegration/unimported",
+			},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		base      string
+		fetchBase bool
+		// breakRemote makes fetching from the remote fail.
+		breakRemote bool
+		want        *gta.Packages
+		wantErr     error
+	}{
+		{
+			desc:    "not fetched",
+			base:    "origin/" + base,
+			wantErr: gta.ErrBaseBranchNotFound,
+		},
+		{
+			desc:      "fetched",
+			base:      "origin/" + base,
+			fetchBase: true,
+			want:      changed,
+		},
+		{
+			desc:      "fetched full ref",
+			base:      "refs/remotes/origin/" + base,
+			fetchBase: true,
+			want:      changed,
+		},
+		{
+			desc:        "already resolves",
+			base:        "origin/" + branch,
+			fetchBase:   true,
+			breakRemote: true,
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// a clone of a single branch does not have the remote-tracking
+			// branch of the base branch.
+			clone := t.TempDir()
+			if _, err := runGit(ctx, ".", "clone", "--quiet", "--single-branch", "--branch", branch, "file://"+abs("."), clone); err != nil {
+				t.Fatal(err)
+			}
+			if tt.breakRemote {
+				if _, err := runGit(ctx, clone, "remote", "set-url", "origin", "file://"+filepath.Join(t.TempDir(), "missing")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			popd := chdir(t, filepath.Join(clone, "src", "gtaintegration"))
+			defer popd()
+
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseBranch(tt.base), gta.SetFetchBase(tt.fetchBase))),
+				gta.SetPrefixes("gtaintegration"),
+			}
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if tt.wantErr != nil {
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v; want an error wrapping %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBaseBranches(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	appendComment := func(fn string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.Join("src", "gtaintegration", filepath.FromSlash(fn)), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("\n// changed\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change "+fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the release branch is cut from main and gets a fix of movedfrom, main
+	// moves on, and the feature branch is branched from the release branch.
+	main := t.Name()
+	release := main + "-release"
+	feature := main + "-feature"
+	if _, err := runGit(ctx, ".", "checkout", "-b", release); err != nil {
+		t.Fatal(err)
+	}
+	appendComment("movedfrom/movedfrom.go")
+	if _, err := runGit(ctx, ".", "checkout", main); err != nil {
+		t.Fatal(err)
+	}
+	appendComment("deleted/deleted.go")
+	if _, err := runGit(ctx, ".", "checkout", "-b", feature, release); err != nil {
+		t.Fatal(err)
+	}
+	appendComment("unimported/unimported.go")
+
+	tests := []struct {
+		desc    string
+		bases   []string
+		want    []string
+		wantErr error
+	}{
+		{
+			desc:  "main only",
+			bases: []string{main},
+			want: []string{
+				"gtaintegration/movedfrom",
+				"gtaintegration/movedfromclient",
+				"gtaintegration/unimported",
+			},
+		},
+		{
+			desc:  "nearest",
+			bases: []string{main, release},
+			want:  []string{"gtaintegration/unimported"},
+		},
+		{
+			desc:  "missing candidate",
+			bases: []string{main + "-missing", release},
+			want:  []string{"gtaintegration/unimported"},
+		},
+		{
+			desc:    "no candidate",
+			bases:   []string{main + "-missing"},
+			wantErr: gta.ErrBaseBranchNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseBranches(tt.bases...))),
+				gta.SetPrefixes("gtaintegration"),
+			}
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if tt.wantErr != nil {
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v; want an error wrapping %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			var importPaths []string
+			for _, pkg := range got.AllChanges {
+				importPaths = append(importPaths, pkg.ImportPath)
+			}
+			if diff := cmp.Diff(tt.want, importPaths); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGtaignore(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"deleted/deleted.go", "movedfrom/movedfrom.go", "unimported/unimported.go"} {
+		f, err := os.OpenFile(filepath.Join("src", "gtaintegration", filepath.FromSlash(fn)), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("\n// changed\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change packages"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the .gtaignore file is read from the root of the repository, even when
+	// gta runs in a subdirectory.
+	if err := os.WriteFile(".gtaignore", []byte("# the client is excluded too\ngtaintegration/deleted\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(".gtaignore")
+
+	tests := []struct {
+		desc     string
+		excludes []string
+		want     *gta.Packages
+	}{
+		{
+			desc: ".gtaignore",
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{
+					"gtaintegration/movedfrom": []gta.Package{
+						gta.Package{
+							ImportPath: "gtaintegration/movedfromclient",
+						},
+					},
+				},
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/movedfrom",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/movedfrom",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/movedfromclient",
+					},
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+			},
+		},
+		{
+			desc:     ".gtaignore and excludes",
+			excludes: []string{"gtaintegration/movedfrom"},
+			want: &gta.Packages{
+				Dependencies: map[string][]gta.Package{},
+				Changes: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+				AllChanges: []gta.Package{
+					gta.Package{
+						ImportPath: "gtaintegration/unimported",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer()),
+				gta.SetPrefixes("gtaintegration"),
+				gta.SetExcludes(tt.excludes...),
+			}
+
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if diff := cmp.Diff(mapFromPackages(t, tt.want), mapFromPackages(t, got)); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the single line of the type declaration by three lines, so that
+	// one line is deleted and three lines are added.
+	fn := filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	if err := os.WriteFile(fn, []byte("package unimported\n\ntype V struct {\n\tN int\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change unimported"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc                string
+		includeChangedLines bool
+		want                int
+	}{
+		{
+			desc: "not included",
+			want: 0,
+		},
+		{
+			desc:                "included",
+			includeChangedLines: true,
+			want:                4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := []gta.Option{
+				gta.SetDiffer(gta.NewGitDiffer()),
+				gta.SetPrefixes("gtaintegration"),
+				gta.SetIncludeChangedLines(tt.includeChangedLines),
+			}
+
+			popd := chdir(t, filepath.Join("src", "gtaintegration"))
+			defer popd()
+
+			gt, err := gta.New(options...)
+			if err != nil {
+				t.Fatalf("can't prepare gta: %v", err)
+			}
+
+			got, err := gt.ChangedPackages()
+			if err != nil {
+				t.Fatalf("err = %q; want nil", err)
+			}
+
+			if len(got.Changes) != 1 || got.Changes[0].ImportPath != "gtaintegration/unimported" {
+				t.Fatalf("got.Changes = %v; want only gtaintegration/unimported", got.Changes)
+			}
+
+			if got.Changes[0].ChangedLines != tt.want {
+				t.Errorf("ChangedLines = %d; want %d", got.Changes[0].ChangedLines, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseFileHeadToHead(t *testing.T) {
+	ctx := context.Background()
+	base := t.Name() + "-base"
+
+	fn := filepath.Clean("src/gtaintegration/unimported/unimported.go")
+	commit := func(branch, src string) {
+		t.Helper()
+		if _, err := runGit(ctx, ".", "checkout", "-b", branch, "master"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change unimported on "+branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the base branch moved on after HEAD was branched from it, so the base
+	// contents are those of the merge base rather than those of the base
+	// branch.
+	commit(base, "package unimported\n\ntype B struct{}\n")
+	commit(t.Name(), "package unimported\n\ntype H struct{}\n")
+
+	mergeBase, err := runGit(ctx, ".", "merge-base", base, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := runGit(ctx, ".", "show", "master:"+filepath.ToSlash(fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	differ := gta.NewGitDiffer(gta.SetBaseBranch(base), gta.SetUseHeadToHead(true))
+
+	got, err := differ.(gta.BaseDiffer).BaseFile(abs(fn))
+	if err != nil {
+		t.Fatalf("BaseFile() err = %q; want nil", err)
+	}
+	if string(got) != want {
+		t.Errorf("BaseFile() = %q; want %q", got, want)
+	}
+
+	revision, err := differ.(gta.BaseRevisionDiffer).BaseRevision()
+	if err != nil {
+		t.Fatalf("BaseRevision() err = %q; want nil", err)
+	}
+	if want := strings.TrimSpace(mergeBase); revision != want {
+		t.Errorf("BaseRevision() = %q; want %q", revision, want)
+	}
+}
+
+func TestDiffGoSumInSubdirectory(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the go.sum file is at the root of the repository, outside of the
+	// directory that gta runs in.
+	if err := os.WriteFile("go.sum", []byte("example.com/dep v1.0.0 h1:abc=\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "add", "go.sum"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-m", "add go.sum"); err != nil {
+		t.Fatal(err)
+	}
+
+	popd := chdir(t, filepath.Join("src", "gtaintegration"))
+	defer popd()
+
+	got, err := gta.NewGitDiffer().(gta.GoSumDiffer).DiffGoSum()
+	if err != nil {
+		t.Fatalf("err = %q; want nil", err)
+	}
+
+	want := map[string]struct{}{"example.com/dep": {}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestChangedPackagesConcurrently(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Clean("src/gtaintegration/movedfrom/movedfrom.go")
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("\n// changed\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change movedfrom"); err != nil {
+		t.Fatal(err)
+	}
+
+	popd := chdir(t, filepath.Join("src", "gtaintegration"))
+	defer popd()
+
+	// the nearest of the base branches is chosen while the parents are
+	// computed, which must not race with the other calls.
+	gt, err := gta.New(
+		gta.SetDiffer(gta.NewGitDiffer(gta.SetBaseBranches("origin/feature-branch", "origin/master"))),
+		gta.SetPrefixes("gtaintegration"),
+		gta.SetUseGoSum(true),
+		gta.SetIncludeChangedLines(true),
+	)
+	if err != nil {
+		t.Fatalf("can't prepare gta: %v", err)
+	}
+
+	const n = 4
+	got := make([]*gta.Packages, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = gt.ChangedPackages()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("err = %q; want nil", errs[i])
+		}
+		if diff := cmp.Diff(mapFromPackages(t, got[0]), mapFromPackages(t, got[i])); diff != "" {
+			t.Errorf("call %d (-want, +got)\n%s", i, diff)
+		}
+	}
+
+	if len(got[0].Changes) != 1 || got[0].Changes[0].ImportPath != "gtaintegration/movedfrom" {
+		t.Errorf("Changes = %v; want only gtaintegration/movedfrom", got[0].Changes)
+	}
+}
+
+func TestResetDiffer(t *testing.T) {
+	ctx := context.Background()
+	if _, err := runGit(ctx, ".", "checkout", "-b", t.Name(), "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	appendComment := func(fn string) {
+		t.Helper()
+		f, err := os.OpenFile(filepath.FromSlash(fn), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteString("\n// changed\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runGit(ctx, ".", "commit", "-a", "-m", "change "+fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, filepath.Join("src", "gtaintegration"))
+	defer popd()
+
+	appendComment("movedfrom/movedfrom.go")
+
+	differ, ok := gta.NewGitDiffer().(gta.ResettableDiffer)
+	if !ok {
+		t.Fatal("the git differ does not implement ResettableDiffer")
+	}
+
+	// changed returns the changed files as the names of their directories
+	// joined with their file names.
+	changed := func() map[string]bool {
+		t.Helper()
+		files, err := differ.DiffFiles()
+		if err != nil {
+			t.Fatalf("err = %q; want nil", err)
+		}
+		m := make(map[string]bool, len(files))
+		for fn := range files {
+			m[filepath.ToSlash(filepath.Join(filepath.Base(filepath.Dir(fn)), filepath.Base(fn)))] = true
+		}
+		return m
+	}
+
+	want := map[string]bool{"movedfrom/movedfrom.go": true}
+	if diff := cmp.Diff(want, changed()); diff != "" {
+		t.Errorf("before the new commit (-want, +got)\n%s", diff)
+	}
+
+	appendComment("unimported/unimported.go")
+
+	// the changes are cached until the differ is reset.
+	if diff := cmp.Diff(want, changed()); diff != "" {
+		t.Errorf("before Reset (-want, +got)\n%s", diff)
+	}
+
+	differ.Reset()
+
+	want = map[string]bool{
+		"movedfrom/movedfrom.go":   true,
+		"unimported/unimported.go": true,
+	}
+	if diff := cmp.Diff(want, changed()); diff != "" {
+		t.Errorf("after Reset (-want, +got)\n%s", diff)
+	}
+}
+
 func testMain(m *testing.M) error {
 	flag.Parse()
 
@@ -1,9 +1,15 @@
 package gta
 
 import (
+	"fmt"
+	"go/build"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"golang.org/x/tools/go/packages/packagestest"
 )
 
 // Setenv sets an environment variable, name, to value and returns a function
@@ -70,3 +76,78 @@ func chdir(t *testing.T, dir string) func() {
 		}
 	}
 }
+
+// workspace creates a go.work workspace in a temporary directory that
+// contains a module for each of the module paths in modules and returns the
+// workspace's directory. Each module is in a directory named after the last
+// element of its module path.
+func workspace(t *testing.T, modules ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	work := "go 1.18\n\nuse (\n"
+	for _, module := range modules {
+		name := path.Base(module)
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		gomod := fmt.Sprintf("module %s\n\ngo 1.18\n", module)
+		if err := os.WriteFile(filepath.Join(dir, name, "go.mod"), []byte(gomod), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		work += fmt.Sprintf("\t./%s\n", name)
+	}
+	work += ")\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "go.work"), []byte(work), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// exportGTATest exports testdata/gtatest as the module named name with
+// exporter. The working directory, the environment and the GOPATH of
+// build.Default are set for the exported module until the test completes.
+func exportGTATest(t *testing.T, exporter packagestest.Exporter, name string) *packagestest.Exported {
+	t.Helper()
+
+	return exportTestdata(t, exporter, name, "gtatest")
+}
+
+// exportTestdata is like exportGTATest, but exports the directory dir of
+// testdata instead.
+func exportTestdata(t *testing.T, exporter packagestest.Exporter, name, dir string) *packagestest.Exported {
+	t.Helper()
+
+	e := packagestest.Export(t, exporter, []packagestest.Module{
+		{
+			Name:  name,
+			Files: packagestest.MustCopyFileTree(filepath.Join("testdata", dir)),
+		},
+	})
+	t.Cleanup(e.Cleanup)
+
+	t.Cleanup(chdir(t, exporter.Filename(e, name, "")))
+	t.Cleanup(AllSetenv(t, e.Config.Env))
+
+	// the default build.Context uses GOPATH as its set at initialization and
+	// it must be overridden for each test.
+	for _, v := range e.Config.Env {
+		sl := strings.SplitN(v, "=", 2)
+		if sl[0] != "GOPATH" {
+			continue
+		}
+
+		gopath := build.Default.GOPATH
+		t.Cleanup(func() {
+			build.Default.GOPATH = gopath
+		})
+
+		build.Default.GOPATH = sl[1]
+	}
+
+	return e
+}
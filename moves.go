@@ -0,0 +1,154 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// moves returns the pairs of deleted and added packages in changes whose
+// exported APIs are identical. Each pair is ordered as the deleted package
+// followed by the added package. A deleted package is only paired with an
+// added package when neither of them has the same exported API as another
+// deleted or added package.
+func (g *GTA) moves(changes []Package) ([][2]Package, error) {
+	bd, ok := g.differ.(BaseDiffer)
+	if !ok {
+		return nil, nil
+	}
+
+	dirs, err := g.differ.Diff()
+	if err != nil {
+		return nil, fmt.Errorf("diffing directory for moved packages, %v", err)
+	}
+
+	changed := make(map[string]Package, len(changes))
+	for _, pkg := range changes {
+		changed[pkg.ImportPath] = pkg
+	}
+
+	deleted := make(map[string][]Package)
+	added := make(map[string][]Package)
+	for abs, dir := range dirs {
+		diffFiles := make(map[string]struct{})
+		for _, fn := range dir.Files {
+			if isAPIFile(fn) {
+				diffFiles[fn] = struct{}{}
+			}
+		}
+		if len(diffFiles) == 0 {
+			continue
+		}
+
+		diskFiles, err := apiFilesIn(abs)
+		if err != nil {
+			return nil, err
+		}
+
+		files := make(map[string][]byte)
+		if len(diskFiles) == 0 {
+			// all of the package's files were deleted; get their contents from the
+			// base.
+			for fn := range diffFiles {
+				b, err := bd.BaseFile(filepath.Join(abs, fn))
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						continue
+					}
+					if errors.Is(err, ErrNoBase) {
+						return nil, nil
+					}
+					return nil, err
+				}
+				files[fn] = b
+			}
+
+			importPath, err := g.findImportPath(abs)
+			if err != nil {
+				continue
+			}
+
+			pkg, ok := changed[importPath]
+			if !ok || pkg.Dir != "" {
+				continue
+			}
+
+			api, err := exportedAPI(files)
+			if err != nil || api == "" {
+				continue
+			}
+			deleted[api] = append(deleted[api], pkg)
+			continue
+		}
+
+		// the package is new when all of its files were added.
+		isNew := true
+		for _, fn := range diskFiles {
+			if _, ok := diffFiles[fn]; !ok {
+				isNew = false
+				break
+			}
+
+			_, err := bd.BaseFile(filepath.Join(abs, fn))
+			if err == nil {
+				isNew = false
+				break
+			}
+			if errors.Is(err, ErrNoBase) {
+				return nil, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+
+			b, err := os.ReadFile(filepath.Join(abs, fn))
+			if err != nil {
+				return nil, err
+			}
+			files[fn] = b
+		}
+		if !isNew {
+			continue
+		}
+
+		p, err := g.packager.PackageFromDir(abs)
+		if err != nil {
+			continue
+		}
+
+		pkg, ok := changed[p.ImportPath]
+		if !ok || pkg.Dir == "" {
+			continue
+		}
+
+		api, err := exportedAPI(files)
+		if err != nil || api == "" {
+			continue
+		}
+		added[api] = append(added[api], pkg)
+	}
+
+	var moves [][2]Package
+	for api, from := range deleted {
+		to := added[api]
+		if len(from) != 1 || len(to) != 1 {
+			continue
+		}
+		moves = append(moves, [2]Package{from[0], to[0]})
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i][0].ImportPath < moves[j][0].ImportPath
+	})
+
+	return moves, nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import "errors"
+
+// multiDiffer is a Differ whose changes are the union of the changes of its
+// differs.
+type multiDiffer struct {
+	differs []Differ
+}
+
+// NewMultiDiffer returns a Differ whose changes are the union of the changes of
+// differs, e.g. to combine the committed changes reported by a git differ with
+// the files that a code generator changed reported by a file differ. A
+// directory or file exists when one of the differs reports that it exists,
+// and the changed files of a directory are those of all of the differs. The
+// modules whose checksums changed are those of the differs that are
+// GoSumDiffers, and the changed lines of a file are the most reported by the
+// differs that are LineDiffers. The base contents of files and the base
+// revision are those of the first of the differs that knows them, and Reset
+// resets each of the differs that is a ResettableDiffer.
+func NewMultiDiffer(differs ...Differ) Differ {
+	return &multiDiffer{differs: differs}
+}
+
+// Diff implements the Differ interface.
+func (m *multiDiffer) Diff() (map[string]Directory, error) {
+	dirs := make(map[string]Directory)
+	for _, d := range m.differs {
+		diff, err := d.Diff()
+		if err != nil {
+			return nil, err
+		}
+
+		for abs, dir := range diff {
+			merged, ok := dirs[abs]
+			if !ok {
+				dirs[abs] = Directory{Exists: dir.Exists, Files: append([]string(nil), dir.Files...)}
+				continue
+			}
+
+			merged.Exists = merged.Exists || dir.Exists
+			for _, fn := range dir.Files {
+				if !hasFile(merged.Files, fn) {
+					merged.Files = append(merged.Files, fn)
+				}
+			}
+			dirs[abs] = merged
+		}
+	}
+
+	return dirs, nil
+}
+
+// DiffFiles implements the Differ interface.
+func (m *multiDiffer) DiffFiles() (map[string]bool, error) {
+	files := make(map[string]bool)
+	for _, d := range m.differs {
+		diff, err := d.DiffFiles()
+		if err != nil {
+			return nil, err
+		}
+
+		for abs, exists := range diff {
+			files[abs] = files[abs] || exists
+		}
+	}
+
+	return files, nil
+}
+
+// DiffGoSum implements the GoSumDiffer interface.
+func (m *multiDiffer) DiffGoSum() (map[string]struct{}, error) {
+	modules := make(map[string]struct{})
+	for _, d := range m.differs {
+		gsd, ok := d.(GoSumDiffer)
+		if !ok {
+			continue
+		}
+
+		diff, err := gsd.DiffGoSum()
+		if err != nil {
+			return nil, err
+		}
+
+		for module := range diff {
+			modules[module] = struct{}{}
+		}
+	}
+
+	return modules, nil
+}
+
+// DiffStats implements the StatsDiffer interface.
+func (m *multiDiffer) DiffStats() (DiffStats, error) {
+	files, err := m.DiffFiles()
+	if err != nil {
+		return DiffStats{}, err
+	}
+
+	modules, err := m.DiffGoSum()
+	if err != nil {
+		return DiffStats{}, err
+	}
+
+	stats := DiffStats{
+		FilesChanged:        len(files),
+		GoSumModulesChanged: len(modules),
+	}
+	for _, ok := range files {
+		if !ok {
+			stats.FilesDeleted++
+		}
+	}
+	return stats, nil
+}
+
+// DiffLines implements the LineDiffer interface.
+func (m *multiDiffer) DiffLines() (map[string]int, error) {
+	lines := make(map[string]int)
+	for _, d := range m.differs {
+		ld, ok := d.(LineDiffer)
+		if !ok {
+			continue
+		}
+
+		diff, err := ld.DiffLines()
+		if err != nil {
+			return nil, err
+		}
+
+		for abs, n := range diff {
+			if n > lines[abs] {
+				lines[abs] = n
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+// BaseFile implements the BaseDiffer interface. It returns ErrNoBase when
+// none of the differs knows the contents of abs before it was changed.
+func (m *multiDiffer) BaseFile(abs string) ([]byte, error) {
+	for _, d := range m.differs {
+		bd, ok := d.(BaseDiffer)
+		if !ok {
+			continue
+		}
+
+		b, err := bd.BaseFile(abs)
+		if errors.Is(err, ErrNoBase) {
+			continue
+		}
+		return b, err
+	}
+
+	return nil, ErrNoBase
+}
+
+// BaseRevision implements the BaseRevisionDiffer interface. It returns
+// ErrNoBase when none of the differs knows the revision that the changes are
+// compared to.
+func (m *multiDiffer) BaseRevision() (string, error) {
+	for _, d := range m.differs {
+		brd, ok := d.(BaseRevisionDiffer)
+		if !ok {
+			continue
+		}
+
+		revision, err := brd.BaseRevision()
+		if errors.Is(err, ErrNoBase) {
+			continue
+		}
+		return revision, err
+	}
+
+	return "", ErrNoBase
+}
+
+// root implements the rootDiffer interface.
+func (m *multiDiffer) root() (string, error) {
+	for _, d := range m.differs {
+		rd, ok := d.(rootDiffer)
+		if !ok {
+			continue
+		}
+
+		root, err := rd.root()
+		if errors.Is(err, errNoRoot) {
+			continue
+		}
+		return root, err
+	}
+
+	return "", errNoRoot
+}
+
+// Reset implements the ResettableDiffer interface.
+func (m *multiDiffer) Reset() {
+	for _, d := range m.differs {
+		if rd, ok := d.(ResettableDiffer); ok {
+			rd.Reset()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	_ GoSumDiffer        = &multiDiffer{}
+	_ StatsDiffer        = &multiDiffer{}
+	_ LineDiffer         = &multiDiffer{}
+	_ BaseDiffer         = &multiDiffer{}
+	_ BaseRevisionDiffer = &multiDiffer{}
+	_ ResettableDiffer   = &multiDiffer{}
+)
+
+func TestMultiDiffer(t *testing.T) {
+	root := t.TempDir()
+	dirA := filepath.Join(root, "a")
+	dirB := filepath.Join(root, "b")
+	dirC := filepath.Join(root, "c")
+	for _, fn := range []string{
+		filepath.Join(dirA, "a.go"),
+		filepath.Join(dirA, "a_gen.go"),
+		filepath.Join(dirC, "c_gen.go"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte("package x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// committed is a stand-in for a git differ: a.go was changed, b was
+	// deleted, and c_gen.go was deleted before it was generated again.
+	committed := &testDiffer{
+		diff: map[string]Directory{
+			dirA: {Exists: true, Files: []string{"a.go"}},
+			dirB: {Exists: false, Files: []string{"b.go"}},
+			dirC: {Exists: false, Files: []string{"c_gen.go"}},
+		},
+		goSum: map[string]struct{}{
+			"example.com/dep": {},
+		},
+		base: map[string][]byte{
+			filepath.Join(dirA, "a.go"): []byte("package a\n"),
+		},
+		revision: "abc123",
+	}
+
+	// generated reports the files that a code generator wrote.
+	generated := NewFileDiffer([]string{
+		filepath.Join(dirA, "a_gen.go"),
+		filepath.Join(dirA, "a.go"),
+		filepath.Join(dirC, "c_gen.go"),
+	})
+
+	sut := NewMultiDiffer(committed, generated)
+
+	// the file differ comes first in reversed so that the base contents and
+	// revision must be taken from the next differ, which knows them.
+	reversed := NewMultiDiffer(generated, committed)
+
+	t.Run("Diff", func(t *testing.T) {
+		got, err := sut.Diff()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]Directory{
+			dirA: {Exists: true, Files: []string{"a.go", "a_gen.go"}},
+			dirB: {Exists: false, Files: []string{"b.go"}},
+			dirC: {Exists: true, Files: []string{"c_gen.go"}},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("DiffFiles", func(t *testing.T) {
+		got, err := sut.DiffFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]bool{
+			filepath.Join(dirA, "a.go"):     true,
+			filepath.Join(dirA, "a_gen.go"): true,
+			filepath.Join(dirB, "b.go"):     false,
+			filepath.Join(dirC, "c_gen.go"): true,
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("DiffGoSum", func(t *testing.T) {
+		got, err := sut.(GoSumDiffer).DiffGoSum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]struct{}{
+			"example.com/dep": {},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("DiffStats", func(t *testing.T) {
+		got, err := sut.(StatsDiffer).DiffStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := DiffStats{FilesChanged: 4, FilesDeleted: 1, GoSumModulesChanged: 1}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("(-want, +got)\n%s", diff)
+		}
+	})
+
+	t.Run("BaseFile", func(t *testing.T) {
+		got, err := reversed.(BaseDiffer).BaseFile(filepath.Join(dirA, "a.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "package a\n"; string(got) != want {
+			t.Errorf("BaseFile() = %q; want %q", got, want)
+		}
+
+		_, err = reversed.(BaseDiffer).BaseFile(filepath.Join(dirA, "a_gen.go"))
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("BaseFile() err = %v; want %v", err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("BaseRevision", func(t *testing.T) {
+		got, err := reversed.(BaseRevisionDiffer).BaseRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "abc123"; got != want {
+			t.Errorf("BaseRevision() = %q; want %q", got, want)
+		}
+
+		_, err = NewMultiDiffer(generated).(BaseRevisionDiffer).BaseRevision()
+		if !errors.Is(err, ErrNoBase) {
+			t.Errorf("BaseRevision() err = %v; want %v", err, ErrNoBase)
+		}
+	})
+}
@@ -6,6 +6,15 @@ in the LICENSE file.
 */
 package gta
 
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
 // Option is an option function used to modify a GTA.
 type Option func(*GTA) error
 
@@ -33,10 +42,462 @@ func SetPrefixes(prefixes ...string) Option {
 	}
 }
 
-// SetTags sets a list of build tags to consider.
+// SetIncludeRegexp sets a list of regular expressions matching the import
+// paths of the packages to include. A package is included when its import path
+// matches one of res or has one of the prefixes set with SetPrefixes. The
+// regular expressions are not anchored: a regular expression matches when it
+// matches any part of the import path, so use ^ and $ to match from its start
+// and to its end. For example, `^example\.com/a/` matches the packages below
+// example.com/a, but `example\.com/a/` also matches x.org/example.com/a/b.
+func SetIncludeRegexp(res ...*regexp.Regexp) Option {
+	return func(g *GTA) error {
+		g.includeRegexps = res
+		return nil
+	}
+}
+
+// SetExcludes sets a list of import path prefixes of packages to exclude. The
+// packages are excluded even when they have one of the prefixes set with
+// SetPrefixes. The prefixes listed in the .gtaignore file at the root of the
+// repository are excluded in addition to excludes.
+func SetExcludes(excludes ...string) Option {
+	return func(g *GTA) error {
+		g.excludes = excludes
+		return nil
+	}
+}
+
+// SetTags sets a list of build tags to consider. Like the -tags flag of the go
+// command, each of tags may itself be a list of tags separated by commas or
+// spaces (e.g. "integration,e2e" or "integration e2e"). The same tags are used
+// to load the dependency graph and to decide whether the files of a changed
+// directory are buildable, so that both agree on the files that are excluded
+// by build constraints. The tags of a -tags flag in GOFLAGS are not used.
 func SetTags(tags ...string) Option {
 	return func(g *GTA) error {
-		g.tags = tags
+		g.tags = splitTags(tags)
+		return nil
+	}
+}
+
+// splitTags returns the build tags in tags, splitting each of them on commas
+// and spaces. Empty tags are dropped.
+func splitTags(tags []string) []string {
+	var split []string
+	for _, tag := range tags {
+		split = append(split, strings.FieldsFunc(tag, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return split
+}
+
+// SetBuildFlags sets flags of the go command (e.g. "-mod=mod" or
+// "-gcflags=-N") that are passed to it when the packages are loaded. The tags
+// of a -tags flag in flags are added to the tags set with SetTags instead of
+// replacing them.
+func SetBuildFlags(flags ...string) Option {
+	return func(g *GTA) error {
+		g.buildFlags = flags
+		return nil
+	}
+}
+
+// SetCGO sets whether cgo is enabled when the packages are loaded, regardless
+// of the CGO_ENABLED environment variable. Packages whose Go files all import
+// "C" only exist when cgo is enabled, so they and the edges to their
+// dependents are missing from the dependency graph when it is not (e.g. on a
+// machine without a C compiler). By default, the go command's setting is
+// used.
+func SetCGO(enabled bool) Option {
+	return func(g *GTA) error {
+		g.cgo = &enabled
+		return nil
+	}
+}
+
+// SetScopedLoad sets whether the default packager only loads the packages
+// below the prefixes set with SetPrefixes (and their dependencies), instead of
+// all packages, which is much faster in large repositories. Each prefix is
+// loaded as the pattern prefix/..., so it must be a complete import path
+// (e.g. example.com/foo rather than example.com/fo). It has no effect when
+// there are no prefixes, and the prefixes take the place of the modules set
+// with SetModules for the loaded packages.
+//
+// WARNING: a scoped load can miss changes. The go command skips the files
+// whose build constraints are not satisfied, so a changed package that is only
+// imported through such files (e.g. a dependency that is only imported on
+// Windows) is not connected to its dependents in the dependency graph, and
+// the dependents are not marked. The packages that are only included by
+// SetIncludeRegexp are not loaded either. Only use it when that tradeoff is
+// acceptable. It has no effect when the packager is set with SetPackager.
+func SetScopedLoad(scopedLoad bool) Option {
+	return func(g *GTA) error {
+		g.scopedLoad = scopedLoad
+		return nil
+	}
+}
+
+// SetRoots sets the root directories (i.e. module roots or GOPATH entries) of
+// the packages to consider, bypassing their detection. Directories below a
+// root are ignored using the same rules as the go tool, but the roots
+// themselves never are.
+func SetRoots(roots ...string) Option {
+	return func(g *GTA) error {
+		g.roots = roots
+		return nil
+	}
+}
+
+// SetImportAliases sets a map of alias import paths to their canonical import
+// paths (e.g. a mirror or fork that is reimported under a different path). The
+// dependents of an alias and of its canonical import path are unified so that
+// a change to either marks the dependents of both.
+func SetImportAliases(aliases map[string]string) Option {
+	return func(g *GTA) error {
+		g.aliases = aliases
+		return nil
+	}
+}
+
+// SetUseGitattributes sets whether to read .gitattributes files to find
+// generated files. Changes that are limited to files marked
+// linguist-generated mark their package as changed, but do not mark the
+// package's dependents.
+func SetUseGitattributes(useGitattributes bool) Option {
+	return func(g *GTA) error {
+		g.useGitattributes = useGitattributes
+		return nil
+	}
+}
+
+// SetIncludeDirectories sets whether ChangedPackages should include the
+// directories and files reported by the differ in Packages.Directories.
+func SetIncludeDirectories(includeDirectories bool) Option {
+	return func(g *GTA) error {
+		g.includeDirectories = includeDirectories
+		return nil
+	}
+}
+
+// SetIgnoreFilePatterns sets glob patterns, in the syntax of filepath.Match, of
+// the names of files whose changes are ignored (e.g. "*.pb.go" for generated
+// protocol buffer code). Ignored files are dropped from the files of each
+// changed directory before the changed packages are determined, so a package
+// whose only changed files are ignored is not marked. Changes to files that
+// are not ignored, including files embedded by packages, are not affected. An
+// error is returned when a pattern is malformed.
+func SetIgnoreFilePatterns(patterns ...string) Option {
+	return func(g *GTA) error {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+			}
+		}
+		g.ignoreFilePatterns = patterns
+		return nil
+	}
+}
+
+// SetIgnoreTestFiles sets whether changes to _test.go files are ignored, e.g.
+// when the changes are used to build packages and the tests run elsewhere. Like
+// the files that match the patterns set with SetIgnoreFilePatterns, test files
+// are dropped from the files of each changed directory, so a package whose
+// only changed files are test files is not marked and its dependents are not
+// marked either.
+func SetIgnoreTestFiles(ignoreTestFiles bool) Option {
+	return func(g *GTA) error {
+		g.ignoreTestFiles = ignoreTestFiles
+		return nil
+	}
+}
+
+// SetPropagateThroughTestImports sets whether changes propagate through the
+// imports from _test.go files. When it is false, the packages that import a
+// marked package only from _test.go files (including external _test packages)
+// are marked so that their tests run, but their dependents are not marked,
+// because the non-test files of the importers are not affected. By default,
+// changes propagate through every import.
+func SetPropagateThroughTestImports(propagateThroughTestImports bool) Option {
+	return func(g *GTA) error {
+		g.propagateThroughTestImports = propagateThroughTestImports
+		return nil
+	}
+}
+
+// SetIncludeChangedLines sets whether ChangedPackages should set the
+// ChangedLines field of each package to the number of lines that were added
+// or deleted in the package's directory. Counting the lines requires an
+// additional git invocation. It has no effect unless the differ implements
+// LineDiffer.
+func SetIncludeChangedLines(includeChangedLines bool) Option {
+	return func(g *GTA) error {
+		g.includeChangedLines = includeChangedLines
+		return nil
+	}
+}
+
+// SetOverlay sets a map of absolute file paths to their contents that replace
+// the files' contents on disk, or add files that do not exist on disk, when
+// loading packages with the default packager to build the dependency graph.
+// It has no effect when the packager is set with SetPackager.
+func SetOverlay(overlay map[string][]byte) Option {
+	return func(g *GTA) error {
+		g.overlay = overlay
+		return nil
+	}
+}
+
+// SetUseGoSum sets whether to mark the packages of modules whose checksums
+// changed in go.sum files as changed (e.g. after a dependency was retagged
+// or go mod tidy was run without changing go.mod). It has no effect unless
+// the differ implements GoSumDiffer.
+func SetUseGoSum(useGoSum bool) Option {
+	return func(g *GTA) error {
+		g.useGoSum = useGoSum
+		return nil
+	}
+}
+
+// SetDetectMoves sets whether ChangedPackages should pair deleted packages
+// with added packages that have the same exported API in Packages.Moves. It
+// has no effect unless the differ provides the contents of files before they
+// were changed, e.g. a differ created by NewGitDiffer; the BaseFile method of
+// the other differs returns ErrNoBase.
+func SetDetectMoves(detectMoves bool) Option {
+	return func(g *GTA) error {
+		g.detectMoves = detectMoves
+		return nil
+	}
+}
+
+// SetAPIChangeDetection sets whether to compare the exported API of each
+// changed package before and after the change. When the exported API did not
+// change, only the package itself is marked; its dependents are not. Note
+// that a change to the behavior of a package without a change to its exported
+// API is not propagated when this is enabled. The exported API is compared by
+// its declarations: an exported declaration is changed when it or one of the
+// unexported declarations that it refers to is changed. Changes to init
+// functions are always propagated, because their side effects are observable
+// by the package's dependents. It has no effect unless the differ provides
+// the contents of files before they were changed, e.g. a differ created by
+// NewGitDiffer.
+func SetAPIChangeDetection(apiChangeDetection bool) Option {
+	return func(g *GTA) error {
+		g.apiChangeDetection = apiChangeDetection
+		return nil
+	}
+}
+
+// SetGoModChangesWholeModule sets whether a change to a go.mod file marks
+// every package of its module as changed. Directories that are ignored by the
+// go tool and nested modules are not considered part of the module.
+func SetGoModChangesWholeModule(goModChangesWholeModule bool) Option {
+	return func(g *GTA) error {
+		g.goModChangesWholeModule = goModChangesWholeModule
+		return nil
+	}
+}
+
+// SetGodebugChangesMains sets whether a change to the godebug settings of a
+// go.mod file marks the main packages of its module as changed, because the
+// settings change the programs' default GODEBUG settings. The main packages
+// are also marked when the go.mod is new or its contents before the change are
+// not known. It has no effect unless the Differ is a BaseDiffer.
+func SetGodebugChangesMains(godebugChangesMains bool) Option {
+	return func(g *GTA) error {
+		g.godebugChangesMains = godebugChangesMains
+		return nil
+	}
+}
+
+// SetMaxPackageDepth sets the maximum number of elements of the import paths
+// of the packages to include (e.g. 3 includes github.com/foo/bar, but not
+// github.com/foo/bar/baz). Deeper packages are excluded from the results the
+// same way as packages without one of the prefixes set with SetPrefixes are.
+// It is a heuristic meant to exclude deeply nested generated or vendored
+// packages; the depth of a package does not say anything about whether it is
+// generated. A depth of 0 does not exclude any packages.
+func SetMaxPackageDepth(n int) Option {
+	return func(g *GTA) error {
+		if n < 0 {
+			return fmt.Errorf("max package depth must not be negative, got %d", n)
+		}
+		g.maxPackageDepth = n
+		return nil
+	}
+}
+
+// SetGraphCache sets the path of a file in which the dependency graph of the
+// default packager is cached between runs. The cache is used when it was
+// written for the same base revision, changes, go.mod and go.sum files, build
+// tags, overlay and go version; otherwise the packages are loaded and the
+// cache is rewritten. The cache is neither read nor written when a go.mod or
+// go.sum file was changed, when the differ does not implement
+// BaseRevisionDiffer or when the cache key cannot be computed, e.g. because
+// git fails. The key is computed when the changed packages are first
+// determined rather than by New. It has no effect when the packager is set with SetPackager. It is a
+// shorthand for SetCache with a Cache that only holds the most recent graph
+// in the file; an empty path disables the cache.
+func SetGraphCache(path string) Option {
+	return func(g *GTA) error {
+		if path == "" {
+			g.cache = nil
+			return nil
+		}
+		g.cache = &singleFileCache{fn: path}
+		return nil
+	}
+}
+
+// SetCache sets the Cache in which the dependency graph of the default
+// packager is cached between runs, under the same conditions as with
+// SetGraphCache. Unlike the file set with SetGraphCache, c may hold the graphs
+// of several revisions at once (e.g. a Cache created with NewFileCache, or
+// one that is shared between machines).
+func SetCache(c Cache) Option {
+	return func(g *GTA) error {
+		g.cache = c
+		return nil
+	}
+}
+
+// SetMaxDepth sets the maximum number of edges in the dependent graph between
+// a changed package and the dependents that are marked. A depth of 0 only
+// marks the changed packages, a depth of 1 also marks their direct
+// dependents, and so on. A negative depth, which is the default, marks all
+// dependents. Limiting the depth is deliberately unsound: the dependents that
+// are not marked may still be affected by the changes. It trades correctness
+// for speed when testing all of the dependents is too expensive.
+func SetMaxDepth(n int) Option {
+	return func(g *GTA) error {
+		g.maxDepth = n
+		return nil
+	}
+}
+
+// SetUnifyTestAndProd sets whether a package and its external test package
+// (i.e. the package of its _test.go files whose name has a _test suffix) are
+// always reported as the single import path of the package in the results.
+// External test packages whose directory is not known (e.g. because they were
+// deleted) are reported as they are.
+func SetUnifyTestAndProd(unifyTestAndProd bool) Option {
+	return func(g *GTA) error {
+		g.unifyTestAndProd = unifyTestAndProd
+		return nil
+	}
+}
+
+// SetIncludeTestOnlyChanges sets whether ChangedPackages should include the
+// packages that are only affected by the changes through _test.go files in
+// Packages.TestOnlyChanges.
+func SetIncludeTestOnlyChanges(includeTestOnlyChanges bool) Option {
+	return func(g *GTA) error {
+		g.includeTestOnlyChanges = includeTestOnlyChanges
+		return nil
+	}
+}
+
+// SetIncludeReasons sets whether ChangedPackages should include the reasons
+// the packages in Packages.Changes were changed in Packages.Reasons.
+func SetIncludeReasons(includeReasons bool) Option {
+	return func(g *GTA) error {
+		g.includeReasons = includeReasons
+		return nil
+	}
+}
+
+// SetStrict sets whether errors of packages that are loaded to build the
+// dependency graph (e.g. files that cannot be parsed) are returned. When they
+// are not, they are logged and the dependency graph is built without the
+// imports that could not be loaded, so that their dependents may not be
+// marked.
+func SetStrict(strict bool) Option {
+	return func(g *GTA) error {
+		g.strict = strict
+		return nil
+	}
+}
+
+// SetMaxChangedPackages sets the maximum number of packages in the AllChanges
+// of the packages returned by ChangedPackages. When more packages changed
+// (e.g. because a package that most packages import was changed), a
+// *TooManyChangesError is returned instead, so that callers can fail fast
+// rather than testing everything. A maximum of 0 or less does not limit the
+// number of changed packages.
+func SetMaxChangedPackages(n int) Option {
+	return func(g *GTA) error {
+		g.maxChangedPackages = n
+		return nil
+	}
+}
+
+// SetStripPrefix sets an import path prefix that is removed from the import
+// paths of the packages returned by ChangedPackages, so that, for example,
+// github.com/acme/monorepo/services/x is reported as services/x with a prefix
+// of github.com/acme/monorepo. The package whose import path is the prefix is
+// reported as ".", and import paths that are not below the prefix are not
+// changed. The directories of the packages are not changed either.
+func SetStripPrefix(prefix string) Option {
+	return func(g *GTA) error {
+		g.stripPrefix = prefix
+		return nil
+	}
+}
+
+// SetValidateInternal sets whether the dependency graph is checked for imports
+// of internal packages by packages outside of the trees rooted at the parents
+// of their internal directories. The go tool does not allow such imports, so
+// they indicate a stale or wrongly built graph, and ErrInternalImport is
+// returned when one is found.
+func SetValidateInternal(validateInternal bool) Option {
+	return func(g *GTA) error {
+		g.validateInternal = validateInternal
+		return nil
+	}
+}
+
+// SetIncludeUnbuildable sets whether packages whose changed Go files cannot be
+// parsed are included in the changes. They are skipped by default, because
+// they cannot be built, but including them lets the changes be used to find
+// the breakage.
+func SetIncludeUnbuildable(includeUnbuildable bool) Option {
+	return func(g *GTA) error {
+		g.includeUnbuildable = includeUnbuildable
+		return nil
+	}
+}
+
+// SetLogger sets a logger for diagnostics about how the changed packages are
+// determined: the directories reported by the differ, the changed packages,
+// the packages that are excluded (e.g. by the prefixes) and the packages that
+// could not be loaded. Most records are logged at the debug level, and the
+// packages that could not be loaded at the warn level. Nothing is logged by
+// default.
+func SetLogger(logger *slog.Logger) Option {
+	return func(g *GTA) error {
+		g.logger = logger
+		return nil
+	}
+}
+
+// SetModules sets the directories of the modules (e.g. some of the modules of a
+// workspace) whose packages are loaded to build the dependency graph, instead
+// of all packages. The directories are also the roots unless the roots are set
+// with SetRoots. Unlike the prefixes set with SetPrefixes, which only filter
+// the results, this limits the packages that are loaded, so the dependents in
+// other modules are not marked. It has no effect on the packages that are
+// loaded when the packager is set with SetPackager.
+func SetModules(dirs ...string) Option {
+	return func(g *GTA) error {
+		g.modules = nil
+		for _, dir := range dirs {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return fmt.Errorf("could not get absolute path of module %q: %w", dir, err)
+			}
+			g.modules = append(g.modules, abs)
+		}
 		return nil
 	}
 }
@@ -7,13 +7,18 @@ in the LICENSE file.
 package gta
 
 import (
+	"errors"
 	"fmt"
 	"go/build"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -22,9 +27,27 @@ type Package struct {
 
 	// Dir the absolute path of the directory containing the package.
 	// bug(bc): this is currently unreliable and in GOPATH mode only identifies
-	// the src directory for the GOPATH that hosts the package.  Currently, the
-	// only guarantee is that Dir will not be empty when the package exists.
+	// the src directory for the GOPATH that hosts the package. It is the import
+	// path of the package when the package was found by its import path and
+	// has no Go files. Currently, the only guarantee is that Dir will not be
+	// empty when the package exists.
 	Dir string
+
+	// TestHelper is true when the package is only imported by tests.
+	TestHelper bool
+
+	// IsCommand is true when the package is a command (i.e. its name is main),
+	// so its binary must be rebuilt when it changes.
+	IsCommand bool
+
+	// Module is the path of the module that contains the package. It is empty
+	// in GOPATH mode or when the module is not known.
+	Module string
+
+	// ChangedLines is the number of lines that were added or deleted in the
+	// files of the package's directory. It is only set when GTA is configured
+	// with SetIncludeChangedLines.
+	ChangedLines int
 }
 
 // graphError is a collection of errors from attempting to build the
@@ -38,6 +61,16 @@ func (g *graphError) Error() string {
 	return fmt.Sprintf("errors while generating import graph: %v", g.Errors)
 }
 
+// add adds err to the errors of the package identified by importPath unless
+// the package already has it (e.g. because it was reported for both the package
+// and its test variant).
+func (g *graphError) add(importPath string, err error) {
+	if errors.Is(g.Errors[importPath], err) {
+		return
+	}
+	g.Errors[importPath] = errors.Join(g.Errors[importPath], err)
+}
+
 // Packager interface defines a set of means to access golang build Package information.
 type Packager interface {
 	// Get a go package from directory. Should return a *build.NoGoError value
@@ -55,37 +88,127 @@ type Packager interface {
 	EmbeddedBy(string) []string
 }
 
+// importPathLister is implemented by the Packagers that can list the import
+// paths of all of the packages they loaded, including the packages that are
+// not in the dependency graph because they neither import nor are imported by
+// other packages.
+type importPathLister interface {
+	importPaths() []string
+}
+
 func NewPackager(patterns, tags []string) Packager {
-	build.Default.BuildTags = tags
-	return newPackager(newLoadConfig(tags), build.Default, patterns)
+	return newOverlayPackager(patterns, tags, nil, nil, nil)
+}
+
+// newOverlayPackager returns a Packager like NewPackager, but the packages are
+// loaded with buildFlags passed to the go command, with env, a list of
+// key=value pairs, added to its environment and with overlay replacing the
+// contents of files. See packages.Config.Overlay.
+func newOverlayPackager(patterns, tags, buildFlags, env []string, overlay map[string][]byte) Packager {
+	tags, buildFlags = mergeTagsFlag(tags, buildFlags)
+	cfg := newLoadConfig(tags, buildFlags...)
+	if len(env) > 0 {
+		cfg.Env = append(os.Environ(), env...)
+	}
+	cfg.Overlay = overlay
+	return newPackager(cfg, newBuildContext(tags, env), patterns)
+}
+
+// mergeTagsFlag returns tags with the tags of the -tags flags in buildFlags
+// appended, and buildFlags without the -tags flags, so that a -tags flag in
+// buildFlags adds to tags instead of replacing them.
+func mergeTagsFlag(tags, buildFlags []string) ([]string, []string) {
+	merged := append([]string(nil), tags...)
+	var rest []string
+	for i := 0; i < len(buildFlags); i++ {
+		flag := buildFlags[i]
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(flag, "-"), "-"), "=")
+		if !strings.HasPrefix(flag, "-") || name != "tags" {
+			rest = append(rest, flag)
+			continue
+		}
+
+		// the value of the flag may be the next argument (e.g. -tags foo).
+		if !hasValue && i+1 < len(buildFlags) {
+			i++
+			value = buildFlags[i]
+		}
+		merged = append(merged, splitTags([]string{value})...)
+	}
+	return merged, rest
+}
+
+// newBuildContext returns a copy of build.Default with tags as its build tags.
+// When env, a list of key=value pairs, sets CGO_ENABLED, cgo is enabled or
+// disabled accordingly. build.Default itself is never modified so that
+// packagers with different tags can be used at the same time.
+func newBuildContext(tags, env []string) build.Context {
+	ctx := build.Default
+	ctx.BuildTags = tags
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "CGO_ENABLED="); ok {
+			ctx.CgoEnabled = v == "1"
+		}
+	}
+	return ctx
 }
 
 func newPackager(cfg *packages.Config, ctx build.Context, patterns []string) Packager {
-	moduleNamesByDir, forward, reverse, packagesByEmbedFile, err := dependencyGraph(cfg, patterns)
+	moduleNamesByDir, forward, reverse, packagesByEmbedFile, embedPatterns, dirsByPackage, commands, err := dependencyGraph(cfg, patterns)
+
+	// the graph is built even when some packages could not be loaded, and the
+	// error is returned with it.
+	var loadErr error
+	var ge *graphError
+	if errors.As(err, &ge) {
+		loadErr, err = err, nil
+	}
+
 	return &packageContext{
 		ctx:                 &ctx,
 		err:                 err,
-		packages:            make(map[string]struct{}),
+		loadErr:             loadErr,
 		forward:             forward,
 		reverse:             reverse,
 		modulesNamesByDir:   moduleNamesByDir,
 		packagesByEmbedFile: packagesByEmbedFile,
+		embedPatterns:       embedPatterns,
+		dirsByPackage:       dirsByPackage,
+		packagesByDir:       packagesByDir(dirsByPackage),
+		commands:            commands,
 	}
 }
 
+// packagesByDir returns a map of the directories in dirsByPackage to the import
+// paths of the packages in them. When packages with different import paths
+// were loaded from the same directory, the least import path is used so that
+// the result does not depend on the order of the map.
+func packagesByDir(dirsByPackage map[string]string) map[string]string {
+	m := make(map[string]string, len(dirsByPackage))
+	for importPath, dir := range dirsByPackage {
+		if existing, ok := m[dir]; ok && existing < importPath {
+			continue
+		}
+		m[dir] = importPath
+	}
+	return m
+}
+
 // newLoadConfig returns a *packages.Config suitable for use by packages.Load.
-// The constructor here is mostly useful for tests.
-func newLoadConfig(tags []string) *packages.Config {
+// buildFlags are passed to the go command after the -tags flag. The
+// constructor here is mostly useful for tests.
+func newLoadConfig(tags []string, buildFlags ...string) *packages.Config {
 	return &packages.Config{
 		Mode: packages.NeedName |
 			packages.NeedFiles |
 			packages.NeedEmbedFiles |
+			packages.NeedEmbedPatterns |
 			packages.NeedImports |
 			packages.NeedDeps |
 			packages.NeedModule,
-		BuildFlags: []string{
+		BuildFlags: append([]string{
 			fmt.Sprintf(`-tags=%s`, strings.Join(tags, ",")),
-		},
+		}, buildFlags...),
 		Tests: true,
 	}
 }
@@ -94,28 +217,98 @@ func newLoadConfig(tags []string) *packages.Config {
 type packageContext struct {
 	ctx *build.Context
 	err error
-	// packages is a set of import paths of packages that have been imported.
-	packages map[string]struct{}
+	// loadErr is a *graphError that describes the packages that could not be
+	// loaded completely. It is returned by DependentGraph with the graph.
+	loadErr error
 	// forward is a dependency graph (import path -> (dependency import path -> struct{}{}))
 	forward map[string]map[string]struct{}
-	// reverse is a reverse dependency graph (import path -> (dependent import path -> struct{}{}))
-	reverse map[string]map[string]struct{}
+	// reverse is a reverse dependency graph (import path -> (dependent import
+	// path -> true when the dependent imports the package from non-test files))
+	reverse map[string]map[string]bool
 	// modulesNamesByDir is a map of directories to import paths. absolute path
 	// directory -> import path/module name
 	modulesNamesByDir map[string]string
 	// packagesByEmbedFile is a map of absolute file paths to packages that
 	// depend on those files.
 	packagesByEmbedFile map[string][]string
+	// embedPatterns is a map of import paths to the //go:embed patterns of the
+	// packages, which are relative to the packages' directories. They match
+	// files that did not exist when the packages were loaded.
+	embedPatterns map[string][]string
+	// dirsByPackage is a map of import paths to the absolute paths of the
+	// packages' directories.
+	dirsByPackage map[string]string
+	// packagesByDir is a map of the absolute paths of the directories of the
+	// loaded packages to the packages' import paths.
+	packagesByDir map[string]string
+	// commands is the set of import paths of packages whose name is main.
+	commands map[string]struct{}
 }
 
-// EmbeddedBy returns the import paths of packages that embed the file at fn.
+// EmbeddedBy returns the import paths of packages that embed the file at fn,
+// either because fn was embedded when the packages were loaded or because fn
+// matches one of their //go:embed patterns (e.g. fn was added later).
 func (p *packageContext) EmbeddedBy(fn string) []string {
 	// return a copy of the slice value so that the source cannot be modified by callers.
 	src := p.packagesByEmbedFile[fn]
 
 	sl := make([]string, 0, len(src))
 	sl = append(sl, src...)
-	return sl
+
+	seen := make(map[string]struct{}, len(sl))
+	for _, importPath := range sl {
+		seen[importPath] = struct{}{}
+	}
+
+	var matched []string
+	for importPath, patterns := range p.embedPatterns {
+		if _, ok := seen[importPath]; ok {
+			continue
+		}
+
+		rel, err := filepath.Rel(p.dirsByPackage[importPath], fn)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		for _, pattern := range patterns {
+			if embedPatternMatches(pattern, filepath.ToSlash(rel)) {
+				matched = append(matched, importPath)
+				break
+			}
+		}
+	}
+	sort.Strings(matched)
+
+	return append(sl, matched...)
+}
+
+// embedPatternMatches returns true when the //go:embed pattern embeds the file
+// at rel, a slash separated path relative to the directory of the package. Like
+// the go command, a pattern that matches a directory embeds the files below it,
+// except for the files whose names begin with . or _ unless the pattern has
+// the all: prefix.
+func embedPatternMatches(pattern, rel string) bool {
+	all := strings.HasPrefix(pattern, "all:")
+	pattern = strings.TrimPrefix(pattern, "all:")
+
+	for dir := rel; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if ok, _ := path.Match(pattern, dir); !ok {
+			continue
+		}
+
+		if dir == rel || all {
+			return true
+		}
+
+		for _, elem := range strings.Split(strings.TrimPrefix(rel, dir+"/"), "/") {
+			if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 // PackageFromDir returns a build package from a directory.
@@ -124,9 +317,7 @@ func (p *packageContext) PackageFromDir(dir string) (*Package, error) {
 	// (e.g. build.NoGoError) will be returned.
 	pkg, err := p.ctx.ImportDir(dir, 0)
 	pkg2 := packageFrom(pkg)
-	resolveLocal(pkg2, dir, p.modulesNamesByDir)
-	pkg2.ImportPath = stripVendor(pkg2.ImportPath)
-	p.packages[pkg2.ImportPath] = struct{}{}
+	p.resolveImportPath(pkg2, dir)
 	return pkg2, err
 }
 
@@ -134,30 +325,82 @@ func (p *packageContext) PackageFromDir(dir string) (*Package, error) {
 func (p *packageContext) PackageFromEmptyDir(dir string) (*Package, error) {
 	pkg, err := p.ctx.ImportDir(dir, build.FindOnly)
 	pkg2 := packageFrom(pkg)
-	resolveLocal(pkg2, dir, p.modulesNamesByDir)
-	pkg2.ImportPath = stripVendor(pkg2.ImportPath)
-	p.packages[pkg2.ImportPath] = struct{}{}
+	p.resolveImportPath(pkg2, dir)
 	return pkg2, err
 }
 
-// PackageFromImport returns a build package from an import path.
+// resolveImportPath sets the import path and the module of pkg, the package in
+// dir. The import path of the package that was loaded from dir is used when
+// there is one, because the import path cannot always be derived from the
+// directory (e.g. when a module is replaced by a directory of another module).
+func (p *packageContext) resolveImportPath(pkg *Package, dir string) {
+	if importPath, ok := p.packagesByDir[dir]; ok {
+		pkg.ImportPath = importPath
+	} else {
+		resolveLocal(pkg, dir, p.modulesNamesByDir)
+		pkg.ImportPath = stripVendor(pkg.ImportPath)
+	}
+	pkg.Module = moduleOf(dir, p.modulesNamesByDir)
+}
+
+// PackageFromImport returns a build package from an import path. The Dir of
+// the package is the directory of its Go files, or its import path when it has
+// none.
 func (p *packageContext) PackageFromImport(importPath string) (*Package, error) {
 	importPath = stripVendor(importPath)
 	if _, ok := p.forward[importPath]; !ok {
 		return nil, fmt.Errorf("%s not found", importPath)
 	}
 
+	dir, ok := p.dirsByPackage[importPath]
+	if !ok {
+		// Dir must not be empty when the package exists.
+		dir = importPath
+	}
+
+	_, isCommand := p.commands[importPath]
 	pkg := &Package{
 		ImportPath: importPath,
-		// TODO(bc): use the correct value for Dir
-		Dir: importPath,
+		Dir:        dir,
+		TestHelper: p.isTestHelper(importPath),
+		IsCommand:  isCommand,
+	}
+	if ok {
+		pkg.Module = moduleOf(dir, p.modulesNamesByDir)
 	}
 
-	p.packages[pkg.ImportPath] = struct{}{}
 	return pkg, nil
 }
 
+// importPaths returns the import paths of the loaded packages.
+func (p *packageContext) importPaths() []string {
+	importPaths := make([]string, 0, len(p.forward))
+	for importPath := range p.forward {
+		importPaths = append(importPaths, importPath)
+	}
+	return importPaths
+}
+
+// isTestHelper returns true when the package identified by importPath has
+// dependents and all of them import it from _test.go files only.
+func (p *packageContext) isTestHelper(importPath string) bool {
+	dependents := p.reverse[importPath]
+	if len(dependents) == 0 {
+		return false
+	}
+
+	for _, nonTest := range dependents {
+		if nonTest {
+			return false
+		}
+	}
+	return true
+}
+
 // DependentGraph returns a dependent graph based on the current imported packages.
+// The values of the inner maps of the graph are false when the dependent only
+// imports the package from _test.go files. When some packages could not be
+// loaded, the graph is returned with a *graphError.
 func (p *packageContext) DependentGraph() (*Graph, error) {
 	if p.err != nil {
 		return nil, p.err
@@ -166,19 +409,20 @@ func (p *packageContext) DependentGraph() (*Graph, error) {
 	graph := make(map[string]map[string]bool)
 	for k := range p.reverse {
 		inner := make(map[string]bool)
-		for k2 := range p.reverse[k] {
-			inner[k2] = true
+		for k2, v := range p.reverse[k] {
+			inner[k2] = v
 		}
 		graph[k] = inner
 	}
 
-	return &Graph{graph: graph}, nil
+	return &Graph{graph: graph}, p.loadErr
 }
 
 func packageFrom(pkg *build.Package) *Package {
 	return &Package{
 		ImportPath: pkg.ImportPath,
 		Dir:        pkg.SrcRoot,
+		IsCommand:  pkg.Name == "main",
 	}
 }
 
@@ -189,46 +433,53 @@ func resolveLocal(pkg *Package, dir string, modulesByDir map[string]string) {
 		return
 	}
 
-	importPath := pkg.ImportPath
+	// there may be nested modules; the module whose directory is the longest
+	// prefix of dir contains it.
+	moduleDir := moduleDirOf(dir, modulesByDir)
+	if moduleDir == "" {
+		return
+	}
 
-	var mruPrefix string
-	for k, v := range modulesByDir {
-		// check for an exact match
-		if dir == k {
-			importPath = v
-			break
-		}
+	vendorPathSegment := "/vendor/"
+	candidateImportPath := strings.ReplaceAll(strings.TrimPrefix(dir, moduleDir), string(filepath.Separator), "/")
 
-		// there may be nested modules; make sure the directory being checked is
-		// within the directory for current entry and deeper than the most recently
-		// matched prefix.
-		if !strings.HasPrefix(dir, k) || len(mruPrefix) > len(k) {
-			continue
-		}
-
-		mruPrefix = k
+	// vendored packages within modules should not have a `vendor` prefix and
+	// will not have one in the value returned from packages.Load, so strip
+	// it out.
+	if strings.HasPrefix(candidateImportPath, vendorPathSegment) {
+		pkg.ImportPath = strings.TrimPrefix(candidateImportPath, vendorPathSegment)
+	} else {
+		pkg.ImportPath = path.Join(modulesByDir[moduleDir], candidateImportPath)
+	}
+}
 
-		vendorPathSegment := "/vendor/"
-		candidateImportPath := strings.ReplaceAll(strings.TrimPrefix(dir, k), string(filepath.Separator), "/")
+// moduleOf returns the path of the module whose directory in modulesByDir is
+// the longest prefix of dir. It returns an empty string when no module
+// contains dir.
+func moduleOf(dir string, modulesByDir map[string]string) string {
+	return modulesByDir[moduleDirOf(dir, modulesByDir)]
+}
 
-		// vendored packages within modules should not have a `vendor` prefix and
-		// will not have one in the value returned from packages.Load, so strip
-		// it out.
-		if strings.HasPrefix(candidateImportPath, vendorPathSegment) {
-			importPath = strings.TrimPrefix(candidateImportPath, vendorPathSegment)
-		} else {
-			importPath = path.Join(v, candidateImportPath)
+// moduleDirOf returns the longest directory in modulesByDir that is dir or
+// contains dir. It returns an empty string when no module contains dir.
+func moduleDirOf(dir string, modulesByDir map[string]string) string {
+	var moduleDir string
+	for k := range modulesByDir {
+		if dir != k && !strings.HasPrefix(dir, k+string(filepath.Separator)) {
+			continue
+		}
+		if len(k) > len(moduleDir) {
+			moduleDir = k
 		}
 	}
-
-	pkg.ImportPath = importPath
+	return moduleDir
 }
 
 // dependencyGraph constructs a map of directories to import paths when in
 // module aware mode and flattened forward and reverse transitive dependency
 // graphs. When in GOPATH mode the map of directories to import paths will be
 // empty.
-func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir map[string]string, forward map[string]map[string]struct{}, reverse map[string]map[string]struct{}, packagesByEmbedFile map[string][]string, err error) {
+func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir map[string]string, forward map[string]map[string]struct{}, reverse map[string]map[string]bool, packagesByEmbedFile map[string][]string, embedPatterns map[string][]string, dirsByPackage map[string]string, commands map[string]struct{}, err error) {
 	loadAllPackages := true
 	for i, pat := range patterns {
 		if strings.HasPrefix(pat, "file=") {
@@ -246,17 +497,44 @@ func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir
 
 	if loadAllPackages {
 		patterns = []string{"..."}
+
+		// load the packages of all of the modules of a workspace together so
+		// that the dependents of a package in one module include the packages
+		// of the other modules that import it.
+		wsPatterns, err := workspacePatterns(cfg)
+		if err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, err
+		}
+		if len(wsPatterns) > 0 {
+			patterns = wsPatterns
+		}
+	}
+
+	// a cycle of replace directives is reported before the packages are loaded,
+	// because the go command's errors do not explain it.
+	gomod, err := goCommandOutput(cfg, "env", "GOMOD")
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("could not get go.mod file: %w", err)
+	}
+	if gomod != "" && gomod != os.DevNull {
+		if err := checkReplaceCycle(gomod); err != nil {
+			return nil, nil, nil, nil, nil, nil, nil, err
+		}
 	}
 
 	loadedPackages, err := packages.Load(cfg, patterns...)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("loading packages: %w", err)
+		return nil, nil, nil, nil, nil, nil, nil, fmt.Errorf("loading packages: %w", err)
 	}
 
 	moduleNamesByDir = make(map[string]string)
 	forward = make(map[string]map[string]struct{})
-	reverse = make(map[string]map[string]struct{})
+	reverse = make(map[string]map[string]bool)
 	packagesByEmbedFile = make(map[string][]string)
+	embedPatterns = make(map[string][]string)
+	dirsByPackage = make(map[string]string)
+	commands = make(map[string]struct{})
+	ge := &graphError{Errors: make(map[string]error)}
 
 	seen := make(map[string]struct{})
 	var addPackage func(pkg *packages.Package)
@@ -269,8 +547,26 @@ func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir
 			moduleNamesByDir[pkg.Module.Dir] = pkg.Module.Path
 		}
 
+		// a module that is replaced by a directory is in that directory, which
+		// may be inside of the directory of a main module.
+		if pkg.Module != nil && pkg.Module.Replace != nil && pkg.Module.Dir != "" && modfile.IsDirectoryPath(pkg.Module.Replace.Path) {
+			moduleNamesByDir[pkg.Module.Dir] = pkg.Module.Path
+		}
+
 		seen[pkg.ID] = struct{}{}
 
+		// normalize the import path so that test packages will be flattened into
+		// the package path of the primary package.
+		pkgPath := normalizeImportPath(pkg)
+
+		// Record the embedded files before deciding whether to ignore the package
+		// so that packages that could only be partially loaded (e.g. because of
+		// errors in their Go files) still report the files they embed.
+		for _, f := range pkg.EmbedFiles {
+			sl := packagesByEmbedFile[f]
+			packagesByEmbedFile[f] = append(sl, pkgPath)
+		}
+
 		// Ignore packages that do not have any Go files that satisfy the build
 		// constraints.
 		if len(pkg.GoFiles) == 0 {
@@ -282,14 +578,32 @@ func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir
 			return
 		}
 
-		// normalize the import path so that test packages will be flattened into
-		// the package path of the primary package.
-		pkgPath := normalizeImportPath(pkg)
+		// the imports of a package with errors may be incomplete (e.g. when one
+		// of its files could not be parsed).
+		for _, err := range pkg.Errors {
+			ge.add(pkgPath, err)
+		}
 
-		for _, f := range pkg.EmbedFiles {
-			sl := packagesByEmbedFile[f]
-			packagesByEmbedFile[f] = append(sl, pkgPath)
+		dirsByPackage[pkgPath] = filepath.Dir(pkg.GoFiles[0])
+		// packages.Load joins the embed patterns with the package's directory,
+		// so they are made relative again to be matched like the go command
+		// matches them.
+		for _, pattern := range pkg.EmbedPatterns {
+			rel, err := filepath.Rel(dirsByPackage[pkgPath], pattern)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if !slices.Contains(embedPatterns[pkgPath], rel) {
+				embedPatterns[pkgPath] = append(embedPatterns[pkgPath], rel)
+			}
 		}
+		if pkg.Name == "main" {
+			commands[pkgPath] = struct{}{}
+		}
+
+		// test is true when the imports of pkg may come from _test.go files.
+		test := isTestVariant(pkg)
 
 		if _, ok := forward[pkgPath]; !ok {
 			forward[pkgPath] = make(map[string]struct{})
@@ -311,10 +625,10 @@ func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir
 			}
 
 			if _, ok := reverse[importedPath]; !ok {
-				reverse[importedPath] = make(map[string]struct{})
+				reverse[importedPath] = make(map[string]bool)
 			}
 			revm := reverse[importedPath]
-			revm[pkgPath] = struct{}{}
+			revm[pkgPath] = revm[pkgPath] || !test
 		}
 	}
 
@@ -322,7 +636,54 @@ func dependencyGraph(cfg *packages.Config, patterns []string) (moduleNamesByDir
 		addPackage(pkg)
 	}
 
-	return moduleNamesByDir, forward, reverse, packagesByEmbedFile, nil
+	if len(ge.Errors) > 0 {
+		return moduleNamesByDir, forward, reverse, packagesByEmbedFile, embedPatterns, dirsByPackage, commands, ge
+	}
+
+	return moduleNamesByDir, forward, reverse, packagesByEmbedFile, embedPatterns, dirsByPackage, commands, nil
+}
+
+// workspacePatterns returns patterns that match the packages of each module of
+// the go.work workspace that is used by cfg. It returns nil when no workspace
+// is used. Though ... matches the packages of the workspace's modules too, it
+// matches the packages of the standard library and of all dependencies as
+// well, and ./... only matches the packages of the module in the current
+// directory.
+func workspacePatterns(cfg *packages.Config) ([]string, error) {
+	gowork, err := goCommandOutput(cfg, "env", "GOWORK")
+	if err != nil {
+		return nil, fmt.Errorf("could not get go.work file: %w", err)
+	}
+	if gowork == "" || gowork == "off" {
+		return nil, nil
+	}
+
+	dirs, err := goCommandOutput(cfg, "list", "-m", "-f", "{{.Dir}}")
+	if err != nil {
+		return nil, fmt.Errorf("could not get workspace modules: %w", err)
+	}
+
+	var patterns []string
+	for _, dir := range strings.Split(dirs, "\n") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			patterns = append(patterns, filepath.Join(dir, "..."))
+		}
+	}
+	return patterns, nil
+}
+
+// goCommandOutput runs the go command with args in the directory and the
+// environment of cfg and returns its trimmed output.
+func goCommandOutput(cfg *packages.Config, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = cfg.Env
+
+	b, err := execWithStderr(cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
 }
 
 // normalizeImportPath will return the import path of pkg. The import path may
@@ -350,6 +711,17 @@ func normalizeImportPath(pkg *packages.Package) string {
 	return importPath
 }
 
+// isTestVariant returns true when pkg is a package that is compiled for tests
+// (i.e. when it includes _test.go files).
+func isTestVariant(pkg *packages.Package) bool {
+	for _, f := range pkg.GoFiles {
+		if strings.HasSuffix(f, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
 func stripVendor(importPath string) string {
 	if os.Getenv("GO111MODULE") == "off" {
 		return importPath
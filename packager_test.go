@@ -1,6 +1,14 @@
 package gta
 
-import "testing"
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
 
 func TestPackageContextImplementsPackager(t *testing.T) {
 	var sut interface{} = new(packageContext)
@@ -8,3 +16,207 @@ func TestPackageContextImplementsPackager(t *testing.T) {
 		t.Error("expected to implement Packager")
 	}
 }
+
+func TestNewPackager_Tags(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	// the package in dir/tagged is a command only when the tag foo is set.
+	files := map[string]string{
+		"go.mod":           "module gta.test\n\ngo 1.18\n",
+		"tagged/main.go":   "//go:build foo\n\npackage main\n\nfunc main() {}\n",
+		"tagged/tagged.go": "//go:build !foo\n\npackage tagged\n",
+	}
+	for fn, contents := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	defaultTags := build.Default.BuildTags
+
+	tags := [][]string{{"foo"}, {"bar"}}
+	pkgrs := make([]Packager, len(tags))
+
+	var wg sync.WaitGroup
+	for i := range tags {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pkgrs[i] = NewPackager(nil, tags[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var got []bool
+	for _, pkgr := range pkgrs {
+		pkg, err := pkgr.PackageFromDir(filepath.Join(dir, "tagged"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, pkg.IsCommand)
+	}
+
+	if diff := cmp.Diff([]bool{true, false}, got); diff != "" {
+		t.Errorf("IsCommand (-want, +got)\n%s", diff)
+	}
+
+	if diff := cmp.Diff(defaultTags, build.Default.BuildTags); diff != "" {
+		t.Errorf("build.Default.BuildTags (-want, +got)\n%s", diff)
+	}
+}
+
+func TestNewPackager_PackageFromImport(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	files := map[string]string{
+		"go.mod":     "module gta.test\n\ngo 1.18\n",
+		"foo/foo.go": "package foo\n",
+	}
+	for fn, contents := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, dir)
+	defer popd()
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewPackager(nil, nil).PackageFromImport("gta.test/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Package{
+		ImportPath: "gta.test/foo",
+		Dir:        filepath.Join(dir, "foo"),
+		Module:     "gta.test",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func Test_moduleOf(t *testing.T) {
+	modulesByDir := map[string]string{
+		filepath.FromSlash("/src/a"):        "example.com/a",
+		filepath.FromSlash("/src/a/nested"): "example.com/nested",
+		filepath.FromSlash("/src/ab"):       "example.com/ab",
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{dir: "/src/a", want: "example.com/a"},
+		{dir: "/src/a/b", want: "example.com/a"},
+		{dir: "/src/a/nested/c", want: "example.com/nested"},
+		{dir: "/src/ab/c", want: "example.com/ab"},
+		{dir: "/src/other", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := moduleOf(filepath.FromSlash(tt.dir), modulesByDir); got != tt.want {
+				t.Errorf("moduleOf(%q) = %q; want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_embedPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{pattern: "assets/*", rel: "assets/new.txt", want: true},
+		{pattern: "assets/*.txt", rel: "assets/new.png", want: false},
+		{pattern: "assets", rel: "assets/sub/new.txt", want: true},
+		{pattern: "assets", rel: "assets/.hidden", want: false},
+		{pattern: "assets", rel: "assets/_sub/new.txt", want: false},
+		{pattern: "all:assets", rel: "assets/.hidden", want: true},
+		{pattern: "assets/.hidden", rel: "assets/.hidden", want: true},
+		{pattern: "assets/*", rel: "other/new.txt", want: false},
+		{pattern: "*", rel: "assets/sub/new.txt", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := embedPatternMatches(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("embedPatternMatches(%q, %q) = %v; want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func Test_mergeTagsFlag(t *testing.T) {
+	tests := []struct {
+		desc       string
+		tags       []string
+		buildFlags []string
+		wantTags   []string
+		wantFlags  []string
+	}{
+		{
+			desc:       "no tags flag",
+			tags:       []string{"foo"},
+			buildFlags: []string{"-mod=mod", "-gcflags=-N -l"},
+			wantTags:   []string{"foo"},
+			wantFlags:  []string{"-mod=mod", "-gcflags=-N -l"},
+		},
+		{
+			desc:       "tags flag with value",
+			tags:       []string{"foo"},
+			buildFlags: []string{"-tags=bar,baz", "-mod=mod"},
+			wantTags:   []string{"foo", "bar", "baz"},
+			wantFlags:  []string{"-mod=mod"},
+		},
+		{
+			desc:       "tags flag followed by value",
+			buildFlags: []string{"-mod=mod", "--tags", "bar baz"},
+			wantTags:   []string{"bar", "baz"},
+			wantFlags:  []string{"-mod=mod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			gotTags, gotFlags := mergeTagsFlag(tt.tags, tt.buildFlags)
+			if diff := cmp.Diff(tt.wantTags, gotTags); diff != "" {
+				t.Errorf("tags (-want, +got)\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantFlags, gotFlags); diff != "" {
+				t.Errorf("build flags (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewLoadConfig_BuildFlags(t *testing.T) {
+	cfg := newLoadConfig([]string{"foo", "bar"}, "-mod=mod", "-gcflags=-N")
+
+	want := []string{"-tags=foo,bar", "-mod=mod", "-gcflags=-N"}
+	if diff := cmp.Diff(want, cfg.BuildFlags); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
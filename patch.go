@@ -0,0 +1,215 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NewPatchDiffer returns a Differ that determines differences from a unified
+// diff read from r, such as the output of git diff or git format-patch. The
+// paths in the diff are resolved against the current directory, which should
+// be the root of the repository, and the diff is expected to have been applied
+// to the files on disk. Whether a changed file exists is determined by the
+// diff: deleted files and the old names of renamed files do not exist.
+func NewPatchDiffer(r io.Reader) Differ {
+	files, err := patchFiles(r)
+
+	m := make(map[string]struct{}, len(files))
+	existing := make(map[string]bool, len(files))
+	if err == nil {
+		for fn, ok := range files {
+			var abs string
+			abs, err = filepath.Abs(filepath.FromSlash(fn))
+			if err != nil {
+				break
+			}
+
+			m[abs] = struct{}{}
+			existing[abs] = ok
+		}
+	}
+
+	return &differ{
+		diff: func() (map[string]struct{}, error) {
+			if err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+		exists: func(abs string) bool {
+			return existing[abs]
+		},
+	}
+}
+
+// patchFiles returns the slash separated paths of the files changed by the
+// unified diff read from r. The map values are true when the file exists after
+// the diff is applied.
+func patchFiles(r io.Reader) (map[string]bool, error) {
+	files := make(map[string]bool)
+
+	var (
+		oldName, newName string
+		// sawGitHeader and sawNewName are true after the diff --git and +++
+		// headers of a file were read.
+		sawGitHeader, sawNewName bool
+		// oldLines and newLines are the numbers of lines of the current hunk
+		// that were not read yet.
+		oldLines, newLines int
+	)
+
+	flush := func() {
+		// a --- line without a header that follows it, such as one in a commit
+		// message, does not change a file.
+		if sawGitHeader || sawNewName {
+			if oldName != "" && oldName != newName {
+				files[oldName] = false
+			}
+			if newName != "" {
+				files[newName] = true
+			}
+		}
+		oldName, newName, sawGitHeader, sawNewName = "", "", false, false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// skip the lines of hunks so that removed lines that start with -- are
+		// not mistaken for headers.
+		if oldLines > 0 || newLines > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				newLines--
+			case strings.HasPrefix(line, "-"):
+				oldLines--
+			case strings.HasPrefix(line, `\`):
+				// \ No newline at end of file
+			default:
+				oldLines--
+				newLines--
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			oldName, newName = parseGitDiffHeader(strings.TrimPrefix(line, "diff --git "))
+			sawGitHeader = true
+		case strings.HasPrefix(line, "--- "):
+			// the changes to files in a diff that is not in the git format are
+			// only separated by their headers.
+			if sawNewName {
+				flush()
+			}
+			oldName = patchPath(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			newName = patchPath(strings.TrimPrefix(line, "+++ "), "b/")
+			sawNewName = true
+		case strings.HasPrefix(line, "new file mode "):
+			oldName = ""
+		case strings.HasPrefix(line, "deleted file mode "):
+			newName = ""
+		case strings.HasPrefix(line, "rename from "):
+			oldName = patchPath(strings.TrimPrefix(line, "rename from "), "")
+		case strings.HasPrefix(line, "rename to "):
+			newName = patchPath(strings.TrimPrefix(line, "rename to "), "")
+		case strings.HasPrefix(line, "@@ "):
+			var err error
+			oldLines, newLines, err = parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return files, nil
+}
+
+// parseGitDiffHeader returns the old and new names from the paths of a diff
+// --git line (e.g. a/foo.go b/foo.go).
+func parseGitDiffHeader(s string) (oldName, newName string) {
+	if strings.HasPrefix(s, `"`) {
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", ""
+		}
+		return patchPath(q, "a/"), patchPath(strings.TrimSpace(s[len(q):]), "b/")
+	}
+
+	i := strings.Index(s, " b/")
+	if i < 0 {
+		i = strings.Index(s, ` "b/`)
+	}
+	if i < 0 {
+		return "", ""
+	}
+	return patchPath(s[:i], "a/"), patchPath(s[i+1:], "b/")
+}
+
+// patchPath returns the path of a file from a header of a diff without prefix.
+// An empty path is returned for /dev/null.
+func patchPath(s, prefix string) string {
+	// diff separates the name from the time of the file with a tab.
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			s = unquoted
+		}
+	}
+
+	if s == "/dev/null" {
+		return ""
+	}
+
+	return strings.TrimPrefix(s, prefix)
+}
+
+// parseHunkHeader returns the numbers of old and new lines of a hunk from its
+// header (e.g. @@ -1,2 +1,3 @@).
+func parseHunkHeader(line string) (oldLines, newLines int, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, fmt.Errorf("malformed hunk header %q", line)
+	}
+
+	count := func(s string) (int, error) {
+		_, n, ok := strings.Cut(s[1:], ",")
+		if !ok {
+			return 1, nil
+		}
+		return strconv.Atoi(n)
+	}
+
+	oldLines, err = count(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+
+	newLines, err = count(fields[2])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+
+	return oldLines, newLines, nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_patchFiles(t *testing.T) {
+	var tests = []struct {
+		desc  string
+		patch string
+		want  map[string]bool
+	}{
+		{
+			desc: "modified file",
+			patch: `diff --git a/foo/foo.go b/foo/foo.go
+index 1234567..89abcde 100644
+--- a/foo/foo.go
++++ b/foo/foo.go
+@@ -1,3 +1,3 @@
+ package foo
+
+-var x = 1
++var x = 2
+`,
+			want: map[string]bool{
+				"foo/foo.go": true,
+			},
+		},
+		{
+			desc: "added and deleted files",
+			patch: `diff --git a/foo/new.go b/foo/new.go
+new file mode 100644
+index 0000000..1234567
+--- /dev/null
++++ b/foo/new.go
+@@ -0,0 +1 @@
++package foo
+diff --git a/bar/old.go b/bar/old.go
+deleted file mode 100644
+index 1234567..0000000
+--- a/bar/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package bar
+`,
+			want: map[string]bool{
+				"foo/new.go": true,
+				"bar/old.go": false,
+			},
+		},
+		{
+			desc: "renamed file",
+			patch: `diff --git a/foo/a.go b/bar/a.go
+similarity index 100%
+rename from foo/a.go
+rename to bar/a.go
+`,
+			want: map[string]bool{
+				"foo/a.go": false,
+				"bar/a.go": true,
+			},
+		},
+		{
+			desc: "empty added file",
+			patch: `diff --git a/foo/empty.go b/foo/empty.go
+new file mode 100644
+index 0000000..e69de29
+`,
+			want: map[string]bool{
+				"foo/empty.go": true,
+			},
+		},
+		{
+			desc: "quoted paths",
+			patch: `diff --git "a/foo/a b.go" "b/foo/a b.go"
+index 1234567..89abcde 100644
+--- "a/foo/a b.go"
++++ "b/foo/a b.go"
+@@ -1 +1 @@
+-package foo
++package foo // changed
+`,
+			want: map[string]bool{
+				"foo/a b.go": true,
+			},
+		},
+		{
+			desc: "hunk with header-like lines",
+			patch: `diff --git a/foo/foo.txt b/foo/foo.txt
+index 1234567..89abcde 100644
+--- a/foo/foo.txt
++++ b/foo/foo.txt
+@@ -1,2 +1,2 @@
+--- a/bar/bar.go
++++ b/baz/baz.go
+ diff --git a/qux/qux.go b/qux/qux.go
+`,
+			want: map[string]bool{
+				"foo/foo.txt": true,
+			},
+		},
+		{
+			desc: "format-patch",
+			patch: `From 1234567890abcdef1234567890abcdef12345678 Mon Sep 17 00:00:00 2001
+From: Gopher <gopher@example.com>
+Date: Mon, 1 Jan 2024 00:00:00 +0000
+Subject: [PATCH] Change foo
+
+--- a/foo/foo.go is mentioned in the message.
+---
+ foo/foo.go | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/foo/foo.go b/foo/foo.go
+index 1234567..89abcde 100644
+--- a/foo/foo.go
++++ b/foo/foo.go
+@@ -1 +1 @@
+-package foo
++package foo // changed
+--
+2.43.0
+`,
+			want: map[string]bool{
+				"foo/foo.go": true,
+			},
+		},
+		{
+			desc: "unified diff",
+			patch: `--- foo/foo.go	2024-01-01 00:00:00.000000000 +0000
++++ foo/foo.go	2024-01-02 00:00:00.000000000 +0000
+@@ -1 +1 @@
+-package foo
++package foo // changed
+--- /dev/null	1970-01-01 00:00:00.000000000 +0000
++++ bar/bar.go	2024-01-02 00:00:00.000000000 +0000
+@@ -0,0 +1 @@
++package bar
+`,
+			want: map[string]bool{
+				"foo/foo.go": true,
+				"bar/bar.go": true,
+			},
+		},
+		{
+			desc: "file changed by several commits",
+			patch: `diff --git a/foo/foo.go b/foo/foo.go
+index 1234567..89abcde 100644
+--- a/foo/foo.go
++++ b/foo/foo.go
+@@ -1 +1 @@
+-package foo
++package foo // changed
+diff --git a/foo/foo.go b/foo/foo.go
+deleted file mode 100644
+index 89abcde..0000000
+--- a/foo/foo.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package foo // changed
+`,
+			want: map[string]bool{
+				"foo/foo.go": false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := patchFiles(strings.NewReader(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("(-want, +got)\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("malformed hunk header", func(t *testing.T) {
+		if _, err := patchFiles(strings.NewReader("@@ -1,x +1 @@\n")); err == nil {
+			t.Error("expected an error for a malformed hunk header")
+		}
+	})
+}
+
+func TestNewPatchDiffer(t *testing.T) {
+	patch := `diff --git a/foo/a.go b/bar/a.go
+similarity index 90%
+rename from foo/a.go
+rename to bar/a.go
+index 1234567..89abcde 100644
+--- a/foo/a.go
++++ b/bar/a.go
+@@ -1 +1 @@
+-package foo
++package bar
+`
+
+	abs := func(fn string) string {
+		t.Helper()
+		abs, err := filepath.Abs(filepath.FromSlash(fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return abs
+	}
+
+	want := map[string]Directory{
+		abs("foo"): {Exists: false, Files: []string{"a.go"}},
+		abs("bar"): {Exists: false, Files: []string{"a.go"}},
+	}
+
+	d := NewPatchDiffer(strings.NewReader(patch))
+
+	got, err := d.Diff()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Diff() (-want, +got)\n%s", diff)
+	}
+
+	wantFiles := map[string]bool{
+		abs("foo/a.go"): false,
+		abs("bar/a.go"): true,
+	}
+
+	gotFiles, err := d.DiffFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(wantFiles, gotFiles); diff != "" {
+		t.Errorf("DiffFiles() (-want, +got)\n%s", diff)
+	}
+}
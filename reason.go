@@ -0,0 +1,59 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+// A reason is the reason a package was changed according to the differ. The
+// reasons are ordered by precedence: when several reasons apply to a package,
+// the one with the lowest value is reported.
+type reason int
+
+const (
+	// reasonDeleted is the reason of a package whose Go files were deleted.
+	reasonDeleted reason = iota + 1
+	// reasonGoFiles is the reason of a package whose non-test Go files were
+	// changed.
+	reasonGoFiles
+	// reasonEmbeddedFiles is the reason of a package whose embedded files were
+	// changed.
+	reasonEmbeddedFiles
+	// reasonGoMod is the reason of a package whose module's go.mod was
+	// changed.
+	reasonGoMod
+	// reasonGoSum is the reason of a package whose module's checksum in go.sum
+	// was changed.
+	reasonGoSum
+	// reasonTestFiles is the reason of a package of which only the tests are
+	// affected by the changes (e.g. its _test.go files or files in its testdata
+	// directory were changed).
+	reasonTestFiles
+)
+
+func (r reason) String() string {
+	switch r {
+	case reasonDeleted:
+		return "deleted"
+	case reasonGoFiles:
+		return "changed go files"
+	case reasonEmbeddedFiles:
+		return "changed embedded files"
+	case reasonGoMod:
+		return "changed go.mod"
+	case reasonGoSum:
+		return "changed go.sum"
+	case reasonTestFiles:
+		return "changed test files"
+	}
+	return ""
+}
+
+// markReason records r as the reason importPath was changed in reasons unless
+// a reason with a higher precedence was already recorded.
+func markReason(reasons map[string]reason, importPath string, r reason) {
+	if old, ok := reasons[importPath]; !ok || r < old {
+		reasons[importPath] = r
+	}
+}
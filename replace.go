@@ -0,0 +1,90 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// checkReplaceCycle returns an error that describes the cycle when the replace
+// directives of the go.mod file fn replace modules by each other (e.g. a
+// module a is replaced by the directory of a module b, and b by the directory
+// of a). The go command reports such cycles as confusing errors about
+// mismatched module paths, if at all. A replacement by a directory is
+// considered to be a replacement by the module that the go.mod in the
+// directory declares.
+func checkReplaceCycle(fn string) error {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	// modfile.ParseLax ignores replace directives, which only apply to the
+	// main module.
+	f, err := modfile.Parse(fn, b, nil)
+	if err != nil {
+		// the go command reports malformed go.mod files.
+		return nil
+	}
+
+	// replacedBy maps module paths to the paths of the modules that replace
+	// them.
+	replacedBy := make(map[string]string)
+	for _, r := range f.Replace {
+		to := r.New.Path
+		if modfile.IsDirectoryPath(to) {
+			dir := to
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(fn), filepath.FromSlash(dir))
+			}
+
+			gomod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+			if err != nil {
+				// the go command reports replacement directories without a
+				// go.mod.
+				continue
+			}
+			to = modfile.ModulePath(gomod)
+		}
+
+		if to != "" && to != r.Old.Path {
+			replacedBy[r.Old.Path] = to
+		}
+	}
+
+	// look for cycles in the order of the directives so that the same cycle is
+	// always reported the same way.
+	for _, r := range f.Replace {
+		start := r.Old.Path
+		cycle := []string{start}
+		seen := map[string]struct{}{start: {}}
+		for p := replacedBy[start]; p != ""; p = replacedBy[p] {
+			cycle = append(cycle, p)
+			if p == start {
+				return fmt.Errorf("replace directives in %s form a cycle: %s", fn, strings.Join(cycle, " => "))
+			}
+			if _, ok := seen[p]; ok {
+				// the cycle does not include start, and it is reported when
+				// starting from one of its modules.
+				break
+			}
+			seen[p] = struct{}{}
+		}
+	}
+
+	return nil
+}
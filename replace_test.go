@@ -0,0 +1,122 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckReplaceCycle(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files map[string]string
+		// want is a substring of the error; no error is expected when it is
+		// empty.
+		want string
+	}{
+		{
+			desc: "local replacements",
+			files: map[string]string{
+				"a/go.mod": "module example.com/a\n\nreplace example.com/b => ../b\n",
+				"b/go.mod": "module example.com/b\n",
+			},
+		},
+		{
+			desc: "local cycle",
+			files: map[string]string{
+				"a/go.mod": "module example.com/a\n\nreplace (\n\texample.com/b => ../c\n\texample.com/c => ../b\n)\n",
+				"b/go.mod": "module example.com/b\n",
+				"c/go.mod": "module example.com/c\n",
+			},
+			want: "form a cycle: example.com/b => example.com/c => example.com/b",
+		},
+		{
+			desc: "module cycle",
+			files: map[string]string{
+				"a/go.mod": "module example.com/a\n\nreplace example.com/b v1.0.0 => example.com/c v1.0.0\nreplace example.com/c => example.com/d v1.0.0\nreplace example.com/d => example.com/b v1.0.0\n",
+			},
+			want: "form a cycle: example.com/b => example.com/c => example.com/d => example.com/b",
+		},
+		{
+			desc: "quoted paths and comments",
+			files: map[string]string{
+				"a/go.mod": "module example.com/a\n\nreplace \"example.com/b\" v1.0.0 => example.com/c v1.0.0 // comment\n\nreplace (\n\texample.com/c => \"example.com/b\" v1.0.0\n\t// example.com/b => example.com/d v1.0.0\n)\n",
+			},
+			want: "form a cycle: example.com/b => example.com/c => example.com/b",
+		},
+		{
+			desc: "chain",
+			files: map[string]string{
+				"a/go.mod": "module example.com/a\n\nreplace example.com/b => example.com/c v1.0.0\nreplace example.com/c => example.com/d v1.0.0\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			for fn, contents := range tt.files {
+				fn = filepath.Join(dir, filepath.FromSlash(fn))
+				if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := checkReplaceCycle(filepath.Join(dir, "a", "go.mod"))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("err = %v; want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("err = %v; want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPackager_ReplaceCycle(t *testing.T) {
+	dir := t.TempDir()
+	defer Setenv(t, "GO111MODULE", "on")()
+	defer Setenv(t, "GOWORK", "off")()
+	defer Setenv(t, "GOFLAGS", "")()
+
+	files := map[string]string{
+		"a/go.mod": "module example.com/a\n\ngo 1.18\n\nrequire example.com/b v0.0.0\n\nreplace (\n\texample.com/b => ../c\n\texample.com/c => ../b\n)\n",
+		"a/a.go":   "package a\n\nimport _ \"example.com/b\"\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.18\n",
+		"b/b.go":   "package b\n",
+		"c/go.mod": "module example.com/c\n\ngo 1.18\n",
+		"c/c.go":   "package c\n",
+	}
+	for fn, contents := range files {
+		fn = filepath.Join(dir, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popd := chdir(t, filepath.Join(dir, "a"))
+	defer popd()
+
+	_, err := NewPackager(nil, nil).DependentGraph()
+	if err == nil {
+		t.Fatal("err = nil; want an error about the replace cycle")
+	}
+
+	want := "form a cycle: example.com/b => example.com/c => example.com/b"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("err = %v; want an error containing %q", err, want)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import "strings"
+
+// stripPrefix removes prefix from the import paths in p. The directories of
+// the packages are not changed.
+func stripPrefix(p *Packages, prefix string) {
+	if p.Dependencies != nil {
+		dependencies := make(map[string][]Package, len(p.Dependencies))
+		for changed, pkgs := range p.Dependencies {
+			dependencies[stripImportPath(changed, prefix)] = stripPackageList(pkgs, prefix)
+		}
+		p.Dependencies = dependencies
+	}
+
+	p.Changes = stripPackageList(p.Changes, prefix)
+	p.AllChanges = stripPackageList(p.AllChanges, prefix)
+	p.TestOnlyChanges = stripPackageList(p.TestOnlyChanges, prefix)
+
+	for i := range p.Moves {
+		p.Moves[i][0].ImportPath = stripImportPath(p.Moves[i][0].ImportPath, prefix)
+		p.Moves[i][1].ImportPath = stripImportPath(p.Moves[i][1].ImportPath, prefix)
+	}
+
+	if p.Reasons != nil {
+		reasons := make(map[string]string, len(p.Reasons))
+		for importPath, reason := range p.Reasons {
+			reasons[stripImportPath(importPath, prefix)] = reason
+		}
+		p.Reasons = reasons
+	}
+}
+
+// stripPackageList returns a copy of pkgs with prefix removed from their
+// import paths.
+func stripPackageList(pkgs []Package, prefix string) []Package {
+	if pkgs == nil {
+		return nil
+	}
+
+	stripped := make([]Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		pkg.ImportPath = stripImportPath(pkg.ImportPath, prefix)
+		stripped = append(stripped, pkg)
+	}
+	return stripped
+}
+
+// stripImportPath returns importPath relative to the import path prefix. The
+// import path of prefix itself is ".", and import paths that are not below
+// prefix are returned unchanged.
+func stripImportPath(importPath, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return importPath
+	}
+
+	if importPath == prefix {
+		return "."
+	}
+	if rel := strings.TrimPrefix(importPath, prefix+"/"); rel != importPath {
+		return rel
+	}
+	return importPath
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStripImportPath(t *testing.T) {
+	tests := []struct {
+		importPath string
+		prefix     string
+		want       string
+	}{
+		{importPath: "github.com/acme/monorepo/services/x", prefix: "github.com/acme/monorepo", want: "services/x"},
+		{importPath: "github.com/acme/monorepo/services/x", prefix: "github.com/acme/monorepo/", want: "services/x"},
+		{importPath: "github.com/acme/monorepo", prefix: "github.com/acme/monorepo", want: "."},
+		{importPath: "github.com/acme/monorepo2/x", prefix: "github.com/acme/monorepo", want: "github.com/acme/monorepo2/x"},
+		{importPath: "example.com/other", prefix: "github.com/acme/monorepo", want: "example.com/other"},
+		{importPath: "github.com/acme/monorepo/x", prefix: "", want: "github.com/acme/monorepo/x"},
+	}
+
+	for _, tt := range tests {
+		if got := stripImportPath(tt.importPath, tt.prefix); got != tt.want {
+			t.Errorf("stripImportPath(%q, %q) = %q; want %q", tt.importPath, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestGTA_StripPrefix(t *testing.T) {
+	// services/a depends on services/b, which depends on example.com/lib.
+	difr := &testDiffer{
+		diff: map[string]Directory{
+			"dirLib": Directory{Exists: true, Files: []string{"lib.go"}},
+		},
+	}
+
+	pkgr := &testPackager{
+		dirs2Imports: map[string]string{
+			"dirA":   "github.com/acme/monorepo/services/a",
+			"dirB":   "github.com/acme/monorepo/services/b",
+			"dirLib": "example.com/lib",
+		},
+		graph: &Graph{
+			graph: map[string]map[string]bool{
+				"example.com/lib": map[string]bool{
+					"github.com/acme/monorepo/services/b": true,
+				},
+				"github.com/acme/monorepo/services/b": map[string]bool{
+					"github.com/acme/monorepo/services/a": true,
+				},
+			},
+		},
+		errs: make(map[string]error),
+	}
+
+	gta, err := New(SetDiffer(difr), SetPackager(pkgr), SetStripPrefix("github.com/acme/monorepo"), SetIncludeReasons(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := gta.ChangedPackages()
+	if err != nil {
+		t.Fatalf("err = %q; want nil", err)
+	}
+
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"example.com/lib": []Package{
+				Package{ImportPath: "services/a"},
+				Package{ImportPath: "services/b"},
+			},
+		},
+		Changes: []Package{
+			Package{ImportPath: "example.com/lib"},
+		},
+		AllChanges: []Package{
+			Package{ImportPath: "example.com/lib"},
+			Package{ImportPath: "services/a"},
+			Package{ImportPath: "services/b"},
+		},
+		Reasons: map[string]string{
+			"example.com/lib": "changed go files",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	p := &Packages{
+		Dependencies: map[string][]Package{
+			"github.com/acme/monorepo/b": []Package{
+				Package{ImportPath: "github.com/acme/monorepo/a", Dir: "/src/a"},
+			},
+		},
+		Changes: []Package{
+			Package{ImportPath: "github.com/acme/monorepo/b", Dir: "/src/b"},
+			Package{ImportPath: "github.com/acme/monorepo/old"},
+		},
+		AllChanges: []Package{
+			Package{ImportPath: "github.com/acme/monorepo/a", Dir: "/src/a"},
+			Package{ImportPath: "github.com/acme/monorepo/b", Dir: "/src/b"},
+			Package{ImportPath: "github.com/acme/monorepo/new", Dir: "/src/new"},
+			Package{ImportPath: "github.com/acme/monorepo/old"},
+		},
+		Moves: [][2]Package{
+			{
+				Package{ImportPath: "github.com/acme/monorepo/old"},
+				Package{ImportPath: "github.com/acme/monorepo/new", Dir: "/src/new"},
+			},
+		},
+		TestOnlyChanges: []Package{
+			Package{ImportPath: "github.com/acme/monorepo/a", Dir: "/src/a"},
+		},
+		Reasons: map[string]string{
+			"github.com/acme/monorepo/b":   "changed go files",
+			"github.com/acme/monorepo/old": "deleted",
+		},
+	}
+
+	stripPrefix(p, "github.com/acme/monorepo")
+
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"b": []Package{
+				Package{ImportPath: "a", Dir: "/src/a"},
+			},
+		},
+		Changes: []Package{
+			Package{ImportPath: "b", Dir: "/src/b"},
+			Package{ImportPath: "old"},
+		},
+		AllChanges: []Package{
+			Package{ImportPath: "a", Dir: "/src/a"},
+			Package{ImportPath: "b", Dir: "/src/b"},
+			Package{ImportPath: "new", Dir: "/src/new"},
+			Package{ImportPath: "old"},
+		},
+		Moves: [][2]Package{
+			{
+				Package{ImportPath: "old"},
+				Package{ImportPath: "new", Dir: "/src/new"},
+			},
+		},
+		TestOnlyChanges: []Package{
+			Package{ImportPath: "a", Dir: "/src/a"},
+		},
+		Reasons: map[string]string{
+			"b":   "changed go files",
+			"old": "deleted",
+		},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+
+	b, err := p.MarshalJSONFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTripped := new(Packages)
+	if err := json.Unmarshal(b, roundTripped); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(p, roundTripped); diff != "" {
+		t.Errorf("JSON round trip (-want, +got)\n%s", diff)
+	}
+}
@@ -0,0 +1,5 @@
+//go:build constrained
+
+package constrainedlib
+
+func Use() {}
@@ -0,0 +1,7 @@
+package constrainedlibclient
+
+import "gta.test/constrainedlib"
+
+func Use() {
+	constrainedlib.Use()
+}
@@ -0,0 +1,12 @@
+package embedbroken
+
+import (
+	_ "embed"
+
+	"gta.test/doesnotexist"
+)
+
+//go:embed files/asset
+var asset string
+
+var _ = doesnotexist.V
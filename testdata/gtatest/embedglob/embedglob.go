@@ -0,0 +1,6 @@
+package embedglob
+
+import "embed"
+
+//go:embed assets/*
+var Assets embed.FS
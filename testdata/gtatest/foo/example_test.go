@@ -0,0 +1,12 @@
+package foo_test
+
+import (
+	"fmt"
+
+	"gta.test/foo"
+)
+
+func ExampleV() {
+	fmt.Println(foo.V{})
+	// Output: {}
+}
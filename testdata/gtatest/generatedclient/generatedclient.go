@@ -0,0 +1,8 @@
+package generatedclient
+
+import "gta.test/generated"
+
+func Use() {
+	generated.Handwritten()
+	generated.Generated()
+}
@@ -0,0 +1,3 @@
+package otheros
+
+func Use() {}
@@ -0,0 +1,3 @@
+package testhelper
+
+func Help() {}
@@ -0,0 +1,3 @@
+package testhelperclient
+
+type V struct{}
@@ -0,0 +1,11 @@
+package testhelperclient_test
+
+import (
+	"testing"
+
+	"gta.test/testhelper"
+)
+
+func TestV(t *testing.T) {
+	testhelper.Help()
+}
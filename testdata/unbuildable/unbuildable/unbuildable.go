@@ -0,0 +1,8 @@
+// Package unbuildable cannot be parsed, because its import declaration is
+// missing its closing parenthesis.
+package unbuildable
+
+import (
+	"fmt"
+
+var V = fmt.Sprint("unbuildable")
@@ -0,0 +1,5 @@
+package unbuildableclient
+
+import "gta.test/unbuildable"
+
+var V = unbuildable.V
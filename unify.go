@@ -0,0 +1,102 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unifyTestPackages replaces the import paths of external test packages in p
+// with the import paths of the packages they test, so that a package and its
+// external test package are reported as a single package.
+func unifyTestPackages(p *Packages) {
+	dirs := make(map[string]string, len(p.AllChanges))
+	for _, pkg := range p.AllChanges {
+		dirs[pkg.ImportPath] = pkg.Dir
+	}
+
+	dependencies := make(map[string][]Package, len(p.Dependencies))
+	for changed, pkgs := range p.Dependencies {
+		changed = unifyTestImportPath(changed, dirs[changed])
+		for _, pkg := range unifyTestPackageList(pkgs) {
+			// a package is not its own dependent.
+			if pkg.ImportPath != changed {
+				dependencies[changed] = append(dependencies[changed], pkg)
+			}
+		}
+	}
+	for changed, pkgs := range dependencies {
+		dependencies[changed] = unifyTestPackageList(pkgs)
+	}
+
+	p.Dependencies = dependencies
+	p.Changes = unifyTestPackageList(p.Changes)
+	p.AllChanges = unifyTestPackageList(p.AllChanges)
+	p.TestOnlyChanges = unifyTestPackageList(p.TestOnlyChanges)
+
+	// the reason of a package takes precedence over the reason of its external
+	// test package.
+	if p.Reasons != nil {
+		reasons := make(map[string]string, len(p.Reasons))
+		for importPath, reason := range p.Reasons {
+			unified := unifyTestImportPath(importPath, dirs[importPath])
+			if _, ok := reasons[unified]; !ok || unified == importPath {
+				reasons[unified] = reason
+			}
+		}
+		p.Reasons = reasons
+	}
+}
+
+// unifyTestPackageList returns pkgs with the import paths of external test
+// packages replaced by the import paths of the packages they test. The
+// returned packages are unique and sorted by import path.
+func unifyTestPackageList(pkgs []Package) []Package {
+	if pkgs == nil {
+		return nil
+	}
+
+	unified := make([]Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		pkg.ImportPath = unifyTestImportPath(pkg.ImportPath, pkg.Dir)
+		unified = append(unified, pkg)
+	}
+
+	// keep the first of the packages with the same import path. pkgs is sorted
+	// by import path, so a package precedes its external test package and is
+	// preferred over it.
+	sort.SliceStable(unified, func(i, j int) bool {
+		return unified[i].ImportPath < unified[j].ImportPath
+	})
+
+	n := 0
+	for i, pkg := range unified {
+		if i > 0 && pkg.ImportPath == unified[n-1].ImportPath {
+			continue
+		}
+		unified[n] = pkg
+		n++
+	}
+
+	return unified[:n]
+}
+
+// unifyTestImportPath returns the import path of the package that the
+// external test package identified by importPath tests. dir is the directory
+// of the package. importPath is returned as is when it is not the import path
+// of an external test package, or when dir is not known.
+func unifyTestImportPath(importPath, dir string) string {
+	base := path.Base(importPath)
+	if dir == "" || !strings.HasSuffix(base, "_test") || filepath.Base(dir) == base {
+		return importPath
+	}
+
+	return path.Join(path.Dir(importPath), strings.TrimSuffix(base, "_test"))
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2016 The gta AUTHORS. All rights reserved.
+
+Use of this source code is governed by the Apache 2 license that can be found
+in the LICENSE file.
+*/
+package gta
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnifyTestPackages(t *testing.T) {
+	// foo_test is the external test package of foo and depends on bar.
+	// bar_test is a package in a directory named bar_test.
+	got := &Packages{
+		Dependencies: map[string][]Package{
+			"example.com/bar": []Package{
+				{ImportPath: "example.com/foo", Dir: "/src/foo"},
+				{ImportPath: "example.com/foo_test", Dir: "/src/foo"},
+			},
+			"example.com/foo_test": []Package{
+				{ImportPath: "example.com/foo", Dir: "/src/foo"},
+			},
+			"example.com/bar_test": []Package{
+				{ImportPath: "example.com/deleted_test"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "example.com/bar", Dir: "/src/bar"},
+			{ImportPath: "example.com/bar_test", Dir: "/src/bar_test"},
+			{ImportPath: "example.com/foo_test", Dir: "/src/foo"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "example.com/bar", Dir: "/src/bar"},
+			{ImportPath: "example.com/bar_test", Dir: "/src/bar_test"},
+			{ImportPath: "example.com/deleted_test"},
+			{ImportPath: "example.com/foo", Dir: "/src/foo"},
+			{ImportPath: "example.com/foo_test", Dir: "/src/foo"},
+		},
+		Reasons: map[string]string{
+			"example.com/bar":      "changed go files",
+			"example.com/bar_test": "changed go files",
+			"example.com/foo_test": "changed test files",
+		},
+	}
+
+	want := &Packages{
+		Dependencies: map[string][]Package{
+			"example.com/bar": []Package{
+				{ImportPath: "example.com/foo", Dir: "/src/foo"},
+			},
+			"example.com/bar_test": []Package{
+				{ImportPath: "example.com/deleted_test"},
+			},
+		},
+		Changes: []Package{
+			{ImportPath: "example.com/bar", Dir: "/src/bar"},
+			{ImportPath: "example.com/bar_test", Dir: "/src/bar_test"},
+			{ImportPath: "example.com/foo", Dir: "/src/foo"},
+		},
+		AllChanges: []Package{
+			{ImportPath: "example.com/bar", Dir: "/src/bar"},
+			{ImportPath: "example.com/bar_test", Dir: "/src/bar_test"},
+			{ImportPath: "example.com/deleted_test"},
+			{ImportPath: "example.com/foo", Dir: "/src/foo"},
+		},
+		Reasons: map[string]string{
+			"example.com/bar":      "changed go files",
+			"example.com/bar_test": "changed go files",
+			"example.com/foo":      "changed test files",
+		},
+	}
+
+	unifyTestPackages(got)
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("(-want, +got)\n%s", diff)
+	}
+}